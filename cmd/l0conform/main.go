@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm/conformance"
+	"github.com/robotalks/robo.go/pkg/l0/comm/serial"
+)
+
+var (
+	conf      = conformance.DefaultConfig()
+	echoCode  = uint(conf.EchoCode)
+	scenarios string
+	listOnly  bool
+)
+
+func init() {
+	flag.UintVar(&echoCode, "echo", echoCode, "L0 command code of echo implemented by the peer.")
+	flag.BoolVar(&conf.CRC, "crc", conf.CRC, "Peer uses CRC-8 on packets.")
+	flag.DurationVar(&conf.ReplyTimeout, "timeout", conf.ReplyTimeout, "Max time waiting for a response.")
+	flag.DurationVar(&conf.PeerTimeout, "peer-timeout", conf.PeerTimeout, "Sync timeout of the peer.")
+	flag.StringVar(&scenarios, "run", scenarios, "Comma separated scenarios to run, all if empty.")
+	flag.BoolVar(&listOnly, "list", listOnly, "List scenarios.")
+}
+
+func openPeer(spec string) (io.ReadWriteCloser, error) {
+	if u, err := url.Parse(spec); err == nil && u.Scheme == serial.URLScheme {
+		return serial.OpenURL(spec)
+	}
+	return conformance.OpenPeer(spec)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [OPTIONS] PEER\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "PEER: serial://DEVICE?baud=N (including pty), tcp://HOST:PORT or exec:CMD [ARGS...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(log.Lmicroseconds)
+
+	if listOnly {
+		for _, s := range conformance.Scenarios {
+			fmt.Printf("%-20s %s\n", s.Name, s.Description)
+		}
+		return
+	}
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	conf.EchoCode = byte(echoCode)
+
+	var selected []*conformance.Scenario
+	if scenarios != "" {
+		for _, name := range strings.Split(scenarios, ",") {
+			s := conformance.Find(strings.TrimSpace(name))
+			if s == nil {
+				log.Fatalf("unknown scenario %q", name)
+			}
+			selected = append(selected, s)
+		}
+	}
+
+	peer, err := openPeer(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer peer.Close()
+
+	failed := 0
+	conformance.Run(peer, conf, selected, func(r *conformance.Result) {
+		if r.Passed() {
+			fmt.Printf("PASS %-20s %v\n", r.Scenario.Name, r.Duration.Round(time.Millisecond))
+			return
+		}
+		failed++
+		fmt.Printf("FAIL %-20s %v: %v\n", r.Scenario.Name, r.Duration.Round(time.Millisecond), r.Err)
+	})
+	if failed > 0 {
+		peer.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robotalks/robo.go/pkg/l0/capture"
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+var (
+	useCRC    bool
+	timeout   = 100 * time.Millisecond
+	direction = "both"
+	verbose   bool
+)
+
+func init() {
+	flag.BoolVar(&useCRC, "crc", useCRC, "Packets are followed by CRC-8.")
+	flag.DurationVar(&timeout, "timeout", timeout, "Sync timeout to simulate, 0 to disable.")
+	flag.StringVar(&direction, "dir", direction, "Direction to decode: recv, send or both.")
+	flag.BoolVar(&verbose, "v", verbose, "Dump every byte.")
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [OPTIONS] CAPTURE-FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var dirFilter func(capture.Direction) bool
+	switch direction {
+	case "both":
+		dirFilter = func(capture.Direction) bool { return true }
+	case "recv":
+		dirFilter = func(d capture.Direction) bool { return d == capture.DirRecv }
+	case "send":
+		dirFilter = func(d capture.Direction) bool { return d == capture.DirSend }
+	default:
+		log.Fatalf("invalid direction: %s", direction)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+	r, err := capture.NewReader(f)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Printf("capture started at %s\n", r.Start().Format(time.RFC3339Nano))
+	opts := capture.ReplayOptions{CRC: useCRC, Timeout: timeout}
+	replayer, err := capture.Replay(r, opts, func(ev *capture.ReplayEvent) {
+		if !dirFilter(ev.Dir) {
+			return
+		}
+		if line := describe(ev); line != "" {
+			fmt.Printf("%12.6f %s %s\n", ev.Time.Sub(r.Start()).Seconds(), ev.Dir, line)
+		}
+	})
+	if err != nil {
+		log.Printf("capture error: %v", err)
+	}
+	for _, dir := range []capture.Direction{capture.DirRecv, capture.DirSend} {
+		if dirFilter(dir) {
+			fmt.Printf("%s stats: %+v\n", dir, replayer.Parser(dir).Stats())
+		}
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func describe(ev *capture.ReplayEvent) string {
+	var items []string
+	if ev.Timeout {
+		items = append(items, "TIMEOUT")
+	} else if verbose {
+		items = append(items, fmt.Sprintf("byte=%02x", ev.Byte))
+	}
+	switch ev.Sync {
+	case comm.SyncREQ:
+		items = append(items, "resync")
+	case comm.SyncACK:
+		items = append(items, "sync-ack")
+	}
+	if ev.Changed || (verbose && len(items) > 0) {
+		items = append(items, "state="+stateString(ev.State))
+	}
+	if ev.Packet != nil {
+		items = append(items, packetString(ev.Dir, ev.Packet))
+	}
+	return strings.Join(items, " ")
+}
+
+func stateString(state comm.SyncState) string {
+	var s string
+	if state.IsReady() {
+		s = "ready"
+	} else {
+		s = "syncing"
+	}
+	if state.IsReceiving() {
+		s += "+receiving"
+	}
+	return s
+}
+
+func packetString(dir capture.Direction, pkt *comm.Packet) string {
+	s := fmt.Sprintf("seq=%02x code=%02x len=%d", byte(pkt.Seq), pkt.Code, len(pkt.Data))
+	switch {
+	case pkt.Code == comm.FragmentCode:
+		s = "FRAG " + s
+	case pkt.Code&0x80 != 0:
+		s = "EVENT " + s
+	case dir == capture.DirSend:
+		s = "CMD " + s
+	case len(pkt.Data) > 0:
+		s = fmt.Sprintf("REPLY %s req=%02x result=%02x", s, pkt.Data[0], pkt.Code&0x7e)
+		if pkt.Code&1 != 0 {
+			s += " error"
+		}
+	default:
+		s = "REPLY(invalid) " + s
+	}
+	if len(pkt.Data) > 0 {
+		s += fmt.Sprintf(" data=% x", pkt.Data)
+	}
+	return s
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l0/comm/serial"
+	"github.com/robotalks/robo.go/pkg/l0/firmware"
+)
+
+var (
+	linkURL   = "serial:///dev/ttyUSB0"
+	code      = uint(firmware.DefaultCode)
+	chunkSize int
+	timeout   = time.Second
+	retries   = 3
+	infoOnly  bool
+)
+
+func init() {
+	if val := os.Getenv("ROBO_L0_URL"); val != "" {
+		linkURL = val
+	}
+	flag.StringVar(&linkURL, "link", linkURL, "L0 link URL: serial://DEVICE?baud=N, tcp://HOST:PORT or ws://HOST:PORT/PATH.")
+	flag.UintVar(&code, "code", code, "L0 command code of firmware update.")
+	flag.IntVar(&chunkSize, "chunk", chunkSize, "Chunk size, 0 for the max supported by bootloader.")
+	flag.DurationVar(&timeout, "timeout", timeout, "Timeout of each command.")
+	flag.IntVar(&retries, "retries", retries, "Max retries of each command.")
+	flag.BoolVar(&infoOnly, "info", infoOnly, "Only query the bootloader.")
+}
+
+func openLink(rawURL string) (*comm.FIFO, io.Closer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme == "serial" {
+		conf, err := serial.ParseURL(rawURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conf.NewFIFO()
+	}
+	return comm.Dial(rawURL)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [OPTIONS] IMAGE-FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(log.Lmicroseconds)
+	if flag.NArg() != 1 && !infoOnly {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var image []byte
+	if !infoOnly {
+		var err error
+		if image, err = ioutil.ReadFile(flag.Arg(0)); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	fifo, closer, err := openLink(linkURL)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer closer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	client := comm.NewClient(fifo)
+	readyCh := make(chan struct{})
+	go func(ready chan struct{}) {
+		for state := range client.StateChan() {
+			if state.IsReady() && ready != nil {
+				close(ready)
+				ready = nil
+			}
+		}
+	}(readyCh)
+	go func() {
+		if err := client.Run(ctx); err != nil && err != context.Canceled {
+			log.Fatalln(err)
+		}
+	}()
+	select {
+	case <-readyCh:
+	case <-time.After(5 * time.Second):
+		log.Fatalln("link not ready")
+	case <-ctx.Done():
+		log.Fatalln(ctx.Err())
+	}
+
+	updater := firmware.NewUpdater(client)
+	updater.Code = byte(code)
+	updater.ChunkSize = chunkSize
+	updater.Options.Timeout = timeout
+	updater.Options.Retry.MaxRetries = retries
+
+	info, err := updater.Hello(ctx)
+	if err != nil {
+		log.Fatalf("bootloader handshake: %v", err)
+	}
+	log.Printf("bootloader version %d, max chunk %d, max image size %d",
+		info.Version, info.MaxChunk, info.MaxSize)
+	if infoOnly {
+		return
+	}
+
+	updater.Progress = func(offset, size int) {
+		fmt.Fprintf(os.Stderr, "\r%d/%d bytes (%d%%)", offset, size, offset*100/size)
+	}
+	err = updater.Update(ctx, image)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Fatalf("update failed: %v", err)
+	}
+	log.Printf("image of %d bytes flashed, rebooting", len(image))
+}
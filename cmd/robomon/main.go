@@ -11,6 +11,7 @@ import (
 	"github.com/robotalks/robo.go/pkg/l1/msgs"
 
 	_ "github.com/robotalks/robo.go/pkg/joystick/msgs"
+	_ "github.com/robotalks/robo.go/pkg/l0/msgs"
 )
 
 var (
@@ -4,6 +4,7 @@ package main
 
 import (
 	"flag"
+	"log"
 
 	fx "github.com/robotalks/robo.go/pkg/framework"
 	"github.com/robotalks/robo.go/pkg/l1"
@@ -43,7 +44,13 @@ func main() {
 	})
 	vis.Subscribe(bot)
 
-	fx.NewLoop().
-		Add(env, bot, vis).
-		RunOrFail()
+	loop := fx.NewLoop().Add(env, bot, vis)
+	err := fx.NewRunner().
+		HandleSignals().
+		ShutdownWith(loop.Shutdown).
+		Go(loop).
+		Wait()
+	if err != nil {
+		log.Fatalln(err)
+	}
 }
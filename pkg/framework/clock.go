@@ -0,0 +1,114 @@
+package framework
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the time and tickers to drive a Loop.
+type Clock interface {
+	// Now gets the current time.
+	Now() time.Time
+	// NewTicker creates a Ticker with the specified period.
+	NewTicker(period time.Duration) Ticker
+}
+
+// Ticker delivers ticks of a Clock.
+type Ticker interface {
+	// C gets the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// ClockAdvancer is implemented by a Clock which can be moved forward,
+// e.g. SimClock.
+type ClockAdvancer interface {
+	Advance(time.Duration)
+}
+
+// RealClock is the Clock using system time.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(period time.Duration) Ticker {
+	return realTicker{Ticker: time.NewTicker(period)}
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// SimClock is a simulated Clock which only moves forward when advanced.
+// Like time.Ticker, ticks are dropped if the receiver falls behind.
+type SimClock struct {
+	now     time.Time
+	tickers map[*simTicker]struct{}
+	lock    sync.Mutex
+}
+
+type simTicker struct {
+	clock  *SimClock
+	ch     chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// NewSimClock creates a SimClock starting at the specified time.
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start, tickers: make(map[*simTicker]struct{})}
+}
+
+// Now implements Clock.
+func (c *SimClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// NewTicker implements Clock.
+func (c *SimClock) NewTicker(period time.Duration) Ticker {
+	if period <= 0 {
+		panic("non-positive period for SimClock.NewTicker")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &simTicker{clock: c, ch: make(chan time.Time, 1), period: period, next: c.now.Add(period)}
+	c.tickers[t] = struct{}{}
+	return t
+}
+
+// Advance implements ClockAdvancer. Tickers due in the duration fire.
+func (c *SimClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	for t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.ch <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+func (t *simTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *simTicker) Stop() {
+	t.clock.lock.Lock()
+	delete(t.clock.tickers, t)
+	t.clock.lock.Unlock()
+}
@@ -1,6 +1,9 @@
 package framework
 
-import "strings"
+import (
+	"errors"
+	"strings"
+)
 
 // AggregatedError aggregates multiple errors.
 type AggregatedError struct {
@@ -37,3 +40,10 @@ func (e *AggregatedError) Aggregate() error {
 	}
 	return e
 }
+
+// ErrClockNotAdvanceable indicates the Clock of Loop can't be advanced.
+var ErrClockNotAdvanceable = errors.New("clock not advanceable")
+
+// ErrShutdownBlocked indicates a shutdown handler doesn't return before
+// the deadline of the phase.
+var ErrShutdownBlocked = errors.New("blocked past deadline")
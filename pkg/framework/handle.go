@@ -0,0 +1,251 @@
+package framework
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// ControllerHandle refers to controllers and Runnables registered together
+// to a Loop. It can be used to remove them while the Loop runs.
+//
+// The Runnables attached to a handle run with a sub-context of the Loop,
+// which is canceled when the handle is removed or the Loop stops.
+type ControllerHandle struct {
+	loop      *Loop
+	entries   []*controllerEntry
+	runnables []Runnable
+	ctx       context.Context
+	cancel    context.CancelFunc
+	policy    *PanicPolicy
+	removed   bool
+	wg        sync.WaitGroup
+	done      chan struct{}
+}
+
+// AddController registers controllers to the loop, and returns the handle.
+// It's safe to be called while the loop runs, including from controllers.
+// Controllers implementing Runnable are attached to the handle, and started
+// immediately if the loop is running.
+// Inside a group, the controllers are added to the handle of the group.
+func (l *Loop) AddController(priorityLevel int, ctls ...Controller) *ControllerHandle {
+	if l.group != nil {
+		return l.group.AddController(priorityLevel, ctls...)
+	}
+	return l.newHandle().AddController(priorityLevel, ctls...)
+}
+
+// AddGroup adds LoopAdders as a group, and returns a single handle for
+// all the controllers and Runnables they add. The LoopAdders are given a
+// view of the loop, which adds controllers and Runnables to the handle,
+// and forwards other calls, e.g. OnShutdown, to the loop. A nested group
+// is part of the enclosing group.
+func (l *Loop) AddGroup(adders ...LoopAdder) *ControllerHandle {
+	h := l.group
+	if h == nil {
+		h = l.newHandle()
+	}
+	view := &Loop{parent: h.loop, group: h}
+	view.Add(adders...)
+	return h
+}
+
+// RemoveController removes controllers and stops Runnables of the handle.
+func (l *Loop) RemoveController(h *ControllerHandle) {
+	h.Remove()
+}
+
+func (l *Loop) newHandle() *ControllerHandle {
+	h := &ControllerHandle{loop: l, done: make(chan struct{})}
+	l.lock.Lock()
+	l.handles = append(l.handles, h)
+	if l.runCtx != nil {
+		h.start(l.runCtx)
+	}
+	l.lock.Unlock()
+	return h
+}
+
+// startHandles starts Runnables of all handles, with the lock held.
+func (l *Loop) startHandles(ctx context.Context) {
+	l.runCtx = ctx
+	for _, h := range l.handles {
+		h.start(ctx)
+	}
+}
+
+// stopHandles stops Runnables of all handles and waits for them.
+func (l *Loop) stopHandles() {
+	l.lock.Lock()
+	l.runCtx = nil
+	handles := l.handles
+	for _, h := range handles {
+		h.stop()
+	}
+	l.lock.Unlock()
+	for _, h := range handles {
+		h.wg.Wait()
+	}
+}
+
+// AddController registers more controllers with the handle.
+// It does nothing if the handle is removed.
+func (h *ControllerHandle) AddController(priorityLevel int, ctls ...Controller) *ControllerHandle {
+	entries := make([]*controllerEntry, 0, len(ctls))
+	var runnables []Runnable
+	for _, ctl := range ctls {
+		entries = append(entries, &controllerEntry{
+			ctl:           ctl,
+			name:          controllerName(ctl),
+			priorityLevel: priorityLevel,
+		})
+		if runner, ok := ctl.(Runnable); ok {
+			runnables = append(runnables, runner)
+		}
+	}
+	h.addEntries(entries)
+	return h.AddRunnable(runnables...)
+}
+
+func (h *ControllerHandle) addEntries(entries []*controllerEntry) {
+	l := h.loop
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if h.removed {
+		return
+	}
+	h.entries = append(h.entries, entries...)
+	for _, entry := range entries {
+		entry.handle = h
+		l.controllers[entry.priorityLevel].add(entry)
+	}
+}
+
+// AddRunnable attaches Runnables to the handle.
+// They are started immediately if the loop is running.
+// It does nothing if the handle is removed.
+func (h *ControllerHandle) AddRunnable(runnables ...Runnable) *ControllerHandle {
+	l := h.loop
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if h.removed {
+		return h
+	}
+	h.runnables = append(h.runnables, runnables...)
+	if h.cancel != nil {
+		h.run(runnables)
+	}
+	return h
+}
+
+// Remove removes the controllers from the loop and cancels the context of
+// Runnables. A controller being removed may still run in the current
+// iteration if it has started, but not any more after that.
+func (h *ControllerHandle) Remove() {
+	h.loop.lock.Lock()
+	h.remove()
+	h.loop.lock.Unlock()
+}
+
+// removeEntry removes a controller of the handle with the lock held,
+// and removes the handle with its last controller.
+func (h *ControllerHandle) removeEntry(entry *controllerEntry) {
+	if h.removed {
+		return
+	}
+	for n, e := range h.entries {
+		if e == entry {
+			h.entries = append(h.entries[:n:n], h.entries[n+1:]...)
+			break
+		}
+	}
+	h.loop.controllers[entry.priorityLevel].remove(entry)
+	if len(h.entries) == 0 {
+		h.remove()
+	}
+}
+
+// remove removes the handle with the lock held.
+func (h *ControllerHandle) remove() {
+	l := h.loop
+	if h.removed {
+		return
+	}
+	h.removed = true
+	for n, handle := range l.handles {
+		if handle == h {
+			l.handles = append(l.handles[:n:n], l.handles[n+1:]...)
+			break
+		}
+	}
+	h.stop()
+	for _, entry := range h.entries {
+		l.controllers[entry.priorityLevel].remove(entry)
+	}
+	go func() {
+		h.wg.Wait()
+		close(h.done)
+	}()
+}
+
+// SetPanicPolicy overrides Loop.PanicPolicy for the controllers of the handle.
+func (h *ControllerHandle) SetPanicPolicy(policy PanicPolicy) *ControllerHandle {
+	h.loop.lock.Lock()
+	h.policy = &policy
+	h.loop.lock.Unlock()
+	return h
+}
+
+// Done returns a channel closed when the handle is removed and all
+// Runnables attached have stopped.
+func (h *ControllerHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// start starts all Runnables with the lock held.
+func (h *ControllerHandle) start(ctx context.Context) {
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	h.run(h.runnables)
+}
+
+// stop cancels the context of Runnables with the lock held.
+func (h *ControllerHandle) stop() {
+	if h.cancel != nil {
+		h.cancel()
+		h.ctx, h.cancel = nil, nil
+	}
+}
+
+// run runs Runnables with the lock held.
+func (h *ControllerHandle) run(runnables []Runnable) {
+	for _, runnable := range runnables {
+		h.loop.goRunnable(h.ctx, &h.wg, runnable)
+	}
+}
+
+func (c *controllerList) add(entry *controllerEntry) {
+	c.lock.Lock()
+	ctls := make([]*controllerEntry, len(c.controllers), len(c.controllers)+1)
+	copy(ctls, c.controllers)
+	c.controllers = append(ctls, entry)
+	c.lock.Unlock()
+}
+
+func (c *controllerList) remove(entry *controllerEntry) {
+	atomic.StoreInt32(&entry.removed, 1)
+	c.lock.Lock()
+	ctls := make([]*controllerEntry, 0, len(c.controllers))
+	for _, e := range c.controllers {
+		if e != entry {
+			ctls = append(ctls, e)
+		}
+	}
+	c.controllers = ctls
+	c.lock.Unlock()
+}
+
+func (c *controllerList) snapshot() []*controllerEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.controllers
+}
@@ -2,8 +2,11 @@ package framework
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
@@ -12,15 +15,57 @@ import (
 // Loop manages sensors, controllers, acuators.
 type Loop struct {
 	Interval time.Duration
+	Clock    Clock // drives the loop and ControlContext.Time, default is RealClock
+
+	// Profiling enables measuring execution time of iterations, priority
+	// levels and controllers, see Profile.
+	Profiling        bool
+	ControllerBudget time.Duration  // max execution time of a controller, zero for no budget
+	OverrunHandler   OverrunHandler // notified when Interval or ControllerBudget is exceeded
+
+	// PanicPolicy decides what to do with a controller after it panics,
+	// it can be overridden by ControllerHandle.SetPanicPolicy.
+	PanicPolicy PanicPolicy
+
+	MessageTTL        time.Duration     // default TTL of posted messages, zero for no expiration
+	MaxMessages       int               // max number of queued messages, zero for unlimited
+	Overflow          OverflowPolicy    // the policy when MaxMessages is reached
+	DeadLetterHandler DeadLetterHandler // receives expired, dropped or unconsumed messages
+
+	Tracer Tracer // records iterations if not nil, e.g. TraceWriter
+
+	// Supervisor restarts Runnables added to the loop if not nil.
+	// Run fails when a supervised Runnable fails and is not restarted.
+	Supervisor *Supervisor
+
+	// Shutdown collects graceful shutdown handlers registered by OnShutdown
+	// and AddShutdownController, and it's created on demand if nil.
+	// Set it before adding components to share it, e.g. with a Runner.
+	Shutdown *Shutdown
 
 	controllers [PriorityLevels]controllerList
 
 	runners []Runnable
 
 	messages messageList
+	pending  int
 	lock     sync.Mutex
 
-	wakeUpCh chan struct{}
+	wakeUpCh  chan struct{}
+	profiler  loopProfiler
+	handles   []*ControllerHandle
+	runCtx    context.Context
+	failsafes []FailsafeHandler
+	replaying bool
+	failCh    chan error
+
+	shutdownCtls [PriorityLevels][]Controller
+	shutdownCh   chan shutdownRequest
+	halted       bool // no more iterations after shutdown controllers
+
+	// set on the view of a group given to LoopAdders by AddGroup.
+	parent *Loop
+	group  *ControllerHandle
 }
 
 // LoopAdder provides specific logic to add components to loop.
@@ -38,6 +83,9 @@ type loopIteration struct {
 	time          time.Time
 	priorityLevel int
 	messages      messageList
+	nextID        int
+	trace         *TraceRecord
+	traceCtl      *ControllerTrace
 }
 
 type messageList struct {
@@ -46,8 +94,10 @@ type messageList struct {
 }
 
 type messageItem struct {
-	msg  Message
-	next *messageItem
+	msg      Message
+	expireAt time.Time
+	id       int
+	next     *messageItem
 }
 
 func (l *messageList) append(item *messageItem) {
@@ -59,6 +109,14 @@ func (l *messageList) append(item *messageItem) {
 	l.tail = item
 }
 
+func (l *messageList) pop() *messageItem {
+	item := l.head
+	if item != nil {
+		l.head, item.next = item.next, nil
+	}
+	return item
+}
+
 func (l *messageList) splice(src *messageList) {
 	l.head, l.tail, src.head = src.head, src.tail, nil
 }
@@ -76,11 +134,21 @@ func (l *messageList) concat(lst *messageList) {
 
 type controllerList struct {
 	preHooks    []Controller
-	controllers []Controller
+	controllers []*controllerEntry
 	postHooks   []Controller
 	lock        sync.Mutex
 }
 
+type controllerEntry struct {
+	ctl           Controller
+	name          string
+	priorityLevel int
+	handle        *ControllerHandle
+	stats         TimingStats
+	removed       int32
+	skip          int // iterations to skip after panic, accessed by loop goroutine
+}
+
 var (
 	loopCtxKey = &Loop{}
 )
@@ -108,49 +176,101 @@ func (l *Loop) Add(adders ...LoopAdder) *Loop {
 	return l
 }
 
-// AddController registers controllers to the loop.
-func (l *Loop) AddController(priorityLevel int, ctls ...Controller) *Loop {
-	lst := &l.controllers[priorityLevel]
-	lst.controllers = append(lst.controllers, ctls...)
-	for _, ctl := range ctls {
-		if runner, ok := ctl.(Runnable); ok {
-			l.runners = append(l.runners, runner)
-		}
-	}
-	return l
-}
-
 // AddRunnable adds Runnable implementions.
 func (l *Loop) AddRunnable(runnables ...Runnable) *Loop {
+	if l.group != nil {
+		l.group.AddRunnable(runnables...)
+		return l
+	}
 	l.runners = append(l.runners, runnables...)
 	return l
 }
 
 // Run implements Runnable.
 func (l *Loop) Run(ctx context.Context) error {
-	if l.wakeUpCh == nil {
-		l.wakeUpCh = make(chan struct{}, 1)
+	l.init()
+
+	runCtx, cancel := context.WithCancel(context.WithValue(ctx, loopCtxKey, &loopCtl{l}))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	for _, runnable := range l.runners {
+		l.goRunnable(runCtx, &wg, runnable)
 	}
+	l.lock.Lock()
+	l.startHandles(runCtx)
+	l.lock.Unlock()
+	defer l.stopHandles()
 
-	runner := NewRunnerWith(context.WithValue(ctx, loopCtxKey, &loopCtl{l}))
-	runner.Go(l.runners...)
-	defer runner.Wait()
-
-	interval := l.Interval
-	if interval == 0 {
-		interval = 100 * time.Millisecond
-	}
-	timer := time.Tick(interval)
+	ticker := l.clock().NewTicker(l.interval())
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-timer:
-			l.runIteration(ctx)
+		case err := <-l.failCh:
+			return err
+		case req := <-l.shutdownCh:
+			l.runShutdownIteration(req.ctx)
+			close(req.done)
+		case tick := <-ticker.C():
+			if !l.isHalted() {
+				l.runIteration(ctx, tick)
+			}
+		case <-l.wakeUpCh:
+			if !l.isHalted() {
+				l.runIteration(ctx, time.Time{})
+			}
+		}
+	}
+}
+
+// Step runs n iterations synchronously without starting Runnables.
+// An iteration requested by TriggerNext runs at the current time,
+// otherwise the clock is advanced by Interval before the iteration
+// if it implements ClockAdvancer.
+// It must not be used concurrently with Run.
+func (l *Loop) Step(ctx context.Context, n int) {
+	l.init()
+	advancer, _ := l.clock().(ClockAdvancer)
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		select {
+		case <-l.wakeUpCh:
+		default:
+			if advancer != nil {
+				advancer.Advance(l.interval())
+			}
+		}
+		l.runIteration(ctx, time.Time{})
+	}
+}
+
+// Advance moves the clock forward by d, and runs iterations synchronously
+// as Step when they are due, including the ones requested by TriggerNext.
+// It fails with ErrClockNotAdvanceable if Clock doesn't implement
+// ClockAdvancer. It never returns if every iteration calls TriggerNext.
+// It must not be used concurrently with Run.
+func (l *Loop) Advance(ctx context.Context, d time.Duration) error {
+	advancer, ok := l.clock().(ClockAdvancer)
+	if !ok {
+		return ErrClockNotAdvanceable
+	}
+	l.init()
+	interval := l.interval()
+	for ctx.Err() == nil {
+		select {
 		case <-l.wakeUpCh:
-			l.runIteration(ctx)
+		default:
+			if d < interval {
+				advancer.Advance(d)
+				return nil
+			}
+			advancer.Advance(interval)
+			d -= interval
 		}
+		l.runIteration(ctx, time.Time{})
 	}
+	return ctx.Err()
 }
 
 // RunOrFail is intended to be used in main to simply run the loop.
@@ -160,8 +280,74 @@ func (l *Loop) RunOrFail() {
 	}
 }
 
+func (l *Loop) init() {
+	if l.wakeUpCh == nil {
+		l.wakeUpCh = make(chan struct{}, 1)
+	}
+	if l.failCh == nil {
+		l.failCh = make(chan error, 1)
+	}
+	if l.shutdownCh == nil {
+		l.shutdownCh = make(chan shutdownRequest)
+	}
+}
+
+// RunnableStates gets the states of Runnables supervised by Supervisor,
+// nil without a Supervisor.
+func (l *Loop) RunnableStates() []RunnableState {
+	if l.Supervisor == nil {
+		return nil
+	}
+	return l.Supervisor.States()
+}
+
+// goRunnable runs a Runnable in the background, supervised if Supervisor
+// is set.
+func (l *Loop) goRunnable(ctx context.Context, wg *sync.WaitGroup, runnable Runnable) {
+	supervisor := l.Supervisor
+	wg.Add(1)
+	done := func(err error) {
+		defer wg.Done()
+		if err == nil || err == context.Canceled {
+			return
+		}
+		glog.Errorf("runnable error: %v", err)
+		if supervisor != nil && ctx.Err() == nil {
+			select {
+			case l.failCh <- fmt.Errorf("runnable %s failed: %v", runnableName(runnable), err):
+			default:
+			}
+		}
+	}
+	if supervisor != nil {
+		supervisor.Start(ctx, runnable, done)
+		return
+	}
+	go func() {
+		done(runnable.Run(ctx))
+	}()
+}
+
+func (l *Loop) clock() Clock {
+	if l.Clock != nil {
+		return l.Clock
+	}
+	return RealClock
+}
+
+func (l *Loop) interval() time.Duration {
+	if l.Interval == 0 {
+		return 100 * time.Millisecond
+	}
+	return l.Interval
+}
+
 // PreRunAt implements LoopCtl.
 func (l *Loop) PreRunAt(priorityLevel int, hooks ...Controller) {
+	if l.parent != nil {
+		l.parent.PreRunAt(priorityLevel, hooks...)
+		return
+	}
 	lst := &l.controllers[priorityLevel]
 	lst.lock.Lock()
 	lst.preHooks = append(lst.preHooks, hooks...)
@@ -170,37 +356,65 @@ func (l *Loop) PreRunAt(priorityLevel int, hooks ...Controller) {
 
 // PostRunAt implements LoopCtl.
 func (l *Loop) PostRunAt(priorityLevel int, hooks ...Controller) {
+	if l.parent != nil {
+		l.parent.PostRunAt(priorityLevel, hooks...)
+		return
+	}
 	lst := &l.controllers[priorityLevel]
 	lst.lock.Lock()
 	lst.postHooks = append(lst.postHooks, hooks...)
 	lst.lock.Unlock()
 }
 
-// PostMessage implements LoopCtl.
-func (l *Loop) PostMessage(msg Message) {
-	l.lock.Lock()
-	l.messages.append(&messageItem{msg: msg})
-	l.lock.Unlock()
-}
-
 // TriggerNext implements LoopCtl.
 func (l *Loop) TriggerNext() {
+	if l.parent != nil {
+		l.parent.TriggerNext()
+		return
+	}
 	select {
 	case l.wakeUpCh <- struct{}{}:
 	default:
 	}
 }
 
-func (l *Loop) runIteration(ctx context.Context) {
-	iter := &loopIteration{loopCtl: loopCtl{l}, time: time.Now()}
-	l.lock.Lock()
-	iter.messages.splice(&l.messages)
-	l.lock.Unlock()
+// runIteration runs an iteration, tick is zero if it's not periodic.
+func (l *Loop) runIteration(ctx context.Context, tick time.Time) {
+	iter := &loopIteration{loopCtl: loopCtl{l}, time: l.clock().Now()}
+	var start time.Time
+	if l.Profiling {
+		start = time.Now()
+		if !tick.IsZero() {
+			l.profileTick(tick, iter.time)
+		}
+	}
+	iter.messages = l.takeMessages(iter.time)
+	if l.Tracer != nil {
+		iter.trace = &TraceRecord{Time: iter.time}
+		for item := iter.messages.head; item != nil; item = item.next {
+			item.id = iter.nextID
+			iter.nextID++
+			iter.trace.Messages = append(iter.trace.Messages, TracedMessage{ID: item.id, Msg: item.msg})
+		}
+	}
 	iter.ctx = context.WithValue(ctx, loopCtxKey, iter)
 	for i := 0; i < PriorityLevels; i++ {
 		iter.priorityLevel = i
 		l.controllers[i].run(iter)
 	}
+	if l.DeadLetterHandler != nil {
+		for item := iter.messages.head; item != nil; item = item.next {
+			l.deadLetter(item.msg, DeadLetterUnconsumed)
+		}
+	}
+	if iter.trace != nil {
+		if err := l.Tracer.TraceIteration(iter.trace); err != nil {
+			glog.Errorf("trace error: %v", err)
+		}
+	}
+	if l.Profiling {
+		l.profileIteration(time.Since(start))
+	}
 }
 
 func (t *loopIteration) Context() context.Context {
@@ -239,46 +453,131 @@ func (c *messageContext) AddMessages(msgs ...Message) { c.iter.AddMessages(msgs.
 
 func (t *loopIteration) ProcessMessages(proc MessageProcessor) {
 	var msgs, remains messageList
+	var current *messageContext
 	msgs.splice(&t.messages)
+	// keep the messages not taken in order, also if proc panics.
+	defer func() {
+		if current != nil {
+			t.messageDone(current, &remains)
+		}
+		remains.concat(&msgs)
+		remains.concat(&t.messages)
+		t.messages = remains
+	}()
 	for msgs.head != nil {
-		mctx := &messageContext{iter: t, item: msgs.head}
+		current = &messageContext{iter: t, item: msgs.head}
 		msgs.head = msgs.head.next
-		mctx.item.next = nil
-		proc.ProcessMessage(mctx)
-		if !mctx.taken {
-			remains.append(mctx.item)
-		}
+		current.item.next = nil
+		proc.ProcessMessage(current)
+		mctx := current
+		current = nil
+		t.messageDone(mctx, &remains)
 		if mctx.stop {
-			remains.concat(&msgs)
+			break
 		}
 	}
-	remains.concat(&t.messages)
-	t.messages = remains
+}
+
+// messageDone keeps the message in remains if it's not taken.
+func (t *loopIteration) messageDone(mctx *messageContext, remains *messageList) {
+	if !mctx.taken {
+		remains.append(mctx.item)
+	} else if t.traceCtl != nil {
+		t.traceCtl.Taken = append(t.traceCtl.Taken, mctx.item.id)
+	}
 }
 
 func (t *loopIteration) AddMessages(msgs ...Message) {
 	for _, msg := range msgs {
-		t.messages.append(&messageItem{msg: msg})
+		item := &messageItem{msg: msg, id: t.nextID}
+		t.nextID++
+		t.messages.append(item)
+		if t.traceCtl != nil {
+			t.traceCtl.Added = append(t.traceCtl.Added, TracedMessage{ID: item.id, Msg: msg})
+		}
 	}
 }
 
 func (c *controllerList) run(iter *loopIteration) {
+	var start time.Time
+	if iter.Profiling {
+		start = time.Now()
+	}
 	c.lock.Lock()
 	ctls := c.preHooks
 	c.preHooks = nil
 	c.lock.Unlock()
 	runControllers(iter, ctls)
-	runControllers(iter, c.controllers)
+	for _, entry := range c.snapshot() {
+		if atomic.LoadInt32(&entry.removed) != 0 {
+			continue
+		}
+		if entry.skip > 0 {
+			entry.skip--
+			continue
+		}
+		var ctlStart time.Time
+		if iter.Profiling {
+			ctlStart = time.Now()
+		}
+		if p := runController(iter, entry.ctl); p != nil {
+			iter.handlePanic(iter, p, entry)
+		}
+		if iter.Profiling {
+			iter.profileController(iter.priorityLevel, entry, time.Since(ctlStart))
+		}
+	}
 	c.lock.Lock()
 	ctls, c.postHooks = c.postHooks, nil
 	c.lock.Unlock()
 	runControllers(iter, ctls)
+	if iter.Profiling {
+		iter.profileLevel(iter.priorityLevel, time.Since(start))
+	}
 }
 
 func runControllers(iter *loopIteration, ctls []Controller) {
 	for _, ctl := range ctls {
-		if err := ctl.Control(iter); err != nil {
-			glog.Errorf("controller error: %v", err)
+		if p := runController(iter, ctl); p != nil {
+			iter.handlePanic(iter, p, nil)
+		}
+	}
+}
+
+// runController runs a controller and recovers from panic.
+func runController(iter *loopIteration, ctl Controller) (p *ControllerPanic) {
+	if iter.trace != nil {
+		iter.traceCtl = &ControllerTrace{PriorityLevel: iter.priorityLevel, Name: controllerName(ctl)}
+		defer iter.traceControllerDone(&p)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			p = &ControllerPanic{
+				Controller:    ctl,
+				PriorityLevel: iter.priorityLevel,
+				Value:         r,
+				Stack:         debug.Stack(),
+			}
+		}
+	}()
+	if err := ctl.Control(iter); err != nil {
+		glog.Errorf("controller error: %v", err)
+		if iter.traceCtl != nil {
+			iter.traceCtl.Err = err.Error()
 		}
 	}
+	return nil
+}
+
+// traceControllerDone adds the trace of the controller to the record
+// if it took or added messages or failed.
+func (t *loopIteration) traceControllerDone(p **ControllerPanic) {
+	ctl := t.traceCtl
+	t.traceCtl = nil
+	if *p != nil {
+		ctl.Err = fmt.Sprintf("panic: %v", (*p).Value)
+	}
+	if len(ctl.Taken) > 0 || len(ctl.Added) > 0 || ctl.Err != "" {
+		t.trace.Controllers = append(t.trace.Controllers, *ctl)
+	}
 }
@@ -0,0 +1,474 @@
+package framework
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopStep(t *testing.T) {
+	start := time.Unix(1000, 0)
+	loop := NewLoop()
+	loop.Clock = NewSimClock(start)
+	var times []time.Time
+	count := 0
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		times = append(times, cc.Time())
+		if count++; count == 2 {
+			cc.TriggerNext()
+		}
+		return nil
+	}))
+
+	ctx := context.Background()
+	loop.Step(ctx, 3)
+	require.Equal(t, []time.Time{
+		start.Add(100 * time.Millisecond),
+		start.Add(200 * time.Millisecond),
+		start.Add(200 * time.Millisecond),
+	}, times)
+
+	times = nil
+	require.NoError(t, loop.Advance(ctx, 250*time.Millisecond))
+	require.Equal(t, []time.Time{
+		start.Add(300 * time.Millisecond),
+		start.Add(400 * time.Millisecond),
+	}, times)
+	require.Equal(t, start.Add(450*time.Millisecond), loop.Clock.Now())
+
+	loop.Clock = nil
+	require.Equal(t, ErrClockNotAdvanceable, loop.Advance(ctx, time.Second))
+}
+
+func TestLoopRunSimClock(t *testing.T) {
+	clock := NewSimClock(time.Unix(1000, 0))
+	loop := NewLoop()
+	loop.Clock = clock
+	timeCh := make(chan time.Time)
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		timeCh <- cc.Time()
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- loop.Run(ctx)
+	}()
+
+	select {
+	case <-timeCh:
+		require.Fail(t, "unexpected iteration")
+	case <-time.After(20 * time.Millisecond):
+	}
+	for !clockHasTicker(clock) {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(100 * time.Millisecond)
+	require.Equal(t, time.Unix(1000, 0).Add(100*time.Millisecond), <-timeCh)
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+}
+
+func clockHasTicker(c *SimClock) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.tickers) > 0
+}
+
+type namedController struct {
+	ControlFunc
+	name string
+}
+
+func (c *namedController) Name() string {
+	return c.name
+}
+
+func TestLoopProfile(t *testing.T) {
+	loop := NewLoop()
+	loop.Clock = NewSimClock(time.Unix(1000, 0))
+	loop.Interval = 10 * time.Millisecond
+	loop.Profiling = true
+	loop.ControllerBudget = 5 * time.Millisecond
+	var overruns []Overrun
+	loop.OverrunHandler = HandleOverrunFunc(func(o Overrun) {
+		overruns = append(overruns, o)
+	})
+	slow := &namedController{name: "slow", ControlFunc: func(ControlContext) error {
+		time.Sleep(12 * time.Millisecond)
+		return nil
+	}}
+	loop.AddController(PrLvSense, ControlFunc(func(ControlContext) error { return nil }))
+	loop.AddController(PrLvAcuate, slow)
+	loop.Step(context.Background(), 2)
+
+	p := loop.Profile()
+	require.EqualValues(t, 2, p.Iterations.Count)
+	require.EqualValues(t, 2, p.Iterations.Overruns)
+	require.True(t, p.Iterations.Max >= 12*time.Millisecond)
+	require.EqualValues(t, 2, p.Levels[PrLvAcuate].Count)
+	require.True(t, p.Levels[PrLvAcuate].Mean() >= 12*time.Millisecond)
+	require.Len(t, p.Controllers, 2)
+	require.Equal(t, "framework.ControlFunc", p.Controllers[0].Name)
+	require.Equal(t, PrLvSense, p.Controllers[0].PriorityLevel)
+	require.EqualValues(t, 0, p.Controllers[0].Overruns)
+	require.Equal(t, "slow", p.Controllers[1].Name)
+	require.EqualValues(t, 2, p.Controllers[1].Overruns)
+
+	require.Len(t, overruns, 4)
+	require.Equal(t, "slow", overruns[0].Name)
+	require.Equal(t, PrLvAcuate, overruns[0].PriorityLevel)
+	require.Nil(t, overruns[1].Controller)
+	require.Equal(t, 10*time.Millisecond, overruns[1].Budget)
+}
+
+type runnableController struct {
+	ranCh     chan struct{}
+	startedCh chan struct{}
+}
+
+func (c *runnableController) Control(ControlContext) error {
+	select {
+	case c.ranCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *runnableController) Run(ctx context.Context) error {
+	close(c.startedCh)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestLoopControllerHandle(t *testing.T) {
+	loop := NewLoop()
+	loop.Interval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- loop.Run(ctx)
+	}()
+
+	ctl := &runnableController{ranCh: make(chan struct{}), startedCh: make(chan struct{})}
+	h := loop.AddController(PrLvControl, ctl)
+	<-ctl.startedCh
+	<-ctl.ranCh
+
+	var count int
+	h.AddController(PrLvAcuate, ControlFunc(func(cc ControlContext) error {
+		if count++; count == 2 {
+			h.Remove()
+		}
+		return nil
+	}))
+	select {
+	case <-h.Done():
+	case <-time.After(time.Second):
+		require.Fail(t, "handle not removed")
+	}
+	select {
+	case <-ctl.ranCh:
+		require.Fail(t, "removed controller still runs")
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.Equal(t, 2, count)
+	require.Empty(t, loop.Profile().Controllers)
+
+	h.AddController(PrLvControl, ctl)
+	require.Empty(t, loop.Profile().Controllers)
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+}
+
+func TestLoopAddGroup(t *testing.T) {
+	loop := NewLoop()
+	ctl := &runnableController{ranCh: make(chan struct{}, 1), startedCh: make(chan struct{})}
+	var hookRuns int
+	h := loop.AddGroup(loopAdderFunc(func(l *Loop) {
+		l.AddController(PrLvSense, ControlFunc(func(ControlContext) error { return nil }))
+		l.AddRunnable(&runnableController{startedCh: make(chan struct{})})
+		l.AddGroup(loopAdderFunc(func(l *Loop) {
+			l.AddController(PrLvAcuate, ctl)
+		}))
+		l.PreRunAt(PrLvSense, ControlFunc(func(ControlContext) error {
+			hookRuns++
+			return nil
+		}))
+		l.OnShutdown(ShutdownCloseTransports, "group", ShutdownFunc(func(context.Context) error { return nil }))
+		l.AddFailsafe(FailsafeFunc(func(ControlContext, *ControllerPanic) {}))
+	}))
+	require.Len(t, loop.Profile().Controllers, 2)
+	require.Len(t, h.runnables, 2)
+	require.Len(t, loop.handles, 1)
+	require.Empty(t, loop.runners)
+	require.NotNil(t, loop.Shutdown)
+	require.Len(t, loop.failsafes, 1)
+	loop.Step(context.Background(), 1)
+	<-ctl.ranCh
+	require.Equal(t, 1, hookRuns)
+	h.Remove()
+	require.Empty(t, loop.Profile().Controllers)
+	<-h.Done()
+}
+
+type loopAdderFunc func(*Loop)
+
+func (f loopAdderFunc) AddToLoop(l *Loop) {
+	f(l)
+}
+
+func TestLoopPanic(t *testing.T) {
+	loop := NewLoop()
+	loop.PanicPolicy = PanicPolicy{Action: PanicSkip, SkipIterations: 2}
+	var panics []*ControllerPanic
+	loop.AddFailsafe(FailsafeFunc(func(cc ControlContext, p *ControllerPanic) {
+		panics = append(panics, p)
+	}))
+	var runs, removedRuns, hookRuns int
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		runs++
+		panic("skip")
+	}))
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		removedRuns++
+		panic("remove")
+	})).SetPanicPolicy(PanicPolicy{Action: PanicRemove})
+	loop.PreRunAt(PrLvAcuate, ControlFunc(func(cc ControlContext) error {
+		hookRuns++
+		panic("hook")
+	}))
+	loop.Step(context.Background(), 5)
+
+	require.Equal(t, 2, runs)
+	require.Equal(t, 1, removedRuns)
+	require.Equal(t, 1, hookRuns)
+	require.Len(t, loop.Profile().Controllers, 1)
+	require.Len(t, panics, 4)
+	require.Equal(t, "skip", panics[0].Value)
+	require.Equal(t, PanicSkip, panics[0].Action)
+	require.Equal(t, PrLvControl, panics[0].PriorityLevel)
+	require.NotEmpty(t, panics[0].Stack)
+	require.Equal(t, "remove", panics[1].Value)
+	require.Equal(t, PanicRemove, panics[1].Action)
+	require.Equal(t, "hook", panics[2].Value)
+	require.Equal(t, PanicContinue, panics[2].Action)
+	require.Equal(t, "skip", panics[3].Value)
+}
+
+func TestLoopPanicRemoveStopsRunnables(t *testing.T) {
+	loop := NewLoop()
+	loop.PanicPolicy = PanicPolicy{Action: PanicRemove}
+	var runs int
+	panicking := ControlFunc(func(cc ControlContext) error {
+		runs++
+		panic("remove")
+	})
+	runnable := &runnableController{startedCh: make(chan struct{})}
+	h := loop.AddController(PrLvControl, panicking).AddRunnable(runnable)
+	h.AddController(PrLvAcuate, panicking)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- loop.Run(ctx)
+	}()
+	// the Runnable stops after the last controller is removed.
+	<-runnable.startedCh
+	select {
+	case <-h.Done():
+	case <-time.After(time.Second):
+		require.Fail(t, "handle not removed")
+	}
+	require.Equal(t, 2, runs)
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+}
+
+type testMsg struct {
+	n int
+}
+
+func (m *testMsg) NewMessage() Message { return &testMsg{} }
+
+func TestProcessMessagesStop(t *testing.T) {
+	loop := NewLoop()
+	for n := 0; n < 4; n++ {
+		loop.PostMessage(&testMsg{n: n})
+	}
+	var seen, remains []int
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			n := mctx.CurrentMessage().(*testMsg).n
+			seen = append(seen, n)
+			if n == 0 {
+				mctx.MessageTaken()
+			}
+			if n == 1 {
+				mctx.StopProcessing()
+			}
+		}))
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			remains = append(remains, mctx.CurrentMessage().(*testMsg).n)
+		}))
+		return nil
+	}))
+	loop.Step(context.Background(), 1)
+	require.Equal(t, []int{0, 1}, seen)
+	require.Equal(t, []int{1, 2, 3}, remains)
+}
+
+func TestProcessMessagesPanic(t *testing.T) {
+	loop := NewLoop()
+	for n := 0; n < 4; n++ {
+		loop.PostMessage(&testMsg{n: n})
+	}
+	var remains []int
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			switch mctx.CurrentMessage().(*testMsg).n {
+			case 0:
+				mctx.MessageTaken()
+			case 2:
+				cc.Messages().AddMessages(&testMsg{n: 10})
+				panic("process")
+			}
+		}))
+		return nil
+	}))
+	loop.AddController(PrLvIdle, ControlFunc(func(cc ControlContext) error {
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			remains = append(remains, mctx.CurrentMessage().(*testMsg).n)
+		}))
+		return nil
+	}))
+	loop.Step(context.Background(), 1)
+	require.Equal(t, []int{1, 2, 3, 10}, remains)
+}
+
+func TestProcessMessagesStopMidQueue(t *testing.T) {
+	loop := NewLoop()
+	for n := 0; n < 5; n++ {
+		loop.PostMessage(&testMsg{n: n})
+	}
+	var seen, remains []int
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			n := mctx.CurrentMessage().(*testMsg).n
+			seen = append(seen, n)
+			switch n {
+			case 0:
+				mctx.MessageTaken()
+			case 1:
+				cc.Messages().AddMessages(&testMsg{n: 10})
+			case 2:
+				mctx.MessageTaken()
+				mctx.StopProcessing()
+			}
+		}))
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			remains = append(remains, mctx.CurrentMessage().(*testMsg).n)
+		}))
+		return nil
+	}))
+	loop.Step(context.Background(), 1)
+	require.Equal(t, []int{0, 1, 2}, seen)
+	require.Equal(t, []int{1, 3, 4, 10}, remains)
+}
+
+func TestLoopDeadLetters(t *testing.T) {
+	loop := NewLoop()
+	loop.Clock = NewSimClock(time.Unix(1000, 0))
+	loop.MaxMessages = 2
+	type deadLetter struct {
+		n      int
+		reason DeadLetterReason
+	}
+	var dead []deadLetter
+	loop.DeadLetterHandler = HandleDeadLetterFunc(func(msg Message, reason DeadLetterReason) {
+		dead = append(dead, deadLetter{n: msg.(*testMsg).n, reason: reason})
+	})
+	var taken []int
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			if n := mctx.CurrentMessage().(*testMsg).n; n%2 == 0 {
+				taken = append(taken, n)
+				mctx.MessageTaken()
+			}
+		}))
+		return nil
+	}))
+
+	ctx := context.Background()
+	loop.PostMessage(&testMsg{n: 0})
+	loop.PostMessage(&testMsg{n: 1})
+	loop.PostMessage(&testMsg{n: 2})
+	loop.Step(ctx, 1)
+	require.Equal(t, []int{2}, taken)
+	require.Equal(t, []deadLetter{{0, DeadLetterOverflow}, {1, DeadLetterUnconsumed}}, dead)
+
+	taken, dead = nil, nil
+	loop.Overflow = OverflowDropNewest
+	loop.PostMessageTTL(&testMsg{n: 4}, 50*time.Millisecond)
+	loop.PostMessageTTL(&testMsg{n: 6}, 150*time.Millisecond)
+	loop.PostMessage(&testMsg{n: 8})
+	loop.Step(ctx, 1)
+	require.Equal(t, []int{6}, taken)
+	require.Equal(t, []deadLetter{{8, DeadLetterOverflow}, {4, DeadLetterExpired}}, dead)
+}
+
+func TestLoopDeadLettersAfterPanic(t *testing.T) {
+	loop := NewLoop()
+	var dead []int
+	loop.DeadLetterHandler = HandleDeadLetterFunc(func(msg Message, reason DeadLetterReason) {
+		require.Equal(t, DeadLetterUnconsumed, reason)
+		dead = append(dead, msg.(*testMsg).n)
+	})
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			panic("process")
+		}))
+		return nil
+	}))
+	for n := 0; n < 3; n++ {
+		loop.PostMessage(&testMsg{n: n})
+	}
+	loop.Step(context.Background(), 1)
+	require.Equal(t, []int{0, 1, 2}, dead)
+}
+
+type sliceMsg []int
+
+func (m sliceMsg) NewMessage() Message { return sliceMsg{} }
+
+func TestLoopOverflowNonComparable(t *testing.T) {
+	loop := NewLoop()
+	loop.MaxMessages = 1
+	var dead []Message
+	loop.DeadLetterHandler = HandleDeadLetterFunc(func(msg Message, reason DeadLetterReason) {
+		dead = append(dead, msg)
+	})
+	loop.PostMessage(sliceMsg{1})
+	loop.PostMessage(sliceMsg{2})
+	require.Equal(t, []Message{sliceMsg{1}}, dead)
+	require.Equal(t, sliceMsg{2}, loop.messages.head.msg)
+}
+
+func TestLoopOverflowRepost(t *testing.T) {
+	loop := NewLoop()
+	loop.MaxMessages = 1
+	var dead []Message
+	loop.DeadLetterHandler = HandleDeadLetterFunc(func(msg Message, reason DeadLetterReason) {
+		dead = append(dead, msg)
+	})
+	msg := &testMsg{n: 1}
+	loop.PostMessage(msg)
+	loop.PostMessage(msg)
+	require.Equal(t, []Message{msg}, dead)
+	require.Equal(t, 1, loop.pending)
+	require.True(t, loop.messages.head.msg == msg)
+}
@@ -0,0 +1,148 @@
+package framework
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// OverflowPolicy defines what to do when the message queue of Loop is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest drops the oldest queued message for the new one.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest drops the new message.
+	OverflowDropNewest
+)
+
+// DeadLetterReason indicates why a message is not delivered.
+type DeadLetterReason int
+
+const (
+	// DeadLetterUnconsumed means no controller took the message
+	// in the iteration.
+	DeadLetterUnconsumed DeadLetterReason = iota
+	// DeadLetterExpired means the message expired before an iteration.
+	DeadLetterExpired
+	// DeadLetterOverflow means the message is dropped because the queue
+	// is full.
+	DeadLetterOverflow
+	// DeadLetterHalted means the message is posted after the loop is
+	// halted by shutdown controllers.
+	DeadLetterHalted
+)
+
+// String implements fmt.Stringer.
+func (r DeadLetterReason) String() string {
+	switch r {
+	case DeadLetterUnconsumed:
+		return "unconsumed"
+	case DeadLetterExpired:
+		return "expired"
+	case DeadLetterOverflow:
+		return "overflow"
+	case DeadLetterHalted:
+		return "halted"
+	}
+	return fmt.Sprintf("DeadLetterReason(%d)", int(r))
+}
+
+// DeadLetterHandler receives messages which are not delivered.
+// It may be called from any goroutine posting messages.
+type DeadLetterHandler interface {
+	HandleDeadLetter(Message, DeadLetterReason)
+}
+
+// HandleDeadLetterFunc is the func form of DeadLetterHandler.
+type HandleDeadLetterFunc func(Message, DeadLetterReason)
+
+// HandleDeadLetter implements DeadLetterHandler.
+func (f HandleDeadLetterFunc) HandleDeadLetter(msg Message, reason DeadLetterReason) {
+	f(msg, reason)
+}
+
+// LogDeadLetters is a DeadLetterHandler logs warnings.
+var LogDeadLetters DeadLetterHandler = HandleDeadLetterFunc(func(msg Message, reason DeadLetterReason) {
+	glog.Warningf("dead letter (%v): %T", reason, msg)
+})
+
+// PostMessage implements LoopCtl.
+func (l *Loop) PostMessage(msg Message) {
+	if l.parent != nil {
+		l.parent.PostMessage(msg)
+		return
+	}
+	l.PostMessageTTL(msg, l.MessageTTL)
+}
+
+// PostMessageTTL implements MessageTTLPoster.
+func (l *Loop) PostMessageTTL(msg Message, ttl time.Duration) {
+	if l.parent != nil {
+		l.parent.PostMessageTTL(msg, ttl)
+		return
+	}
+	item := &messageItem{msg: msg}
+	if ttl > 0 {
+		item.expireAt = l.clock().Now().Add(ttl)
+	}
+	l.lock.Lock()
+	if l.replaying {
+		l.lock.Unlock()
+		return
+	}
+	if l.halted {
+		l.lock.Unlock()
+		l.deadLetter(msg, DeadLetterHalted)
+		return
+	}
+	dropped, overflow := l.enqueue(item)
+	l.lock.Unlock()
+	if overflow {
+		l.deadLetter(dropped, DeadLetterOverflow)
+	}
+}
+
+// enqueue appends the item with the lock held. If the queue is full, it
+// returns the message dropped according to Overflow and true.
+func (l *Loop) enqueue(item *messageItem) (Message, bool) {
+	var dropped Message
+	overflow := l.MaxMessages > 0 && l.pending >= l.MaxMessages
+	if overflow {
+		if l.Overflow == OverflowDropNewest {
+			return item.msg, true
+		}
+		dropped = l.messages.pop().msg
+		l.pending--
+	}
+	l.messages.append(item)
+	l.pending++
+	return dropped, overflow
+}
+
+// takeMessages takes queued messages without expired ones.
+func (l *Loop) takeMessages(now time.Time) (msgs messageList) {
+	var queued messageList
+	l.lock.Lock()
+	queued.splice(&l.messages)
+	l.pending = 0
+	l.lock.Unlock()
+	for item := queued.head; item != nil; {
+		next := item.next
+		item.next = nil
+		if !item.expireAt.IsZero() && !now.Before(item.expireAt) {
+			l.deadLetter(item.msg, DeadLetterExpired)
+		} else {
+			msgs.append(item)
+		}
+		item = next
+	}
+	return
+}
+
+func (l *Loop) deadLetter(msg Message, reason DeadLetterReason) {
+	if h := l.DeadLetterHandler; h != nil {
+		h.HandleDeadLetter(msg, reason)
+	}
+}
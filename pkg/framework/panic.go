@@ -0,0 +1,116 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// PanicAction defines what to do with a controller after it panics.
+type PanicAction int
+
+const (
+	// PanicContinue keeps running the controller.
+	PanicContinue PanicAction = iota
+	// PanicSkip skips the controller for PanicPolicy.SkipIterations.
+	PanicSkip
+	// PanicRemove removes the controller permanently.
+	PanicRemove
+)
+
+// String implements fmt.Stringer.
+func (a PanicAction) String() string {
+	switch a {
+	case PanicContinue:
+		return "continue"
+	case PanicSkip:
+		return "skip"
+	case PanicRemove:
+		return "remove"
+	}
+	return fmt.Sprintf("PanicAction(%d)", int(a))
+}
+
+// PanicPolicy defines how a controller is treated after it panics.
+type PanicPolicy struct {
+	Action         PanicAction
+	SkipIterations int // number of iterations to skip with PanicSkip
+}
+
+// ControllerPanic describes a panic recovered from a controller.
+type ControllerPanic struct {
+	Controller    Controller
+	Name          string
+	PriorityLevel int
+	Value         interface{} // the value passed to panic
+	Stack         []byte
+	Action        PanicAction // the action applied to the controller
+}
+
+// FailsafeHandler is called after a controller panics, e.g. to put
+// acuators into a safe state.
+type FailsafeHandler interface {
+	Failsafe(ControlContext, *ControllerPanic)
+}
+
+// FailsafeFunc is the func form of FailsafeHandler.
+type FailsafeFunc func(ControlContext, *ControllerPanic)
+
+// Failsafe implements FailsafeHandler.
+func (f FailsafeFunc) Failsafe(cc ControlContext, p *ControllerPanic) {
+	f(cc, p)
+}
+
+// AddFailsafe registers handlers called after a controller panics.
+func (l *Loop) AddFailsafe(handlers ...FailsafeHandler) *Loop {
+	if l.parent != nil {
+		l.parent.AddFailsafe(handlers...)
+		return l
+	}
+	l.lock.Lock()
+	l.failsafes = append(l.failsafes, handlers...)
+	l.lock.Unlock()
+	return l
+}
+
+// handlePanic applies the policy to the controller and runs failsafe
+// handlers. entry is nil for hooks, which are only run once.
+func (l *Loop) handlePanic(cc ControlContext, p *ControllerPanic, entry *controllerEntry) {
+	l.lock.Lock()
+	policy := l.PanicPolicy
+	if entry != nil && entry.handle != nil && entry.handle.policy != nil {
+		policy = *entry.handle.policy
+	}
+	failsafes := l.failsafes
+	l.lock.Unlock()
+
+	if entry != nil {
+		p.Name, p.Action = entry.name, policy.Action
+	} else {
+		p.Name, p.Action = controllerName(p.Controller), PanicContinue
+	}
+	glog.Errorf("controller %s at priority level %d panic: %v (%v)\n%s",
+		p.Name, p.PriorityLevel, p.Value, p.Action, p.Stack)
+
+	switch p.Action {
+	case PanicSkip:
+		entry.skip = policy.SkipIterations
+	case PanicRemove:
+		l.lock.Lock()
+		entry.handle.removeEntry(entry)
+		l.lock.Unlock()
+	}
+
+	for _, h := range failsafes {
+		runFailsafe(cc, h, p)
+	}
+}
+
+func runFailsafe(cc ControlContext, h FailsafeHandler, p *ControllerPanic) {
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Errorf("failsafe panic: %v", r)
+		}
+	}()
+	h.Failsafe(cc, p)
+}
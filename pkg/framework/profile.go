@@ -0,0 +1,193 @@
+package framework
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// TimingStats accumulates measured durations.
+type TimingStats struct {
+	Count    uint64
+	Total    time.Duration
+	Max      time.Duration
+	Last     time.Duration
+	Overruns uint64 // number of durations exceeding the budget
+}
+
+// Mean calculates the average duration.
+func (s TimingStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// add accumulates a duration and tells if it exceeds a non-zero budget.
+func (s *TimingStats) add(d, budget time.Duration) bool {
+	s.Count++
+	s.Total += d
+	s.Last = d
+	if d > s.Max {
+		s.Max = d
+	}
+	if budget > 0 && d > budget {
+		s.Overruns++
+		return true
+	}
+	return false
+}
+
+// ControllerProfile is the timing profile of a controller.
+type ControllerProfile struct {
+	Name          string
+	PriorityLevel int
+	TimingStats
+}
+
+// LoopProfile is a snapshot of timing profiles of a Loop.
+type LoopProfile struct {
+	// Iterations is the execution time of iterations,
+	// and Overruns counts iterations exceeding Loop.Interval.
+	Iterations TimingStats
+	// Jitter is the delay of periodic iterations from schedule.
+	Jitter TimingStats
+	// MissedTicks is the number of periodic iterations skipped
+	// because previous iterations took too long.
+	MissedTicks uint64
+	// Levels is the execution time of each priority level, including hooks.
+	Levels [PriorityLevels]TimingStats
+	// Controllers are profiles of registered controllers, and Overruns
+	// counts executions exceeding Loop.ControllerBudget.
+	Controllers []ControllerProfile
+}
+
+// Overrun describes an execution exceeding the budget.
+type Overrun struct {
+	// Controller is nil if the whole iteration exceeds Loop.Interval.
+	Controller    Controller
+	Name          string
+	PriorityLevel int
+	Duration      time.Duration
+	Budget        time.Duration
+}
+
+// String implements fmt.Stringer.
+func (o Overrun) String() string {
+	if o.Controller == nil {
+		return fmt.Sprintf("iteration took %v, exceeding interval %v", o.Duration, o.Budget)
+	}
+	return fmt.Sprintf("controller %s at priority level %d took %v, exceeding budget %v",
+		o.Name, o.PriorityLevel, o.Duration, o.Budget)
+}
+
+// OverrunHandler is notified when an execution exceeds the budget.
+type OverrunHandler interface {
+	HandleOverrun(Overrun)
+}
+
+// HandleOverrunFunc is the func form of OverrunHandler.
+type HandleOverrunFunc func(Overrun)
+
+// HandleOverrun implements OverrunHandler.
+func (f HandleOverrunFunc) HandleOverrun(o Overrun) {
+	f(o)
+}
+
+// LogOverruns is an OverrunHandler logs warnings.
+var LogOverruns OverrunHandler = HandleOverrunFunc(func(o Overrun) {
+	glog.Warningf("loop overrun: %v", o)
+})
+
+// controllerName gets the name of a controller for profiling.
+func controllerName(ctl Controller) string {
+	if named, ok := ctl.(Named); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", ctl)
+}
+
+// loopProfiler collects timing profiles, accessed by loop goroutine
+// except when taking snapshot with lock of Loop.
+type loopProfiler struct {
+	iterations  TimingStats
+	jitter      TimingStats
+	missedTicks uint64
+	levels      [PriorityLevels]TimingStats
+	lastTick    time.Time
+}
+
+// Profile gets a snapshot of timing profiles.
+// It's only collected when Profiling is enabled.
+func (l *Loop) Profile() LoopProfile {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	p := LoopProfile{
+		Iterations:  l.profiler.iterations,
+		Jitter:      l.profiler.jitter,
+		MissedTicks: l.profiler.missedTicks,
+		Levels:      l.profiler.levels,
+	}
+	for i := range l.controllers {
+		for _, entry := range l.controllers[i].snapshot() {
+			p.Controllers = append(p.Controllers, ControllerProfile{
+				Name:          entry.name,
+				PriorityLevel: i,
+				TimingStats:   entry.stats,
+			})
+		}
+	}
+	return p
+}
+
+// profileTick records the schedule of a periodic iteration.
+func (l *Loop) profileTick(tick, now time.Time) {
+	p := &l.profiler
+	l.lock.Lock()
+	if !p.lastTick.IsZero() {
+		if missed := tick.Sub(p.lastTick)/l.interval() - 1; missed > 0 {
+			p.missedTicks += uint64(missed)
+		}
+	}
+	p.lastTick = tick
+	if now.After(tick) {
+		p.jitter.add(now.Sub(tick), 0)
+	} else {
+		p.jitter.add(0, 0)
+	}
+	l.lock.Unlock()
+}
+
+// profileIteration records the execution time of an iteration.
+func (l *Loop) profileIteration(d time.Duration) {
+	l.lock.Lock()
+	overrun := l.profiler.iterations.add(d, l.interval())
+	l.lock.Unlock()
+	if overrun && l.OverrunHandler != nil {
+		l.OverrunHandler.HandleOverrun(Overrun{PriorityLevel: -1, Duration: d, Budget: l.interval()})
+	}
+}
+
+// profileLevel records the execution time of a priority level.
+func (l *Loop) profileLevel(level int, d time.Duration) {
+	l.lock.Lock()
+	l.profiler.levels[level].add(d, 0)
+	l.lock.Unlock()
+}
+
+// profileController records the execution time of a controller.
+func (l *Loop) profileController(level int, entry *controllerEntry, d time.Duration) {
+	l.lock.Lock()
+	overrun := entry.stats.add(d, l.ControllerBudget)
+	l.lock.Unlock()
+	if overrun && l.OverrunHandler != nil {
+		l.OverrunHandler.HandleOverrun(Overrun{
+			Controller:    entry.ctl,
+			Name:          entry.name,
+			PriorityLevel: level,
+			Duration:      d,
+			Budget:        l.ControllerBudget,
+		})
+	}
+}
@@ -31,8 +31,10 @@ type Runner struct {
 	Context context.Context
 	Runners []Runnable
 
-	errCh  chan error
-	exitCh chan struct{}
+	errCh      chan error
+	exitCh     chan struct{}
+	supervisor *Supervisor
+	shutdown   *Shutdown
 }
 
 // NewRunner creates a runner with a default background context.
@@ -50,6 +52,8 @@ func NewRunnerWith(ctx context.Context) *Runner {
 }
 
 // HandleSignals handles CtrlC and SIGTERM from the system.
+// The first signal runs the phases set by ShutdownWith and then cancels
+// the context, and a second signal forces exit.
 func (r *Runner) HandleSignals() *Runner {
 	ctx, cancel := context.WithCancel(r.Context)
 	sigCh := make(chan os.Signal, 1)
@@ -58,7 +62,14 @@ func (r *Runner) HandleSignals() *Runner {
 	go func() {
 		<-sigCh
 		glog.Info("stop requested")
-		cancel()
+		go func() {
+			if s := r.shutdown; s != nil {
+				if err := s.Run(ctx); err != nil {
+					glog.Errorf("graceful shutdown: %v", err)
+				}
+			}
+			cancel()
+		}()
 		<-sigCh
 		glog.Error("stop requested again, force exit")
 		close(r.exitCh)
@@ -66,6 +77,30 @@ func (r *Runner) HandleSignals() *Runner {
 	return r
 }
 
+// ShutdownWith sets the graceful shutdown phases run by HandleSignals
+// before canceling the context. It must be called before a stop is
+// requested.
+func (r *Runner) ShutdownWith(s *Shutdown) *Runner {
+	r.shutdown = s
+	return r
+}
+
+// Supervise runs Runnables spawned afterwards with the Supervisor,
+// nil to run without supervision.
+func (r *Runner) Supervise(s *Supervisor) *Runner {
+	r.supervisor = s
+	return r
+}
+
+// States gets the states of Runnables being supervised, nil without
+// a Supervisor.
+func (r *Runner) States() []RunnableState {
+	if r.supervisor == nil {
+		return nil
+	}
+	return r.supervisor.States()
+}
+
 // Go spawns a Runnable with default context.
 func (r *Runner) Go(runners ...Runnable) *Runner {
 	return r.GoWith(r.Context, runners...)
@@ -82,6 +117,13 @@ func (r *Runner) GoWith(ctx context.Context, runners ...Runnable) *Runner {
 		}
 		r.Runners = append(r.Runners, runner)
 		glog.V(4).Infof("start Runner[%s]", name)
+		if r.supervisor != nil {
+			r.supervisor.Start(ctx, runner, func(err error) {
+				glog.V(4).Infof("Runner[%s] stopped", name)
+				r.errCh <- err
+			})
+			continue
+		}
 		go func(runner Runnable, name string) {
 			glog.V(4).Infof("Runner[%s] started", name)
 			r.errCh <- runner.Run(ctx)
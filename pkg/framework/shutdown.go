@@ -0,0 +1,269 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ShutdownPhase is a phase of graceful shutdown. Phases run in order.
+type ShutdownPhase int
+
+const (
+	// ShutdownStopCommands stops accepting commands.
+	ShutdownStopCommands ShutdownPhase = iota
+	// ShutdownControllers runs shutdown controllers, e.g. to zero velocities.
+	ShutdownControllers
+	// ShutdownFlushEvents flushes events being sent.
+	ShutdownFlushEvents
+	// ShutdownCloseTransports closes transports.
+	ShutdownCloseTransports
+)
+
+// ShutdownPhases is the total number of shutdown phases.
+const ShutdownPhases int = 4
+
+// DefaultShutdownTimeout is the default deadline of a shutdown phase.
+const DefaultShutdownTimeout = 2 * time.Second
+
+// String implements fmt.Stringer.
+func (p ShutdownPhase) String() string {
+	switch p {
+	case ShutdownStopCommands:
+		return "stop-commands"
+	case ShutdownControllers:
+		return "controllers"
+	case ShutdownFlushEvents:
+		return "flush-events"
+	case ShutdownCloseTransports:
+		return "close-transports"
+	}
+	return fmt.Sprintf("ShutdownPhase(%d)", int(p))
+}
+
+// ShutdownHandler does the work of a component in a shutdown phase.
+// It should return when the context is done.
+type ShutdownHandler interface {
+	Shutdown(context.Context) error
+}
+
+// ShutdownFunc is the func form of ShutdownHandler.
+type ShutdownFunc func(context.Context) error
+
+// Shutdown implements ShutdownHandler.
+func (f ShutdownFunc) Shutdown(ctx context.Context) error {
+	return f(ctx)
+}
+
+// ShutdownError reports a shutdown handler which failed or blocked.
+type ShutdownError struct {
+	Phase ShutdownPhase
+	Name  string
+	Err   error // ErrShutdownBlocked if the deadline is exceeded
+}
+
+// Error implements error.
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown %s: %s: %v", e.Phase, e.Name, e.Err)
+}
+
+// Shutdown runs handlers of components in ordered phases for graceful
+// shutdown. Handlers of the same phase run concurrently, and the next
+// phase starts when all of them return or the deadline of the phase is
+// exceeded. The zero value is ready to use.
+type Shutdown struct {
+	// Timeouts are the deadlines of phases, DefaultShutdownTimeout if zero.
+	Timeouts [ShutdownPhases]time.Duration
+
+	handlers [ShutdownPhases][]namedShutdownHandler
+	lock     sync.Mutex
+}
+
+type namedShutdownHandler struct {
+	name    string
+	handler ShutdownHandler
+}
+
+type shutdownResult struct {
+	index int
+	err   error
+}
+
+// Add registers a handler to the phase, name identifies the component
+// in errors.
+func (s *Shutdown) Add(phase ShutdownPhase, name string, h ShutdownHandler) *Shutdown {
+	s.lock.Lock()
+	s.handlers[phase] = append(s.handlers[phase], namedShutdownHandler{name: name, handler: h})
+	s.lock.Unlock()
+	return s
+}
+
+// Run runs all phases, and aggregates ShutdownErrors of handlers
+// which failed or blocked.
+func (s *Shutdown) Run(ctx context.Context) error {
+	var errs AggregatedError
+	for phase := ShutdownPhase(0); int(phase) < ShutdownPhases; phase++ {
+		s.lock.Lock()
+		handlers, timeout := s.handlers[phase], s.Timeouts[phase]
+		s.lock.Unlock()
+		if len(handlers) == 0 {
+			continue
+		}
+		if timeout == 0 {
+			timeout = DefaultShutdownTimeout
+		}
+		glog.V(4).Infof("shutdown phase %s", phase)
+		errs.Add(runShutdownPhase(ctx, phase, timeout, handlers)...)
+	}
+	return errs.Aggregate()
+}
+
+func runShutdownPhase(ctx context.Context, phase ShutdownPhase, timeout time.Duration, handlers []namedShutdownHandler) (errs []error) {
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resultCh := make(chan shutdownResult, len(handlers))
+	for n, h := range handlers {
+		go func(n int, h ShutdownHandler) {
+			resultCh <- shutdownResult{index: n, err: h.Shutdown(phaseCtx)}
+		}(n, h.handler)
+	}
+	done := make([]bool, len(handlers))
+	for pending := len(handlers); pending > 0; pending-- {
+		select {
+		case r := <-resultCh:
+			done[r.index] = true
+			if r.err != nil {
+				err := &ShutdownError{Phase: phase, Name: handlers[r.index].name, Err: r.err}
+				glog.Error(err)
+				errs = append(errs, err)
+			}
+		case <-phaseCtx.Done():
+			for n, h := range handlers {
+				if !done[n] {
+					err := &ShutdownError{Phase: phase, Name: h.name, Err: ErrShutdownBlocked}
+					glog.Error(err)
+					errs = append(errs, err)
+				}
+			}
+			return
+		}
+	}
+	return
+}
+
+type shutdownRequest struct {
+	ctx  context.Context
+	done chan struct{}
+}
+
+// OnShutdown registers a handler to the phase of Loop.Shutdown, which is
+// created if nil. It's usually called by LoopAdders.
+func (l *Loop) OnShutdown(phase ShutdownPhase, name string, h ShutdownHandler) *Loop {
+	if l.parent != nil {
+		l.parent.OnShutdown(phase, name, h)
+		return l
+	}
+	l.lock.Lock()
+	if l.Shutdown == nil {
+		l.Shutdown = &Shutdown{}
+	}
+	s := l.Shutdown
+	l.lock.Unlock()
+	s.Add(phase, name, h)
+	return l
+}
+
+// AddShutdownController registers controllers to run in the
+// ShutdownControllers phase, e.g. to zero velocities. They run in a final
+// iteration with the messages pending, in the order of priority levels,
+// and the loop stops running iterations afterwards. Messages posted then
+// are dead-lettered with DeadLetterHalted.
+func (l *Loop) AddShutdownController(priorityLevel int, ctls ...Controller) *Loop {
+	if l.parent != nil {
+		l.parent.AddShutdownController(priorityLevel, ctls...)
+		return l
+	}
+	l.lock.Lock()
+	first := !l.hasShutdownControllers()
+	l.shutdownCtls[priorityLevel] = append(l.shutdownCtls[priorityLevel], ctls...)
+	l.lock.Unlock()
+	if first {
+		l.OnShutdown(ShutdownControllers, "loop", ShutdownFunc(l.shutdownControllers))
+	}
+	return l
+}
+
+func (l *Loop) hasShutdownControllers() bool {
+	for _, ctls := range l.shutdownCtls {
+		if len(ctls) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// shutdownControllers runs shutdown controllers in the loop goroutine
+// if the loop is running, otherwise runs them directly.
+func (l *Loop) shutdownControllers(ctx context.Context) error {
+	l.lock.Lock()
+	running := l.runCtx != nil
+	l.lock.Unlock()
+	if !running {
+		l.runShutdownIteration(ctx)
+		return nil
+	}
+	req := shutdownRequest{ctx: ctx, done: make(chan struct{})}
+	select {
+	case l.shutdownCh <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-req.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// halt stops iterations, and dead-letters messages queued or posted.
+func (l *Loop) halt() {
+	l.lock.Lock()
+	l.halted = true
+	var queued messageList
+	queued.splice(&l.messages)
+	l.pending = 0
+	l.lock.Unlock()
+	for item := queued.head; item != nil; item = item.next {
+		l.deadLetter(item.msg, DeadLetterHalted)
+	}
+}
+
+func (l *Loop) isHalted() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.halted
+}
+
+func (l *Loop) runShutdownIteration(ctx context.Context) {
+	l.lock.Lock()
+	ctls := l.shutdownCtls
+	l.lock.Unlock()
+	iter := &loopIteration{loopCtl: loopCtl{l}, time: l.clock().Now()}
+	iter.messages = l.takeMessages(iter.time)
+	// messages posted during the iteration are dead-lettered by halt.
+	defer l.halt()
+	iter.ctx = context.WithValue(ctx, loopCtxKey, iter)
+	for i := 0; i < PriorityLevels; i++ {
+		iter.priorityLevel = i
+		runControllers(iter, ctls[i])
+	}
+	if l.DeadLetterHandler != nil {
+		for item := iter.messages.head; item != nil; item = item.next {
+			l.deadLetter(item.msg, DeadLetterUnconsumed)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package framework
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownPhases(t *testing.T) {
+	var lock sync.Mutex
+	var order []string
+	record := func(name string) ShutdownFunc {
+		return func(context.Context) error {
+			lock.Lock()
+			order = append(order, name)
+			lock.Unlock()
+			return nil
+		}
+	}
+	var s Shutdown
+	s.Timeouts[ShutdownFlushEvents] = 10 * time.Millisecond
+	s.Add(ShutdownCloseTransports, "transport", record("close"))
+	s.Add(ShutdownFlushEvents, "stuck", ShutdownFunc(func(context.Context) error {
+		select {}
+	}))
+	s.Add(ShutdownFlushEvents, "events", record("flush"))
+	s.Add(ShutdownStopCommands, "commands", record("stop"))
+
+	err := s.Run(context.Background())
+	require.Equal(t, []string{"stop", "flush", "close"}, order)
+	require.IsType(t, &AggregatedError{}, err)
+	errs := err.(*AggregatedError).Errors
+	require.Len(t, errs, 1)
+	require.Equal(t, &ShutdownError{Phase: ShutdownFlushEvents, Name: "stuck", Err: ErrShutdownBlocked}, errs[0])
+	require.EqualError(t, errs[0], "shutdown flush-events: stuck: blocked past deadline")
+}
+
+func TestLoopShutdownControllers(t *testing.T) {
+	loop := NewLoop()
+	loop.Interval = time.Millisecond
+	var iterations, stops int32
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		atomic.AddInt32(&iterations, 1)
+		return nil
+	}))
+	loop.AddShutdownController(PrLvAcuate, ControlFunc(func(cc ControlContext) error {
+		atomic.AddInt32(&stops, 1)
+		return nil
+	}))
+	require.NotNil(t, loop.Shutdown)
+	var dead []DeadLetterReason
+	loop.DeadLetterHandler = HandleDeadLetterFunc(func(msg Message, reason DeadLetterReason) {
+		dead = append(dead, reason)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- loop.Run(ctx)
+	}()
+	waitFor(t, func() bool { return atomic.LoadInt32(&iterations) > 0 })
+
+	require.NoError(t, loop.Shutdown.Run(ctx))
+	require.Equal(t, int32(1), atomic.LoadInt32(&stops))
+	loop.PostMessage(&testMsg{})
+	require.Equal(t, []DeadLetterReason{DeadLetterHalted}, dead)
+	require.Zero(t, loop.pending)
+	// the loop is halted after shutdown controllers.
+	count := atomic.LoadInt32(&iterations)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, count, atomic.LoadInt32(&iterations))
+
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+}
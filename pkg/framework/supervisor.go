@@ -0,0 +1,314 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RestartPolicy decides whether a supervised Runnable is restarted
+// after it returns.
+type RestartPolicy int
+
+const (
+	// RestartNever never restarts the Runnable.
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts the Runnable when it returns an error.
+	RestartOnFailure
+	// RestartAlways restarts the Runnable whenever it returns.
+	RestartAlways
+)
+
+// String implements fmt.Stringer.
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	}
+	return fmt.Sprintf("RestartPolicy(%d)", int(p))
+}
+
+// SupervisorStrategy decides which Runnables are restarted together.
+type SupervisorStrategy int
+
+const (
+	// OneForOne restarts only the Runnable which returned.
+	OneForOne SupervisorStrategy = iota
+	// OneForAll also stops and restarts all other running Runnables of
+	// the Supervisor when a Runnable is restarted.
+	OneForAll
+)
+
+// String implements fmt.Stringer.
+func (s SupervisorStrategy) String() string {
+	switch s {
+	case OneForOne:
+		return "one-for-one"
+	case OneForAll:
+		return "one-for-all"
+	}
+	return fmt.Sprintf("SupervisorStrategy(%d)", int(s))
+}
+
+// Backoff defines the exponential delay before restarts.
+type Backoff struct {
+	Initial time.Duration // delay before the first restart
+	Max     time.Duration // max delay, zero for unlimited
+	Factor  float64       // multiplier for each consecutive restart, default 2 if less than 1
+}
+
+// DefaultBackoff is the default Backoff of Supervisor.
+var DefaultBackoff = Backoff{
+	Initial: 100 * time.Millisecond,
+	Max:     30 * time.Second,
+	Factor:  2,
+}
+
+// Delay calculates the delay before the nth (starting from 1)
+// consecutive restart.
+func (b Backoff) Delay(n int) time.Duration {
+	factor := b.Factor
+	if factor < 1 {
+		factor = 2
+	}
+	d := float64(b.Initial)
+	for i := 1; i < n && (b.Max <= 0 || d < float64(b.Max)); i++ {
+		d *= factor
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	return time.Duration(d)
+}
+
+// RunnableStatus is the status of a supervised Runnable.
+type RunnableStatus int
+
+const (
+	// RunnableRunning indicates the Runnable is running.
+	RunnableRunning RunnableStatus = iota
+	// RunnableBackoff indicates the Runnable is waiting to be restarted.
+	RunnableBackoff
+	// RunnableStopped indicates the Runnable returned without error
+	// and is not restarted.
+	RunnableStopped
+	// RunnableFailed indicates the Runnable returned an error and
+	// is not restarted.
+	RunnableFailed
+)
+
+// String implements fmt.Stringer.
+func (s RunnableStatus) String() string {
+	switch s {
+	case RunnableRunning:
+		return "running"
+	case RunnableBackoff:
+		return "backoff"
+	case RunnableStopped:
+		return "stopped"
+	case RunnableFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("RunnableStatus(%d)", int(s))
+}
+
+// RunnableState is a snapshot of the state of a supervised Runnable.
+type RunnableState struct {
+	Name     string
+	Status   RunnableStatus
+	Restarts int       // total number of restarts
+	LastErr  error     // the last error returned
+	Since    time.Time // when Status changed
+}
+
+// MaxFinishedStates is the number of states of stopped or failed
+// Runnables kept by Supervisor.
+const MaxFinishedStates = 16
+
+// Supervisor runs Runnables and restarts them according to the policy.
+// A Runnable is no longer supervised once the context it's started with
+// is done. A Supervisor can be shared by Runners and Loops, and OneForAll
+// applies to all Runnables it supervises.
+type Supervisor struct {
+	Restart     RestartPolicy
+	Strategy    SupervisorStrategy
+	Backoff     Backoff // the delay before restarts
+	MaxRestarts int     // max consecutive restarts before the Runnable fails, zero for unlimited
+	// StableUptime resets the number of consecutive restarts when a
+	// Runnable has been running longer. If zero, it's Backoff.Max, or
+	// Backoff.Initial if Backoff.Max is zero.
+	StableUptime time.Duration
+
+	children []*supervisedRunnable
+	finished []RunnableState // the last MaxFinishedStates stopped or failed
+	lock     sync.Mutex
+}
+
+type supervisedRunnable struct {
+	runnable Runnable
+	state    RunnableState
+	attempts int // consecutive restarts
+	cancel   context.CancelFunc
+
+	// set when restarted together with a sibling by OneForAll.
+	groupRestart bool
+	restartAt    time.Time
+}
+
+// NewSupervisor creates a Supervisor with DefaultBackoff.
+func NewSupervisor(restart RestartPolicy, strategy SupervisorStrategy) *Supervisor {
+	return &Supervisor{Restart: restart, Strategy: strategy, Backoff: DefaultBackoff}
+}
+
+// Start runs a Runnable in the background. done is called with the last
+// error when the Runnable is no longer restarted, or ctx is done.
+func (s *Supervisor) Start(ctx context.Context, runnable Runnable, done func(error)) {
+	c := &supervisedRunnable{runnable: runnable}
+	c.state.Name = runnableName(runnable)
+	s.lock.Lock()
+	s.children = append(s.children, c)
+	s.lock.Unlock()
+	go func() {
+		done(s.supervise(ctx, c))
+	}()
+}
+
+// States gets the states of Runnables being supervised, followed by
+// the last MaxFinishedStates Runnables stopped or failed.
+func (s *Supervisor) States() []RunnableState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	states := make([]RunnableState, 0, len(s.children)+len(s.finished))
+	for _, c := range s.children {
+		states = append(states, c.state)
+	}
+	return append(states, s.finished...)
+}
+
+func (s *Supervisor) supervise(ctx context.Context, c *supervisedRunnable) error {
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		s.lock.Lock()
+		c.cancel = cancel
+		c.setStatus(RunnableRunning, nil)
+		s.lock.Unlock()
+
+		started := time.Now()
+		err := c.runnable.Run(runCtx)
+		cancel()
+		if ctx.Err() != nil {
+			s.remove(c)
+			return err
+		}
+
+		s.lock.Lock()
+		c.cancel = nil
+		delay, restart := s.restartDelay(c, err, time.Since(started))
+		if !restart {
+			status := RunnableStopped
+			if err != nil {
+				status = RunnableFailed
+			}
+			c.setStatus(status, err)
+			s.removeLocked(c)
+			if s.finished = append(s.finished, c.state); len(s.finished) > MaxFinishedStates {
+				s.finished = append(s.finished[:0:0], s.finished[len(s.finished)-MaxFinishedStates:]...)
+			}
+			s.lock.Unlock()
+			return err
+		}
+		c.setStatus(RunnableBackoff, err)
+		s.lock.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.remove(c)
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// restartDelay decides whether to restart the Runnable with the lock held.
+func (s *Supervisor) restartDelay(c *supervisedRunnable, err error, uptime time.Duration) (time.Duration, bool) {
+	if c.groupRestart {
+		c.groupRestart = false
+		c.state.Restarts++
+		return time.Until(c.restartAt), true
+	}
+	switch s.Restart {
+	case RestartNever:
+		return 0, false
+	case RestartOnFailure:
+		if err == nil {
+			return 0, false
+		}
+	}
+	if stable := s.stableUptime(); stable > 0 && uptime >= stable {
+		c.attempts = 0
+	}
+	c.attempts++
+	if s.MaxRestarts > 0 && c.attempts > s.MaxRestarts {
+		return 0, false
+	}
+	c.state.Restarts++
+	delay := s.Backoff.Delay(c.attempts)
+	if s.Strategy == OneForAll {
+		restartAt := time.Now().Add(delay)
+		for _, sibling := range s.children {
+			if sibling != c && sibling.cancel != nil {
+				sibling.groupRestart = true
+				sibling.restartAt = restartAt
+				sibling.cancel()
+			}
+		}
+	}
+	return delay, true
+}
+
+func (s *Supervisor) stableUptime() time.Duration {
+	switch {
+	case s.StableUptime > 0:
+		return s.StableUptime
+	case s.Backoff.Max > 0:
+		return s.Backoff.Max
+	}
+	return s.Backoff.Initial
+}
+
+func (s *Supervisor) remove(c *supervisedRunnable) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.removeLocked(c)
+}
+
+func (s *Supervisor) removeLocked(c *supervisedRunnable) {
+	for n, child := range s.children {
+		if child == c {
+			s.children = append(s.children[:n:n], s.children[n+1:]...)
+			break
+		}
+	}
+}
+
+func (c *supervisedRunnable) setStatus(status RunnableStatus, err error) {
+	c.state.Status = status
+	c.state.Since = time.Now()
+	if err != nil {
+		c.state.LastErr = err
+	}
+}
+
+func runnableName(runnable Runnable) string {
+	if named, ok := runnable.(Named); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", runnable)
+}
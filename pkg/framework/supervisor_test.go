@@ -0,0 +1,167 @@
+package framework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errTestRunnable = errors.New("test failure")
+
+// testRunnable fails the first failures runs, then blocks until canceled.
+type testRunnable struct {
+	name     string
+	failures int32
+	runs     int32
+}
+
+func (r *testRunnable) Name() string { return r.name }
+
+func (r *testRunnable) Run(ctx context.Context) error {
+	if atomic.AddInt32(&r.runs, 1) <= r.failures {
+		return errTestRunnable
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (r *testRunnable) Runs() int {
+	return int(atomic.LoadInt32(&r.runs))
+}
+
+func findState(states []RunnableState, name string) RunnableState {
+	for _, state := range states {
+		if state.Name == name {
+			return state
+		}
+	}
+	return RunnableState{}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		require.True(t, time.Now().Before(deadline), "wait timeout")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	require.Equal(t, 10*time.Millisecond, b.Delay(1))
+	require.Equal(t, 20*time.Millisecond, b.Delay(2))
+	require.Equal(t, 40*time.Millisecond, b.Delay(3))
+	require.Equal(t, 50*time.Millisecond, b.Delay(4))
+	require.Equal(t, 50*time.Millisecond, b.Delay(100))
+}
+
+func TestRunnerSuperviseOneForOne(t *testing.T) {
+	s := NewSupervisor(RestartOnFailure, OneForOne)
+	s.Backoff = Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond}
+	flaky := &testRunnable{name: "flaky", failures: 2}
+	stable := &testRunnable{name: "stable"}
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := NewRunnerWith(ctx).Supervise(s).Go(flaky, stable)
+
+	waitFor(t, func() bool { return flaky.Runs() == 3 })
+	state := findState(runner.States(), "flaky")
+	require.Equal(t, 2, state.Restarts)
+	require.Equal(t, errTestRunnable, state.LastErr)
+	require.Equal(t, 1, stable.Runs())
+	require.Equal(t, 0, findState(runner.States(), "stable").Restarts)
+
+	cancel()
+	require.NoError(t, runner.Wait())
+	require.Empty(t, runner.States())
+}
+
+func TestRunnerSuperviseOneForAll(t *testing.T) {
+	s := NewSupervisor(RestartOnFailure, OneForAll)
+	s.Backoff = Backoff{Initial: time.Millisecond}
+	flaky := &testRunnable{name: "flaky", failures: 1}
+	sibling := &testRunnable{name: "sibling"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := NewRunnerWith(ctx).Supervise(s).Go(sibling)
+	waitFor(t, func() bool { return sibling.Runs() == 1 })
+	runner.Go(flaky)
+
+	waitFor(t, func() bool { return flaky.Runs() == 2 && sibling.Runs() == 2 })
+	require.Equal(t, 1, findState(runner.States(), "sibling").Restarts)
+}
+
+func TestRunnerSuperviseMaxRestarts(t *testing.T) {
+	s := NewSupervisor(RestartAlways, OneForOne)
+	s.Backoff = Backoff{Initial: time.Millisecond}
+	s.MaxRestarts = 2
+	failing := &testRunnable{name: "failing", failures: 100}
+	runner := NewRunner().Supervise(s).Go(failing)
+	require.EqualError(t, runner.Wait(), "Multiple errors:\ntest failure")
+	require.Equal(t, 3, failing.Runs())
+	state := findState(runner.States(), "failing")
+	require.Equal(t, RunnableFailed, state.Status)
+	require.Equal(t, 2, state.Restarts)
+}
+
+// slowFailure fails after running for a while.
+type slowFailure struct {
+	runs int32
+}
+
+func (r *slowFailure) Run(ctx context.Context) error {
+	atomic.AddInt32(&r.runs, 1)
+	time.Sleep(5 * time.Millisecond)
+	return errTestRunnable
+}
+
+func TestSupervisorStableUptime(t *testing.T) {
+	s := NewSupervisor(RestartOnFailure, OneForOne)
+	s.Backoff = Backoff{Initial: time.Millisecond}
+	s.MaxRestarts = 1
+	r := &slowFailure{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// running longer than Backoff.Initial resets consecutive restarts.
+	s.Start(ctx, r, func(error) {})
+	waitFor(t, func() bool { return atomic.LoadInt32(&r.runs) >= 3 })
+
+	s = NewSupervisor(RestartOnFailure, OneForOne)
+	s.Backoff = Backoff{Initial: time.Millisecond}
+	s.MaxRestarts = 1
+	s.StableUptime = time.Hour
+	r = &slowFailure{}
+	doneCh := make(chan error, 1)
+	s.Start(ctx, r, func(err error) { doneCh <- err })
+	require.Equal(t, errTestRunnable, <-doneCh)
+	require.Equal(t, int32(2), atomic.LoadInt32(&r.runs))
+}
+
+func TestSupervisorFinishedStates(t *testing.T) {
+	s := NewSupervisor(RestartNever, OneForOne)
+	doneCh := make(chan error, MaxFinishedStates+2)
+	for n := 0; n < MaxFinishedStates+2; n++ {
+		s.Start(context.Background(), &testRunnable{name: fmt.Sprintf("r%d", n), failures: 1}, func(err error) {
+			doneCh <- err
+		})
+		<-doneCh
+	}
+	states := s.States()
+	require.Len(t, states, MaxFinishedStates)
+	require.Equal(t, "r2", states[0].Name)
+	require.Equal(t, RunnableFailed, states[0].Status)
+	require.Empty(t, s.children)
+}
+
+func TestLoopSupervisorFailure(t *testing.T) {
+	loop := NewLoop()
+	loop.Supervisor = NewSupervisor(RestartNever, OneForOne)
+	loop.AddRunnable(&testRunnable{name: "failing", failures: 1})
+	err := loop.Run(context.Background())
+	require.EqualError(t, err, "runnable failing failed: test failure")
+	require.Equal(t, RunnableFailed, findState(loop.RunnableStates(), "failing").Status)
+}
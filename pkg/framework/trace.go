@@ -0,0 +1,365 @@
+package framework
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A trace file starts with a header of magic "FXTRC", a version byte and
+// the start time in Unix nanoseconds (int64, big endian), followed by
+// records of iterations. All integers in a record are varints, and the time
+// is the nanoseconds elapsed since the previous record (or start). Type
+// names of messages and controllers are interned: a known string is
+// written as its index, otherwise as 0 followed by the length and bytes.
+
+const (
+	traceMagic   = "FXTRC"
+	traceVersion = 1
+)
+
+// ErrBadTraceFormat indicates the trace file is malformed.
+var ErrBadTraceFormat = errors.New("bad trace format")
+
+// TraceRecord is the trace of an iteration.
+type TraceRecord struct {
+	Time time.Time
+	// Messages are delivered to the iteration.
+	Messages []TracedMessage
+	// Controllers are the ones which took or added messages or
+	// failed in the iteration, in the order of execution.
+	Controllers []ControllerTrace
+}
+
+// TracedMessage is a message in the trace.
+type TracedMessage struct {
+	// ID identifies the message within an iteration.
+	ID int
+	// Type is the type name of the message.
+	Type string
+	// Data is the message encoded by MessageCodec, empty if unavailable.
+	Data []byte
+	// Msg is the message when recording, or the decoded message when
+	// reading if a MessageCodec is provided.
+	Msg Message
+}
+
+// ControllerTrace is the trace of a controller in an iteration.
+type ControllerTrace struct {
+	PriorityLevel int
+	Name          string
+	Taken         []int // IDs of messages taken
+	Added         []TracedMessage
+	Err           string // error returned or panic
+}
+
+// Tracer records iterations.
+type Tracer interface {
+	TraceIteration(*TraceRecord) error
+}
+
+// MessageCodec encodes messages to be replayed.
+type MessageCodec interface {
+	EncodeMessage(Message) ([]byte, error)
+	DecodeMessage([]byte) (Message, error)
+}
+
+// TraceWriter is a Tracer writing records to a trace file.
+type TraceWriter struct {
+	// Codec encodes messages, only type names are recorded if nil.
+	Codec MessageCodec
+
+	w       *bufio.Writer
+	last    time.Time
+	strings map[string]uint64
+	buf     []byte
+	lock    sync.Mutex
+}
+
+// NewTraceWriter creates a TraceWriter and writes the header.
+func NewTraceWriter(w io.Writer, codec MessageCodec) (*TraceWriter, error) {
+	tw := &TraceWriter{
+		Codec:   codec,
+		w:       bufio.NewWriter(w),
+		last:    time.Now(),
+		strings: make(map[string]uint64),
+	}
+	var head [len(traceMagic) + 9]byte
+	copy(head[:], traceMagic)
+	head[len(traceMagic)] = traceVersion
+	binary.BigEndian.PutUint64(head[len(traceMagic)+1:], uint64(tw.last.UnixNano()))
+	if _, err := tw.w.Write(head[:]); err != nil {
+		return nil, err
+	}
+	return tw, tw.w.Flush()
+}
+
+// TraceIteration implements Tracer.
+func (w *TraceWriter) TraceIteration(r *TraceRecord) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf = w.buf[:0]
+	w.putInt(int64(r.Time.Sub(w.last)))
+	w.last = r.Time
+	w.putMessages(r.Messages)
+	w.putUint(uint64(len(r.Controllers)))
+	for _, ctl := range r.Controllers {
+		w.putUint(uint64(ctl.PriorityLevel))
+		w.putString(ctl.Name)
+		w.putUint(uint64(len(ctl.Taken)))
+		for _, id := range ctl.Taken {
+			w.putUint(uint64(id))
+		}
+		w.putMessages(ctl.Added)
+		w.putBytes([]byte(ctl.Err))
+	}
+	if _, err := w.w.Write(w.buf); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *TraceWriter) putMessages(msgs []TracedMessage) {
+	w.putUint(uint64(len(msgs)))
+	for _, msg := range msgs {
+		w.putUint(uint64(msg.ID))
+		typ, data := msg.Type, msg.Data
+		if msg.Msg != nil {
+			typ = fmt.Sprintf("%T", msg.Msg)
+			if w.Codec != nil {
+				data, _ = w.Codec.EncodeMessage(msg.Msg)
+			}
+		}
+		w.putString(typ)
+		w.putBytes(data)
+	}
+}
+
+func (w *TraceWriter) putUint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (w *TraceWriter) putInt(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func (w *TraceWriter) putBytes(data []byte) {
+	w.putUint(uint64(len(data)))
+	w.buf = append(w.buf, data...)
+}
+
+func (w *TraceWriter) putString(str string) {
+	if index, ok := w.strings[str]; ok {
+		w.putUint(index)
+		return
+	}
+	w.strings[str] = uint64(len(w.strings) + 1)
+	w.putUint(0)
+	w.putBytes([]byte(str))
+}
+
+// TraceReader reads records from a trace file.
+type TraceReader struct {
+	// Codec decodes messages into TracedMessage.Msg if not nil.
+	Codec MessageCodec
+
+	r       *bufio.Reader
+	start   time.Time
+	last    time.Time
+	strings []string
+}
+
+// NewTraceReader creates a TraceReader and reads the header.
+func NewTraceReader(r io.Reader, codec MessageCodec) (*TraceReader, error) {
+	tr := &TraceReader{Codec: codec, r: bufio.NewReader(r)}
+	var head [len(traceMagic) + 9]byte
+	if _, err := io.ReadFull(tr.r, head[:]); err != nil {
+		return nil, err
+	}
+	if string(head[:len(traceMagic)]) != traceMagic || head[len(traceMagic)] != traceVersion {
+		return nil, ErrBadTraceFormat
+	}
+	tr.start = time.Unix(0, int64(binary.BigEndian.Uint64(head[len(traceMagic)+1:])))
+	tr.last = tr.start
+	return tr, nil
+}
+
+// Start returns the start time.
+func (r *TraceReader) Start() time.Time {
+	return r.start
+}
+
+// ReadRecord reads the next record. It returns io.EOF at the end.
+func (r *TraceReader) ReadRecord() (*TraceRecord, error) {
+	elapsed, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+	rec := &TraceRecord{Time: r.last.Add(time.Duration(elapsed))}
+	r.last = rec.Time
+	if rec.Messages, err = r.readMessages(); err != nil {
+		return nil, badTrace(err)
+	}
+	count, err := r.readCount()
+	if err != nil {
+		return nil, badTrace(err)
+	}
+	for ; count > 0; count-- {
+		var ctl ControllerTrace
+		level, err := binary.ReadUvarint(r.r)
+		if err != nil {
+			return nil, badTrace(err)
+		}
+		ctl.PriorityLevel = int(level)
+		if ctl.Name, err = r.readString(); err != nil {
+			return nil, badTrace(err)
+		}
+		taken, err := r.readCount()
+		if err != nil {
+			return nil, badTrace(err)
+		}
+		for ; taken > 0; taken-- {
+			id, err := binary.ReadUvarint(r.r)
+			if err != nil {
+				return nil, badTrace(err)
+			}
+			ctl.Taken = append(ctl.Taken, int(id))
+		}
+		if ctl.Added, err = r.readMessages(); err != nil {
+			return nil, badTrace(err)
+		}
+		errMsg, err := r.readBytes()
+		if err != nil {
+			return nil, badTrace(err)
+		}
+		ctl.Err = string(errMsg)
+		rec.Controllers = append(rec.Controllers, ctl)
+	}
+	return rec, nil
+}
+
+func (r *TraceReader) readMessages() ([]TracedMessage, error) {
+	count, err := r.readCount()
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	msgs := make([]TracedMessage, count)
+	for n := range msgs {
+		id, err := binary.ReadUvarint(r.r)
+		if err != nil {
+			return nil, err
+		}
+		msgs[n].ID = int(id)
+		if msgs[n].Type, err = r.readString(); err != nil {
+			return nil, err
+		}
+		if msgs[n].Data, err = r.readBytes(); err != nil {
+			return nil, err
+		}
+		if r.Codec != nil && len(msgs[n].Data) > 0 {
+			if msgs[n].Msg, err = r.Codec.DecodeMessage(msgs[n].Data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return msgs, nil
+}
+
+func (r *TraceReader) readCount() (int, error) {
+	count, err := binary.ReadUvarint(r.r)
+	if err == nil && count > 1<<20 {
+		err = ErrBadTraceFormat
+	}
+	return int(count), err
+}
+
+func (r *TraceReader) readBytes() ([]byte, error) {
+	size, err := r.readCount()
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(r.r, data)
+	return data, err
+}
+
+func (r *TraceReader) readString() (string, error) {
+	index, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return "", err
+	}
+	if index > 0 {
+		if index > uint64(len(r.strings)) {
+			return "", ErrBadTraceFormat
+		}
+		return r.strings[index-1], nil
+	}
+	str, err := r.readBytes()
+	if err != nil {
+		return "", err
+	}
+	r.strings = append(r.strings, string(str))
+	return string(str), nil
+}
+
+// badTrace converts io.EOF in the middle of a record.
+func badTrace(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Replay feeds the messages and timestamps of recorded iterations into the
+// loop, and runs an iteration for each record synchronously. Runnables are
+// not started, and messages posted by controllers are discarded, as they
+// are recorded and fed in later iterations. Messages without decoded Msg
+// are skipped.
+// The Clock of the loop is replaced by a SimClock starting at the time of
+// the first record if it's not one.
+// It must not be used concurrently with Run.
+func (l *Loop) Replay(ctx context.Context, r *TraceReader) error {
+	clock, _ := l.Clock.(*SimClock)
+	l.init()
+	l.lock.Lock()
+	l.replaying = true
+	l.lock.Unlock()
+	defer func() {
+		l.lock.Lock()
+		l.replaying = false
+		l.lock.Unlock()
+	}()
+	for ctx.Err() == nil {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if clock == nil {
+			clock = NewSimClock(rec.Time)
+			l.Clock = clock
+		}
+		if d := rec.Time.Sub(clock.Now()); d > 0 {
+			clock.Advance(d)
+		}
+		l.lock.Lock()
+		for _, msg := range rec.Messages {
+			if msg.Msg != nil {
+				l.messages.append(&messageItem{msg: msg.Msg})
+				l.pending++
+			}
+		}
+		l.lock.Unlock()
+		l.runIteration(ctx, time.Time{})
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,125 @@
+package framework
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testMsgCodec struct{}
+
+func (testMsgCodec) EncodeMessage(msg Message) ([]byte, error) {
+	if m, ok := msg.(*testMsg); ok {
+		return []byte{byte(m.n)}, nil
+	}
+	return nil, errors.New("unknown message")
+}
+
+func (testMsgCodec) DecodeMessage(data []byte) (Message, error) {
+	return &testMsg{n: int(data[0])}, nil
+}
+
+func newTracedLoop(start time.Time) *Loop {
+	loop := NewLoop()
+	loop.Clock = NewSimClock(start)
+	loop.AddController(PrLvControl, ControlFunc(func(cc ControlContext) error {
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			if m, ok := mctx.CurrentMessage().(*testMsg); ok && m.n%2 == 0 {
+				mctx.MessageTaken()
+				mctx.AddMessages(&testMsg{n: m.n + 1})
+				cc.PostMessage(&testMsg{n: m.n + 10})
+			}
+		}))
+		return nil
+	}))
+	loop.AddController(PrLvAcuate, ControlFunc(func(cc ControlContext) error {
+		var err error
+		cc.Messages().ProcessMessages(ProcessMessageFunc(func(mctx MessageProcessingContext) {
+			if m, ok := mctx.CurrentMessage().(*testMsg); ok && m.n == 3 {
+				err = errors.New("three")
+			}
+		}))
+		return err
+	}))
+	return loop
+}
+
+func readTrace(t *testing.T, data []byte) []*TraceRecord {
+	r, err := NewTraceReader(bytes.NewReader(data), nil)
+	require.NoError(t, err)
+	var recs []*TraceRecord
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			return recs
+		}
+		require.NoError(t, err)
+		recs = append(recs, rec)
+	}
+}
+
+func TestTraceAndReplay(t *testing.T) {
+	start := time.Unix(1000, 0)
+	var buf bytes.Buffer
+	tw, err := NewTraceWriter(&buf, testMsgCodec{})
+	require.NoError(t, err)
+	loop := newTracedLoop(start)
+	loop.Tracer = tw
+	loop.PostMessage(&testMsg{n: 2})
+	loop.PostMessage(&struct{ testMsg }{})
+	loop.Step(context.Background(), 3)
+
+	recs := readTrace(t, buf.Bytes())
+	require.Len(t, recs, 3)
+	require.Equal(t, start.Add(100*time.Millisecond), recs[0].Time)
+	require.Equal(t, []TracedMessage{
+		{ID: 0, Type: "*framework.testMsg", Data: []byte{2}},
+		{ID: 1, Type: "*struct { framework.testMsg }"},
+	}, recs[0].Messages)
+	require.Equal(t, []ControllerTrace{
+		{
+			PriorityLevel: PrLvControl,
+			Name:          "framework.ControlFunc",
+			Taken:         []int{0},
+			Added:         []TracedMessage{{ID: 2, Type: "*framework.testMsg", Data: []byte{3}}},
+		},
+		{PriorityLevel: PrLvAcuate, Name: "framework.ControlFunc", Err: "three"},
+	}, recs[0].Controllers)
+	require.Equal(t, []TracedMessage{{ID: 0, Type: "*framework.testMsg", Data: []byte{12}}}, recs[1].Messages)
+	require.Len(t, recs[1].Controllers, 1)
+	require.Equal(t, []TracedMessage{{ID: 0, Type: "*framework.testMsg", Data: []byte{22}}}, recs[2].Messages)
+
+	var replayed bytes.Buffer
+	r, err := NewTraceReader(bytes.NewReader(buf.Bytes()), testMsgCodec{})
+	require.NoError(t, err)
+	loop = newTracedLoop(time.Unix(0, 0))
+	loop.Clock = nil
+	loop.Tracer, err = NewTraceWriter(&replayed, testMsgCodec{})
+	require.NoError(t, err)
+	require.NoError(t, loop.Replay(context.Background(), r))
+	require.Equal(t, start.Add(300*time.Millisecond), loop.Clock.Now())
+
+	// the message without data is not replayed.
+	recs[0].Messages = recs[0].Messages[:1]
+	recs[0].Controllers[0].Added[0].ID = 1
+	require.Equal(t, recs, readTrace(t, replayed.Bytes()))
+}
+
+func TestTraceBadFormat(t *testing.T) {
+	_, err := NewTraceReader(bytes.NewReader([]byte("L0CAP\x01\x00\x00\x00\x00\x00\x00\x00\x00")), nil)
+	require.Equal(t, ErrBadTraceFormat, err)
+
+	var buf bytes.Buffer
+	tw, err := NewTraceWriter(&buf, nil)
+	require.NoError(t, err)
+	require.NoError(t, tw.TraceIteration(&TraceRecord{Time: time.Now(), Messages: []TracedMessage{{Msg: &testMsg{}}}}))
+	r, err := NewTraceReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), nil)
+	require.NoError(t, err)
+	_, err = r.ReadRecord()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -100,6 +100,14 @@ type LoopControl interface {
 	TriggerNext()
 }
 
+// MessageTTLPoster is optionally implemented by LoopControl, e.g. Loop,
+// to post messages with TTL.
+type MessageTTLPoster interface {
+	// PostMessageTTL enqueues the message which expires if not
+	// delivered to an iteration within the TTL.
+	PostMessageTTL(Message, time.Duration)
+}
+
 // MessageStore provides read/write access to a list of messages.
 type MessageStore interface {
 	// ProcessMessages uses a processor to process all messages.
@@ -22,6 +22,7 @@ type Controller struct {
 	DeviceIndex int
 	Verbose     bool
 
+	loop        *fx.Loop
 	conn        *connection
 	eventCh     chan device.Event
 	device      device.Device
@@ -29,20 +30,32 @@ type Controller struct {
 
 	status        msgs.JoystickStatus
 	statusChanged bool
+	dispatcher    *l1.Dispatcher
 }
 
 // NewController creates a Controller.
 func NewController(e *env.Env) *Controller {
-	return &Controller{
+	c := &Controller{
 		Env:           e,
 		DeviceIndex:   defaultConfig.DeviceIndex,
 		Verbose:       defaultConfig.Verbose,
 		statusChanged: true,
 	}
+	c.dispatcher = l1.NewDispatcher().
+		OnCommand(func(cc fx.ControlContext, m *msgs.JoystickStatusQuery) (fx.Message, error) {
+			return &msgs.JoystickStatusReply{Status: &c.status}, nil
+		}).
+		OnCommand(func(cc fx.ControlContext, m *msgs.JoystickConnect) (fx.Message, error) {
+			return c.connect(cc, m), nil
+		}).
+		OnMessage(c.handleEvent).
+		OnMessage(c.handleStatus)
+	return c
 }
 
 // AddToLoop implements LoopAdder.
 func (c *Controller) AddToLoop(loop *fx.Loop) {
+	c.loop = loop
 	loop.AddRunnable(c)
 	loop.AddController(fx.PrLvControl, c)
 	loop.AddController(fx.PrLvPostProc, fx.ControlFunc(c.notifyStatusChange))
@@ -111,45 +124,34 @@ func (c *Controller) Run(ctx context.Context) error {
 
 // Control implements Controller.
 func (c *Controller) Control(cc fx.ControlContext) error {
-	cc.Messages().ProcessMessages(fx.ProcessMessageFunc(func(mctx fx.MessageProcessingContext) {
-		switch msg := mctx.CurrentMessage().(type) {
-		case *l1.CommandMsg:
-			switch m := msg.Command.Msg().(type) {
-			case *msgs.JoystickStatusQuery:
-				mctx.MessageTaken()
-				msg.Command.Done(&msgs.JoystickStatusReply{Status: &c.status})
-			case *msgs.JoystickConnect:
-				mctx.MessageTaken()
-				msg.Command.Done(c.connect(cc, m))
-			}
-		case *eventMsg:
-			mctx.MessageTaken()
-			if conn := c.conn; conn != nil {
-				conn.loop.PostMessage(msg)
-				conn.loop.TriggerNext()
-			} else {
-				log.Println("Controller not connected.")
-			}
-		case *statusMsg:
-			if msg.device != nil {
-				if msg.device.Index == 0xffffffff {
-					c.status.Device = nil
-				} else {
-					c.status.Device = msg.device
-				}
-				c.statusChanged = true
-			}
-			if msg.conn != nil {
-				if msg.conn.Type == "" {
-					c.status.Connection = nil
-				} else {
-					c.status.Connection = msg.conn
-				}
-				c.statusChanged = true
-			}
+	return c.dispatcher.Control(cc)
+}
+
+func (c *Controller) handleEvent(cc fx.ControlContext, msg *eventMsg) {
+	if conn := c.conn; conn != nil {
+		conn.handleEventMsg(msg)
+	} else {
+		log.Println("Controller not connected.")
+	}
+}
+
+func (c *Controller) handleStatus(cc fx.ControlContext, msg *statusMsg) {
+	if msg.device != nil {
+		if msg.device.Index == 0xffffffff {
+			c.status.Device = nil
+		} else {
+			c.status.Device = msg.device
 		}
-	}))
-	return nil
+		c.statusChanged = true
+	}
+	if msg.conn != nil {
+		if msg.conn.Type == "" {
+			c.status.Connection = nil
+		} else {
+			c.status.Connection = msg.conn
+		}
+		c.statusChanged = true
+	}
 }
 
 func (c *Controller) notifyStatusChange(cc fx.ControlContext) error {
@@ -182,10 +184,9 @@ func (c *Controller) connect(cc fx.ControlContext, msg *msgs.JoystickConnect) fx
 	if err != nil {
 		return l1msgs.NewCommandErr(err)
 	}
-	if c.conn, err = newConnection(cc, connector, conf.Ref); err != nil {
+	if c.conn, err = newConnection(cc, c.loop, connector, conf.Ref); err != nil {
 		return l1msgs.NewCommandErr(err)
 	}
-	go c.conn.run()
 	cc.PostMessage(&statusMsg{conn: &msgs.JoystickConnect{
 		RegistryURL: conf.RegistryURL,
 		Type:        conf.Ref.Type,
@@ -236,38 +237,39 @@ type eventMsg struct {
 func (m *eventMsg) NewMessage() fx.Message { return &eventMsg{} }
 
 type capsMsg struct {
+	conn *connection
 	caps *l1msgs.Nav2DCaps
 }
 
 func (m *capsMsg) NewMessage() fx.Message { return &capsMsg{} }
 
 type connection struct {
-	ctx    context.Context
-	cancel func()
-	conn   l1.ControllerConn
-	loop   *fx.Loop
-	caps   *l1msgs.Nav2DCaps
+	ctx        context.Context
+	cancel     func()
+	conn       l1.ControllerConn
+	handle     *fx.ControllerHandle
+	dispatcher *l1.Dispatcher
+	caps       *l1msgs.Nav2DCaps
 }
 
-func newConnection(cc fx.ControlContext, connector l1.Connector, ref l1.ControllerRef) (c *connection, err error) {
+func newConnection(cc fx.ControlContext, loop *fx.Loop, connector l1.Connector, ref l1.ControllerRef) (c *connection, err error) {
 	c = &connection{}
+	c.dispatcher = l1.NewDispatcher().OnMessage(c.handleCaps)
 	c.ctx, c.cancel = context.WithCancel(cc.Context())
 	if c.conn, err = connector.Connect(c.ctx, ref); err != nil {
+		c.cancel()
 		return
 	}
-	c.loop = fx.NewLoop()
+	var adders []fx.LoopAdder
 	if adder, ok := c.conn.(fx.LoopAdder); ok {
-		c.loop.Add(adder)
+		adders = append(adders, adder)
 	}
-	c.loop.AddController(fx.PrLvControl, c)
+	c.handle = loop.AddGroup(adders...).AddController(fx.PrLvControl, c)
 	return
 }
 
-func (c *connection) run() {
-	c.loop.Run(c.ctx)
-}
-
 func (c *connection) close() {
+	c.handle.Remove()
 	c.cancel()
 }
 
@@ -318,33 +320,38 @@ func (c *connection) Run(ctx context.Context) error {
 			log.Printf("Nav2DCapsQuery error: %v", res.Err)
 		} else if caps, ok := res.Msg.(*l1msgs.Nav2DCaps); ok {
 			loopCtl := fx.LoopCtlFrom(ctx)
-			loopCtl.PostMessage(&capsMsg{caps: caps})
+			loopCtl.PostMessage(&capsMsg{conn: c, caps: caps})
 			loopCtl.TriggerNext()
 			break
 		} else {
 			log.Println("Nav2DCapsQuery got unknown response")
 		}
-		<-time.After(time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
 	}
 	return nil
 }
 
 // Control implements Controller.
 func (c *connection) Control(cc fx.ControlContext) error {
-	cc.Messages().ProcessMessages(fx.ProcessMessageFunc(func(mctx fx.MessageProcessingContext) {
-		switch msg := mctx.CurrentMessage().(type) {
-		case *eventMsg:
-			mctx.MessageTaken()
-			if msg.stopAll {
-				c.stopAll()
-			} else {
-				c.handleEvent(msg.event)
-			}
-		case *capsMsg:
-			mctx.MessageTaken()
-			log.Printf("Nav2DCaps available: %s", msg.caps.String())
-			c.caps = msg.caps
-		}
-	}))
-	return nil
+	return c.dispatcher.Control(cc)
+}
+
+func (c *connection) handleEventMsg(msg *eventMsg) {
+	if msg.stopAll {
+		c.stopAll()
+	} else {
+		c.handleEvent(msg.event)
+	}
+}
+
+func (c *connection) handleCaps(cc fx.ControlContext, msg *capsMsg) {
+	// ignore the one from a previous connection.
+	if msg.conn == c {
+		log.Printf("Nav2DCaps available: %s", msg.caps.String())
+		c.caps = msg.caps
+	}
 }
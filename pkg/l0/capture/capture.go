@@ -0,0 +1,291 @@
+// Package capture records and replays the bytes transferred over an L0 link.
+//
+// A capture file starts with a header of magic "L0CAP", a version byte
+// and the start time in Unix nanoseconds (int64, big endian), followed by
+// records. Each record has a direction byte, the time elapsed since start
+// in nanoseconds (uvarint), the data length (uvarint, at most MaxRecordLen)
+// and the data.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+// Direction indicates the direction of captured data.
+type Direction byte
+
+// Directions.
+const (
+	// DirRecv is data received from the peer (read from the link).
+	DirRecv Direction = 'R'
+	// DirSend is data sent to the peer (written to the link).
+	DirSend Direction = 'S'
+)
+
+// String implements Stringer.
+func (d Direction) String() string {
+	return string(d)
+}
+
+const (
+	magic   = "L0CAP"
+	version = 1
+)
+
+// MaxRecordLen is the max data length of a record, enough for the
+// largest fragmented packet. Longer data is written in multiple records.
+const MaxRecordLen = comm.MaxFragmentedDataLen + comm.MaxPacketLen
+
+// DefaultFlushInterval is the default max delay before Tap flushes records.
+const DefaultFlushInterval = time.Second
+
+var (
+	// ErrBadFormat indicates the capture file is malformed.
+	ErrBadFormat = errors.New("bad capture format")
+)
+
+// Record is a piece of captured data.
+type Record struct {
+	Time time.Time
+	Dir  Direction
+	Data []byte
+}
+
+// Writer writes records to a capture file.
+type Writer struct {
+	w     *bufio.Writer
+	start time.Time
+	lock  sync.Mutex
+}
+
+// NewWriter creates a Writer and writes the header with current time as start.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return NewWriterAt(w, time.Now())
+}
+
+// NewWriterAt creates a Writer with a specified start time.
+func NewWriterAt(w io.Writer, start time.Time) (*Writer, error) {
+	cw := &Writer{w: bufio.NewWriter(w), start: start}
+	var head [len(magic) + 9]byte
+	copy(head[:], magic)
+	head[len(magic)] = version
+	binary.BigEndian.PutUint64(head[len(magic)+1:], uint64(start.UnixNano()))
+	if _, err := cw.w.Write(head[:]); err != nil {
+		return nil, err
+	}
+	return cw, cw.w.Flush()
+}
+
+// Start returns the start time.
+func (w *Writer) Start() time.Time {
+	return w.start
+}
+
+// WriteRecord writes a record. Records must be written in time order.
+// Records are buffered until Flush.
+func (w *Writer) WriteRecord(rec *Record) error {
+	elapsed := rec.Time.Sub(w.start)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	data := rec.Data
+	for {
+		chunk := data
+		if len(chunk) > MaxRecordLen {
+			chunk = chunk[:MaxRecordLen]
+		}
+		var head [1 + 2*binary.MaxVarintLen64]byte
+		head[0] = byte(rec.Dir)
+		n := 1 + binary.PutUvarint(head[1:], uint64(elapsed))
+		n += binary.PutUvarint(head[n:], uint64(len(chunk)))
+		if _, err := w.w.Write(head[:n]); err != nil {
+			return err
+		}
+		if _, err := w.w.Write(chunk); err != nil {
+			return err
+		}
+		if data = data[len(chunk):]; len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// Flush writes buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.w.Flush()
+}
+
+// Reader reads records from a capture file.
+type Reader struct {
+	r     *bufio.Reader
+	start time.Time
+}
+
+// NewReader creates a Reader and reads the header.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReader(r)}
+	var head [len(magic) + 9]byte
+	if _, err := io.ReadFull(cr.r, head[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrBadFormat
+		}
+		return nil, err
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, ErrBadFormat
+	}
+	if v := head[len(magic)]; v != version {
+		return nil, fmt.Errorf("unsupported capture version %d", v)
+	}
+	cr.start = time.Unix(0, int64(binary.BigEndian.Uint64(head[len(magic)+1:])))
+	return cr, nil
+}
+
+// Start returns the start time.
+func (r *Reader) Start() time.Time {
+	return r.start
+}
+
+// ReadRecord reads the next record. It returns io.EOF at the end.
+func (r *Reader) ReadRecord() (*Record, error) {
+	dir, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if Direction(dir) != DirRecv && Direction(dir) != DirSend {
+		return nil, ErrBadFormat
+	}
+	elapsed, err := binary.ReadUvarint(r.r)
+	if err == nil {
+		var size uint64
+		if size, err = binary.ReadUvarint(r.r); err == nil {
+			if size > MaxRecordLen {
+				return nil, ErrBadFormat
+			}
+			rec := &Record{
+				Time: r.start.Add(time.Duration(elapsed)),
+				Dir:  Direction(dir),
+				Data: make([]byte, size),
+			}
+			if _, err = io.ReadFull(r.r, rec.Data); err == nil {
+				return rec, nil
+			}
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// Tap wraps an io.ReadWriter and records data in both directions.
+// Failures of writing records don't affect the wrapped ReadWriter,
+// and the first one is available from Err. Records are flushed
+// within FlushInterval, and on Close.
+type Tap struct {
+	ReadWriter    io.ReadWriter
+	Writer        *Writer
+	FlushInterval time.Duration // DefaultFlushInterval if zero
+
+	err    error
+	timer  *time.Timer
+	closed bool
+	lock   sync.Mutex
+}
+
+// NewTap creates a Tap.
+func NewTap(rw io.ReadWriter, w *Writer) *Tap {
+	return &Tap{ReadWriter: rw, Writer: w}
+}
+
+// Read implements io.Reader.
+func (t *Tap) Read(p []byte) (int, error) {
+	n, err := t.ReadWriter.Read(p)
+	if n > 0 {
+		t.record(DirRecv, p[:n])
+	}
+	return n, err
+}
+
+// Write implements io.Writer.
+func (t *Tap) Write(p []byte) (int, error) {
+	n, err := t.ReadWriter.Write(p)
+	if n > 0 {
+		t.record(DirSend, p[:n])
+	}
+	return n, err
+}
+
+// Close implements io.Closer. It flushes records, and closes the
+// wrapped ReadWriter if it implements io.Closer.
+func (t *Tap) Close() error {
+	t.lock.Lock()
+	t.closed = true
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.lock.Unlock()
+	t.flush()
+	var err error
+	if closer, ok := t.ReadWriter.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if err == nil {
+		err = t.Err()
+	}
+	return err
+}
+
+// Err returns the first error of writing records.
+func (t *Tap) Err() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.err
+}
+
+func (t *Tap) record(dir Direction, data []byte) {
+	t.setErr(t.Writer.WriteRecord(&Record{Time: time.Now(), Dir: dir, Data: data}))
+	t.lock.Lock()
+	if t.timer == nil && !t.closed {
+		interval := t.FlushInterval
+		if interval <= 0 {
+			interval = DefaultFlushInterval
+		}
+		t.timer = time.AfterFunc(interval, t.flushTimer)
+	}
+	t.lock.Unlock()
+}
+
+func (t *Tap) flushTimer() {
+	t.lock.Lock()
+	t.timer = nil
+	t.lock.Unlock()
+	t.flush()
+}
+
+func (t *Tap) flush() {
+	t.setErr(t.Writer.Flush())
+}
+
+func (t *Tap) setErr(err error) {
+	if err != nil {
+		t.lock.Lock()
+		if t.err == nil {
+			t.err = err
+		}
+		t.lock.Unlock()
+	}
+}
@@ -0,0 +1,225 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+type bufReadWriter struct {
+	in  *bytes.Buffer
+	out bytes.Buffer
+}
+
+func (b *bufReadWriter) Read(p []byte) (int, error) {
+	return b.in.Read(p)
+}
+
+func (b *bufReadWriter) Write(p []byte) (int, error) {
+	return b.out.Write(p)
+}
+
+func TestWriterReader(t *testing.T) {
+	start := time.Unix(100, 500)
+	recs := []*Record{
+		{Time: start, Dir: DirSend, Data: []byte{0xff, 1}},
+		{Time: start.Add(time.Millisecond), Dir: DirRecv, Data: []byte{0xfe}},
+		{Time: start.Add(time.Hour), Dir: DirRecv, Data: bytes.Repeat([]byte{1}, 300)},
+	}
+	var buf bytes.Buffer
+	w, err := NewWriterAt(&buf, start)
+	require.NoError(t, err)
+	for _, rec := range recs {
+		require.NoError(t, w.WriteRecord(rec))
+	}
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	require.True(t, start.Equal(r.Start()))
+	for _, expected := range recs {
+		rec, err := r.ReadRecord()
+		require.NoError(t, err)
+		require.True(t, expected.Time.Equal(rec.Time))
+		require.Equal(t, expected.Dir, rec.Dir)
+		require.Equal(t, expected.Data, rec.Data)
+	}
+	_, err = r.ReadRecord()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestReaderErrors(t *testing.T) {
+	_, err := NewReader(bytes.NewBufferString("L0CA"))
+	require.Equal(t, ErrBadFormat, err)
+	_, err = NewReader(bytes.NewBufferString("XXCAP\x01\x00\x00\x00\x00\x00\x00\x00\x00"))
+	require.Equal(t, ErrBadFormat, err)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteRecord(&Record{Time: time.Now(), Dir: DirRecv, Data: []byte{1, 2}}))
+	require.NoError(t, w.Flush())
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	require.NoError(t, err)
+	_, err = r.ReadRecord()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// corrupt length.
+	header := buf.Bytes()[:len(magic)+9]
+	corrupt := append(append([]byte{}, header...), byte(DirRecv), 0, 0xff, 0xff, 0xff, 0xff, 0x0f)
+	r, err = NewReader(bytes.NewReader(corrupt))
+	require.NoError(t, err)
+	_, err = r.ReadRecord()
+	require.Equal(t, ErrBadFormat, err)
+}
+
+func TestWriterSplitRecord(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte{1}, MaxRecordLen+1)
+	now := time.Now()
+	require.NoError(t, w.WriteRecord(&Record{Time: now, Dir: DirSend, Data: data}))
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	rec, err := r.ReadRecord()
+	require.NoError(t, err)
+	require.Len(t, rec.Data, MaxRecordLen)
+	rec, err = r.ReadRecord()
+	require.NoError(t, err)
+	require.Equal(t, DirSend, rec.Dir)
+	require.Equal(t, []byte{1}, rec.Data)
+	_, err = r.ReadRecord()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestTap(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	rw := &bufReadWriter{in: bytes.NewBuffer([]byte{0xfe, 1})}
+	tap := NewTap(rw, w)
+
+	_, err = tap.Write([]byte{0xff, 1})
+	require.NoError(t, err)
+	p := make([]byte, 4)
+	n, err := tap.Read(p)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, tap.Err())
+	require.Equal(t, []byte{0xff, 1}, rw.out.Bytes())
+	require.NoError(t, tap.Close())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	rec, err := r.ReadRecord()
+	require.NoError(t, err)
+	require.Equal(t, DirSend, rec.Dir)
+	require.Equal(t, []byte{0xff, 1}, rec.Data)
+	rec, err = r.ReadRecord()
+	require.NoError(t, err)
+	require.Equal(t, DirRecv, rec.Dir)
+	require.Equal(t, []byte{0xfe, 1}, rec.Data)
+}
+
+type lockedBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Len()
+}
+
+func TestTapFlushInterval(t *testing.T) {
+	var buf lockedBuffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	size := buf.Len()
+	tap := NewTap(&bufReadWriter{in: &bytes.Buffer{}}, w)
+	tap.FlushInterval = time.Millisecond
+	_, err = tap.Write([]byte{0xff, 1})
+	require.NoError(t, err)
+	deadline := time.Now().Add(time.Second)
+	for buf.Len() == size {
+		require.True(t, time.Now().Before(deadline), "wait flush timeout")
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, tap.Close())
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Unix(0, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	var buf bytes.Buffer
+	w, err := NewWriterAt(&buf, start)
+	require.NoError(t, err)
+	for _, rec := range []*Record{
+		{Time: at(0), Dir: DirSend, Data: []byte{comm.SyncREQ, 1}},
+		{Time: at(1), Dir: DirRecv, Data: []byte{comm.SyncACK, 1}},
+		{Time: at(2), Dir: DirSend, Data: []byte{1, 0x12, 5}},
+		{Time: at(3), Dir: DirRecv, Data: []byte{1, 0x22, 1, 7}},
+		{Time: at(4), Dir: DirRecv, Data: []byte{2, 0x91}},
+		{Time: at(200), Dir: DirRecv, Data: []byte{comm.SyncACK, 2}},
+	} {
+		require.NoError(t, w.WriteRecord(rec))
+	}
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	var pkts []*comm.Packet
+	var timeouts []time.Time
+	replayer, err := Replay(r, ReplayOptions{Timeout: 100 * time.Millisecond}, func(ev *ReplayEvent) {
+		if ev.Packet != nil {
+			pkts = append(pkts, ev.Packet)
+		}
+		if ev.Timeout {
+			require.Equal(t, DirRecv, ev.Dir)
+			require.Equal(t, comm.SyncREQ, ev.Sync)
+			timeouts = append(timeouts, ev.Time)
+		}
+	})
+	require.NoError(t, err)
+	require.Equal(t, []*comm.Packet{
+		{Seq: 1, Code: 2, Data: []byte{5}},
+		{Seq: 1, Code: 2, Data: []byte{1, 7}},
+	}, pkts)
+	require.Equal(t, []time.Time{at(104)}, timeouts)
+	require.True(t, replayer.Parser(DirRecv).State().IsReady())
+	require.Equal(t, uint64(1), replayer.Parser(DirRecv).Stats().Timeouts)
+}
+
+func TestReplayReader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, w.WriteRecord(&Record{Time: now, Dir: DirRecv, Data: []byte{1, 2}}))
+	require.NoError(t, w.WriteRecord(&Record{Time: now, Dir: DirSend, Data: []byte{3}}))
+	require.NoError(t, w.WriteRecord(&Record{Time: now, Dir: DirRecv, Data: []byte{4}}))
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(NewReplayReader(r, DirRecv))
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 4}, data)
+}
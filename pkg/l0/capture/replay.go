@@ -0,0 +1,146 @@
+package capture
+
+import (
+	"io"
+	"time"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+// ReplayOptions defines options for Replayer.
+type ReplayOptions struct {
+	// CRC indicates packets are followed by CRC-8, same as comm.FIFO.CRC.
+	CRC bool
+	// Timeout simulates the sync timer of comm.FIFO from gaps between
+	// captured bytes. Zero disables timeout simulation.
+	Timeout time.Duration
+}
+
+// ReplayEvent is the result of replaying one byte or a simulated timeout.
+type ReplayEvent struct {
+	comm.ParseResult
+	Time time.Time
+	Dir  Direction
+	// Byte is the replayed byte, invalid if Timeout is true.
+	Byte byte
+	// Timeout indicates a simulated timeout.
+	Timeout bool
+	// Changed indicates State is different from the previous event
+	// in the same direction.
+	Changed bool
+}
+
+// Replayer feeds captured records through a comm.Parser per direction.
+// The DirRecv parser sees the link as the local FIFO does, and the DirSend
+// parser sees it as the peer does.
+type Replayer struct {
+	Options ReplayOptions
+
+	dirs map[Direction]*replayDir
+}
+
+type replayDir struct {
+	parser  comm.Parser
+	state   comm.SyncState
+	armed   bool
+	armedAt time.Time
+}
+
+// NewReplayer creates a Replayer.
+func NewReplayer(opts ReplayOptions) *Replayer {
+	r := &Replayer{Options: opts, dirs: make(map[Direction]*replayDir)}
+	for _, dir := range []Direction{DirRecv, DirSend} {
+		d := &replayDir{}
+		d.parser.CRC = opts.CRC
+		d.state = d.parser.State()
+		r.dirs[dir] = d
+	}
+	return r
+}
+
+// Parser gets the parser of a direction, mostly for stats.
+func (r *Replayer) Parser(dir Direction) *comm.Parser {
+	if d := r.dirs[dir]; d != nil {
+		return &d.parser
+	}
+	return nil
+}
+
+// Feed replays a record and reports each event to fn.
+func (r *Replayer) Feed(rec *Record, fn func(*ReplayEvent)) {
+	d := r.dirs[rec.Dir]
+	if d == nil {
+		return
+	}
+	for _, b := range rec.Data {
+		if r.Options.Timeout > 0 && d.armed {
+			if expireAt := d.armedAt.Add(r.Options.Timeout); rec.Time.After(expireAt) {
+				ev := &ReplayEvent{Time: expireAt, Dir: rec.Dir, Timeout: true}
+				ev.ParseResult = d.parser.Timeout()
+				r.apply(d, ev)
+				fn(ev)
+			}
+		}
+		ev := &ReplayEvent{Time: rec.Time, Dir: rec.Dir, Byte: b}
+		ev.ParseResult = d.parser.Parse(b)
+		r.apply(d, ev)
+		fn(ev)
+	}
+}
+
+func (r *Replayer) apply(d *replayDir, ev *ReplayEvent) {
+	ev.Changed = ev.State != d.state
+	d.state = ev.State
+	switch ev.WhatAboutTimer() {
+	case comm.TimerRestart:
+		d.armed, d.armedAt = true, ev.Time
+	case comm.TimerStop:
+		d.armed = false
+	}
+}
+
+// Replay reads all records from r and replays them.
+func Replay(r *Reader, opts ReplayOptions, fn func(*ReplayEvent)) (*Replayer, error) {
+	replayer := NewReplayer(opts)
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			return replayer, nil
+		}
+		if err != nil {
+			return replayer, err
+		}
+		replayer.Feed(rec, fn)
+	}
+}
+
+// ReplayReader is an io.Reader reading captured data of one direction,
+// which can be used to feed a comm.FIFO or comm.Parser directly.
+// Timing is not preserved.
+type ReplayReader struct {
+	Reader *Reader
+	Dir    Direction
+
+	buf []byte
+}
+
+// NewReplayReader creates a ReplayReader.
+func NewReplayReader(r *Reader, dir Direction) *ReplayReader {
+	return &ReplayReader{Reader: r, Dir: dir}
+}
+
+// Read implements io.Reader.
+func (r *ReplayReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		rec, err := r.Reader.ReadRecord()
+		if err != nil {
+			return 0, err
+		}
+		if rec.Dir == r.Dir {
+			r.buf = rec.Data
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,458 @@
+// Package codec encodes/decodes Go structs to/from L0 packet data.
+//
+// Fields are encoded in order with the wire format specified by the
+// struct tag "l0":
+//
+//	l0:"<type>[,le|be][,scale=<factor>]"
+//
+// type is one of u8, u16, u32, u64, i8, i16, i32, i64, f32, f64 and bytes.
+// If type is omitted, it's derived from the Go type of the field (bool is u8).
+// Byte order defaults to little endian.
+// With scale, the wire value is the field value divided by the factor and
+// rounded to the nearest integer, which is used for fixed-point values, e.g.
+// a float64 field in meters with "i16,scale=0.001" is sent in millimeters.
+// bytes is for []byte which consumes the remaining data and must be the last
+// field, or [N]byte which is fixed length.
+// Nested structs are encoded inline. Fields tagged with "-" and unexported
+// fields are skipped.
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrShortData indicates the data is shorter than expected.
+	ErrShortData = errors.New("short data")
+	// ErrOverflow indicates the value doesn't fit the wire type.
+	ErrOverflow = errors.New("value overflow")
+	// ErrNotStruct indicates the value is not a struct or pointer to struct.
+	ErrNotStruct = errors.New("not a struct")
+)
+
+// TagName is the name of struct tag.
+const TagName = "l0"
+
+type wireType int
+
+const (
+	wireUint wireType = iota
+	wireInt
+	wireFloat
+	wireBytes
+	wireStruct
+)
+
+type fieldCodec struct {
+	name   string
+	index  int
+	wire   wireType
+	size   int // 0 for variable length bytes.
+	order  binary.ByteOrder
+	scale  float64
+	fields []*fieldCodec // for wireStruct.
+}
+
+var (
+	typeCache     = make(map[reflect.Type][]*fieldCodec)
+	typeCacheLock sync.RWMutex
+)
+
+// Marshal encodes a struct into bytes.
+func Marshal(v interface{}) ([]byte, error) {
+	val := reflect.Indirect(reflect.ValueOf(v))
+	if val.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	fields, err := codecOf(val.Type())
+	if err != nil {
+		return nil, err
+	}
+	return encodeFields(nil, val, fields)
+}
+
+// Unmarshal decodes bytes into a struct pointed by v.
+// Extra data after all fields are decoded is ignored.
+func Unmarshal(data []byte, v interface{}) error {
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	fields, err := codecOf(ptr.Elem().Type())
+	if err != nil {
+		return err
+	}
+	_, err = decodeFields(data, ptr.Elem(), fields)
+	return err
+}
+
+func codecOf(typ reflect.Type) ([]*fieldCodec, error) {
+	typeCacheLock.RLock()
+	fields, ok := typeCache[typ]
+	typeCacheLock.RUnlock()
+	if ok {
+		return fields, nil
+	}
+	fields, err := buildCodec(typ)
+	if err != nil {
+		return nil, err
+	}
+	typeCacheLock.Lock()
+	typeCache[typ] = fields
+	typeCacheLock.Unlock()
+	return fields, nil
+}
+
+func buildCodec(typ reflect.Type) ([]*fieldCodec, error) {
+	var fields []*fieldCodec
+	for n := 0; n < typ.NumField(); n++ {
+		sf := typ.Field(n)
+		tag, tagged := sf.Tag.Lookup(TagName)
+		if tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+		if len(fields) > 0 && fields[len(fields)-1].wire == wireBytes && fields[len(fields)-1].size == 0 {
+			return nil, fmt.Errorf("%s.%s: field after variable length bytes", typ.Name(), sf.Name)
+		}
+		fc, err := parseField(sf, tag, tagged)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", typ.Name(), sf.Name, err)
+		}
+		fc.index = n
+		fields = append(fields, fc)
+	}
+	return fields, nil
+}
+
+func parseField(sf reflect.StructField, tag string, tagged bool) (*fieldCodec, error) {
+	fc := &fieldCodec{name: sf.Name, order: binary.LittleEndian}
+	var opts []string
+	if tagged {
+		opts = strings.Split(tag, ",")
+	}
+	wireSpec := ""
+	if len(opts) > 0 {
+		wireSpec, opts = strings.TrimSpace(opts[0]), opts[1:]
+	}
+	for _, opt := range opts {
+		switch opt = strings.TrimSpace(opt); {
+		case opt == "le":
+			fc.order = binary.LittleEndian
+		case opt == "be":
+			fc.order = binary.BigEndian
+		case strings.HasPrefix(opt, "scale="):
+			scale, err := strconv.ParseFloat(opt[6:], 64)
+			if err != nil || scale == 0 {
+				return nil, fmt.Errorf("invalid scale %q", opt[6:])
+			}
+			fc.scale = scale
+		default:
+			return nil, fmt.Errorf("unknown option %q", opt)
+		}
+	}
+	if wireSpec == "" {
+		wireSpec = defaultWireSpec(sf.Type)
+	}
+	kind := sf.Type.Kind()
+	switch wireSpec {
+	case "u8", "u16", "u32", "u64":
+		fc.wire = wireUint
+		fc.size, _ = strconv.Atoi(wireSpec[1:])
+		fc.size /= 8
+	case "i8", "i16", "i32", "i64":
+		fc.wire = wireInt
+		fc.size, _ = strconv.Atoi(wireSpec[1:])
+		fc.size /= 8
+	case "f32":
+		fc.wire, fc.size = wireFloat, 4
+	case "f64":
+		fc.wire, fc.size = wireFloat, 8
+	case "bytes":
+		fc.wire = wireBytes
+		switch {
+		case kind == reflect.Slice && sf.Type.Elem().Kind() == reflect.Uint8:
+		case kind == reflect.Array && sf.Type.Elem().Kind() == reflect.Uint8:
+			fc.size = sf.Type.Len()
+		default:
+			return nil, fmt.Errorf("bytes requires []byte or [N]byte, not %s", sf.Type)
+		}
+		return fc, nil
+	case "struct":
+		fields, err := buildCodec(sf.Type)
+		if err != nil {
+			return nil, err
+		}
+		fc.wire, fc.fields = wireStruct, fields
+		return fc, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q for %s", wireSpec, sf.Type)
+	}
+	if !isNumeric(kind) {
+		return nil, fmt.Errorf("%s can't be encoded as %s", sf.Type, wireSpec)
+	}
+	return fc, nil
+}
+
+func defaultWireSpec(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.Bool, reflect.Uint8:
+		return "u8"
+	case reflect.Uint16:
+		return "u16"
+	case reflect.Uint32:
+		return "u32"
+	case reflect.Uint64:
+		return "u64"
+	case reflect.Int8:
+		return "i8"
+	case reflect.Int16:
+		return "i16"
+	case reflect.Int32:
+		return "i32"
+	case reflect.Int64:
+		return "i64"
+	case reflect.Float32:
+		return "f32"
+	case reflect.Float64:
+		return "f64"
+	case reflect.Slice, reflect.Array:
+		return "bytes"
+	case reflect.Struct:
+		return "struct"
+	}
+	return typ.Kind().String()
+}
+
+func isNumeric(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func encodeFields(b []byte, val reflect.Value, fields []*fieldCodec) ([]byte, error) {
+	for _, fc := range fields {
+		var err error
+		if b, err = fc.encode(b, val.Field(fc.index)); err != nil {
+			return nil, fmt.Errorf("%s: %v", fc.name, err)
+		}
+	}
+	return b, nil
+}
+
+func decodeFields(data []byte, val reflect.Value, fields []*fieldCodec) ([]byte, error) {
+	for _, fc := range fields {
+		var err error
+		if data, err = fc.decode(data, val.Field(fc.index)); err != nil {
+			return nil, fmt.Errorf("%s: %v", fc.name, err)
+		}
+	}
+	return data, nil
+}
+
+func (fc *fieldCodec) encode(b []byte, v reflect.Value) ([]byte, error) {
+	switch fc.wire {
+	case wireStruct:
+		return encodeFields(b, v, fc.fields)
+	case wireBytes:
+		if v.Kind() == reflect.Array {
+			for n := 0; n < v.Len(); n++ {
+				b = append(b, byte(v.Index(n).Uint()))
+			}
+			return b, nil
+		}
+		return append(b, v.Bytes()...), nil
+	}
+
+	var bits uint64
+	if fc.wire == wireFloat {
+		f := numericFloat(v)
+		if fc.scale != 0 {
+			f /= fc.scale
+		}
+		if fc.size == 4 {
+			bits = uint64(math.Float32bits(float32(f)))
+		} else {
+			bits = math.Float64bits(f)
+		}
+	} else if fc.scale != 0 {
+		f := math.Round(numericFloat(v) / fc.scale)
+		if fc.wire == wireInt {
+			if f < fc.minInt() || f > fc.maxInt() {
+				return nil, ErrOverflow
+			}
+			bits = uint64(int64(f))
+		} else {
+			if f < 0 || f > fc.maxUint() {
+				return nil, ErrOverflow
+			}
+			bits = uint64(f)
+		}
+	} else {
+		switch v.Kind() {
+		case reflect.Bool:
+			if v.Bool() {
+				bits = 1
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i := v.Int()
+			if fc.wire == wireUint && (i < 0 || fc.size < 8 && uint64(i) >= 1<<uint(fc.size*8)) ||
+				fc.wire == wireInt && fc.size < 8 && (i < -1<<uint(fc.size*8-1) || i >= 1<<uint(fc.size*8-1)) {
+				return nil, ErrOverflow
+			}
+			bits = uint64(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u := v.Uint()
+			if fc.wire == wireUint && fc.size < 8 && u >= 1<<uint(fc.size*8) ||
+				fc.wire == wireInt && u >= 1<<uint(fc.size*8-1) {
+				return nil, ErrOverflow
+			}
+			bits = u
+		case reflect.Float32, reflect.Float64:
+			f := math.Round(v.Float())
+			if fc.wire == wireInt && (f < fc.minInt() || f > fc.maxInt()) ||
+				fc.wire == wireUint && (f < 0 || f > fc.maxUint()) {
+				return nil, ErrOverflow
+			}
+			if fc.wire == wireInt {
+				bits = uint64(int64(f))
+			} else {
+				bits = uint64(f)
+			}
+		}
+	}
+
+	var buf [8]byte
+	switch fc.size {
+	case 1:
+		buf[0] = byte(bits)
+	case 2:
+		fc.order.PutUint16(buf[:], uint16(bits))
+	case 4:
+		fc.order.PutUint32(buf[:], uint32(bits))
+	case 8:
+		fc.order.PutUint64(buf[:], bits)
+	}
+	return append(b, buf[:fc.size]...), nil
+}
+
+func (fc *fieldCodec) decode(data []byte, v reflect.Value) ([]byte, error) {
+	switch fc.wire {
+	case wireStruct:
+		return decodeFields(data, v, fc.fields)
+	case wireBytes:
+		if v.Kind() == reflect.Array {
+			if len(data) < fc.size {
+				return nil, ErrShortData
+			}
+			reflect.Copy(v, reflect.ValueOf(data[:fc.size]))
+			return data[fc.size:], nil
+		}
+		v.SetBytes(append([]byte(nil), data...))
+		return nil, nil
+	}
+
+	if len(data) < fc.size {
+		return nil, ErrShortData
+	}
+	var bits uint64
+	switch fc.size {
+	case 1:
+		bits = uint64(data[0])
+	case 2:
+		bits = uint64(fc.order.Uint16(data))
+	case 4:
+		bits = uint64(fc.order.Uint32(data))
+	case 8:
+		bits = fc.order.Uint64(data)
+	}
+	data = data[fc.size:]
+
+	var f float64
+	var i int64
+	switch fc.wire {
+	case wireFloat:
+		if fc.size == 4 {
+			f = float64(math.Float32frombits(uint32(bits)))
+		} else {
+			f = math.Float64frombits(bits)
+		}
+		i = int64(math.Round(f))
+	case wireInt:
+		// sign extension.
+		shift := uint(64 - fc.size*8)
+		i = int64(bits<<shift) >> shift
+		f = float64(i)
+	default:
+		i, f = int64(bits), float64(bits)
+	}
+	if fc.scale != 0 {
+		f *= fc.scale
+		i = int64(math.Round(f))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(i != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if fc.wire == wireUint && fc.scale == 0 && bits > math.MaxInt64 || v.OverflowInt(i) {
+			return nil, ErrOverflow
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := uint64(i)
+		if fc.wire == wireUint && fc.scale == 0 {
+			u = bits
+		} else if i < 0 {
+			return nil, ErrOverflow
+		}
+		if v.OverflowUint(u) {
+			return nil, ErrOverflow
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		if fc.wire == wireUint && fc.scale == 0 {
+			f = float64(bits)
+		}
+		v.SetFloat(f)
+	}
+	return data, nil
+}
+
+func (fc *fieldCodec) minInt() float64 {
+	return -math.Exp2(float64(fc.size*8 - 1))
+}
+
+func (fc *fieldCodec) maxInt() float64 {
+	return math.Exp2(float64(fc.size*8-1)) - 1
+}
+
+func (fc *fieldCodec) maxUint() float64 {
+	return math.Exp2(float64(fc.size*8)) - 1
+}
+
+func numericFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testPose struct {
+	X     float64 `l0:"i16,scale=0.001"`
+	Y     float64 `l0:"i16,be,scale=0.001"`
+	Theta float32 `l0:"i16,scale=0.0001"`
+}
+
+type testPayload struct {
+	Flags   uint8
+	Enabled bool
+	Count   uint16
+	Offset  int32 `l0:"i32,be"`
+	Speed   int   `l0:"i8"`
+	Ratio   float32
+	Pose    testPose
+	skipped int
+	Ignored int `l0:"-"`
+	ID      [2]byte
+	Extra   []byte
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	v := &testPayload{
+		Flags:   0x81,
+		Enabled: true,
+		Count:   0x1234,
+		Offset:  -2,
+		Speed:   -100,
+		Ratio:   1,
+		Pose:    testPose{X: 1.5, Y: -0.25, Theta: 0.5},
+		Ignored: 3,
+		ID:      [2]byte{0xa, 0xb},
+		Extra:   []byte{1, 2, 3},
+	}
+	data, err := Marshal(v)
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0x81,
+		0x01,
+		0x34, 0x12,
+		0xff, 0xff, 0xff, 0xfe,
+		0x9c,
+		0x00, 0x00, 0x80, 0x3f,
+		0xdc, 0x05,
+		0xff, 0x06,
+		0x88, 0x13,
+		0xa, 0xb,
+		1, 2, 3,
+	}, data)
+
+	var decoded testPayload
+	require.NoError(t, Unmarshal(data, &decoded))
+	v.Ignored = 0
+	require.Equal(t, v, &decoded)
+
+	require.Equal(t, ErrNotStruct, Unmarshal(data, decoded))
+	_, err = Marshal(1)
+	require.Equal(t, ErrNotStruct, err)
+}
+
+func TestUnmarshalShortData(t *testing.T) {
+	var v struct {
+		A uint8
+		B uint16
+	}
+	err := Unmarshal([]byte{1, 2}, &v)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrShortData.Error())
+}
+
+func TestOverflow(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    interface{}
+	}{
+		{"u8", &struct {
+			A int `l0:"u8"`
+		}{256}},
+		{"negative to unsigned", &struct {
+			A int `l0:"u16"`
+		}{-1}},
+		{"i8", &struct {
+			A int `l0:"i8"`
+		}{128}},
+		{"uint to i8", &struct {
+			A uint `l0:"i8"`
+		}{128}},
+		{"scaled", &struct {
+			A float64 `l0:"i16,scale=0.001"`
+		}{40}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Marshal(tc.v)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), ErrOverflow.Error())
+		})
+	}
+
+	var v struct {
+		A int8 `l0:"u8"`
+	}
+	require.Error(t, Unmarshal([]byte{0xff}, &v))
+}
+
+func TestInvalidTags(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    interface{}
+	}{
+		{"unknown type", &struct {
+			A int `l0:"u24"`
+		}{}},
+		{"unknown option", &struct {
+			A int `l0:"u8,xx"`
+		}{}},
+		{"invalid scale", &struct {
+			A int `l0:"u8,scale=0"`
+		}{}},
+		{"string", &struct{ A string }{}},
+		{"bytes on int", &struct {
+			A int `l0:"bytes"`
+		}{}},
+		{"field after bytes", &struct {
+			A []byte
+			B uint8
+		}{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Marshal(tc.v)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package codec
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+// ErrUnknownCode indicates the packet code is not registered.
+type ErrUnknownCode struct {
+	Code byte
+}
+
+// Error implements error.
+func (e *ErrUnknownCode) Error() string {
+	return fmt.Sprintf("unknown code: %x", e.Code)
+}
+
+// ErrUnknownType indicates the type is not registered.
+type ErrUnknownType struct {
+	Type reflect.Type
+}
+
+// Error implements error.
+func (e *ErrUnknownType) Error() string {
+	return fmt.Sprintf("unknown type: %s", e.Type)
+}
+
+// CommandType defines the request and reply types of a command.
+type CommandType struct {
+	Code    byte
+	Request reflect.Type
+	// Reply is nil if the reply carries no data.
+	Reply reflect.Type
+}
+
+// Registry maps packet codes to request, reply and event struct types.
+// Types are registered using typed nil pointers, e.g. (*SetSpeed)(nil),
+// and decoded values are always pointers to new structs.
+type Registry struct {
+	commands map[byte]*CommandType
+	requests map[reflect.Type]*CommandType
+	events   map[byte]reflect.Type
+	lock     sync.RWMutex
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		commands: make(map[byte]*CommandType),
+		requests: make(map[reflect.Type]*CommandType),
+		events:   make(map[byte]reflect.Type),
+	}
+}
+
+// RegisterCommand registers the request and reply types of a command code.
+// reply can be nil if the reply carries no data.
+// A request type can only be registered with one code.
+// It returns ErrNotStruct if request or reply is not a struct or pointer
+// to struct, including nil, or an error if a field can't be encoded.
+func (r *Registry) RegisterCommand(code byte, request, reply interface{}) error {
+	reqType, err := codecType(request)
+	if err != nil {
+		return err
+	}
+	ct := &CommandType{Code: code & 0x0f, Request: reqType}
+	if reply != nil {
+		if ct.Reply, err = codecType(reply); err != nil {
+			return err
+		}
+	}
+	r.lock.Lock()
+	r.commands[ct.Code] = ct
+	r.requests[ct.Request] = ct
+	r.lock.Unlock()
+	return nil
+}
+
+// RegisterEvent registers the event type of an event code.
+// It returns an error in the same way as RegisterCommand.
+func (r *Registry) RegisterEvent(code byte, event interface{}) error {
+	typ, err := codecType(event)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	r.events[code&0x0f|0x80] = typ
+	r.lock.Unlock()
+	return nil
+}
+
+// Command gets the registered command type of a code.
+func (r *Registry) Command(code byte) *CommandType {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.commands[code&0x0f]
+}
+
+// EncodeCommand encodes a request into a packet with the registered code.
+func (r *Registry) EncodeCommand(request interface{}) (*comm.Packet, error) {
+	typ, err := structType(request)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.RLock()
+	ct := r.requests[typ]
+	r.lock.RUnlock()
+	if ct == nil {
+		return nil, &ErrUnknownType{Type: typ}
+	}
+	data, err := Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return &comm.Packet{Code: ct.Code, Data: data}, nil
+}
+
+// DecodeCommand decodes a received command packet into a request,
+// which is useful on the device side.
+func (r *Registry) DecodeCommand(pkt *comm.Packet) (interface{}, error) {
+	ct := r.Command(pkt.Code)
+	if ct == nil {
+		return nil, &ErrUnknownCode{Code: pkt.Code}
+	}
+	return decodeNew(ct.Request, pkt.Data)
+}
+
+// DecodeReply decodes the result of a command into the registered reply.
+// It returns nil without error if the command has no reply type.
+func (r *Registry) DecodeReply(code byte, result comm.Result) (interface{}, error) {
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	ct := r.Command(code)
+	if ct == nil {
+		return nil, &ErrUnknownCode{Code: code}
+	}
+	if ct.Reply == nil {
+		return nil, nil
+	}
+	return decodeNew(ct.Reply, result.Data)
+}
+
+// DecodeEvent decodes an event packet into the registered event.
+func (r *Registry) DecodeEvent(pkt *comm.Packet) (interface{}, error) {
+	r.lock.RLock()
+	typ := r.events[pkt.Code&0x8f]
+	r.lock.RUnlock()
+	if typ == nil {
+		return nil, &ErrUnknownCode{Code: pkt.Code}
+	}
+	return decodeNew(typ, pkt.Data)
+}
+
+// Do encodes the request, sends it using the client and decodes the reply.
+func (r *Registry) Do(ctx context.Context, client *comm.Client, request interface{}, opts *comm.CommandOptions) (interface{}, error) {
+	pkt, err := r.EncodeCommand(request)
+	if err != nil {
+		return nil, err
+	}
+	code := pkt.Code
+	return r.DecodeReply(code, client.DoContext(ctx, pkt, opts))
+}
+
+// EventHandler returns a PacketHandler which decodes events and
+// calls fn with decoded events. Events failed to decode are passed to
+// fn with error.
+func (r *Registry) EventHandler(fn func(context.Context, interface{}, error)) comm.PacketHandler {
+	return comm.HandlePacketFunc(func(ctx context.Context, pkt *comm.Packet) {
+		event, err := r.DecodeEvent(pkt)
+		fn(ctx, event, err)
+	})
+}
+
+// structType gets the struct type of v, which can be a pointer.
+func structType(v interface{}) (reflect.Type, error) {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		return nil, ErrNotStruct
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	return typ, nil
+}
+
+// codecType gets the struct type of v, and validates it can be encoded.
+func codecType(v interface{}) (reflect.Type, error) {
+	typ, err := structType(v)
+	if err == nil {
+		_, err = codecOf(typ)
+	}
+	return typ, err
+}
+
+func decodeNew(typ reflect.Type, data []byte) (interface{}, error) {
+	val := reflect.New(typ)
+	if err := Unmarshal(data, val.Interface()); err != nil {
+		return nil, err
+	}
+	return val.Interface(), nil
+}
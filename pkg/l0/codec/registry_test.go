@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l0/comm/commtest"
+)
+
+type setSpeed struct {
+	Left  float32 `l0:"i16,scale=0.01"`
+	Right float32 `l0:"i16,scale=0.01"`
+}
+
+type speedReply struct {
+	Left  float32 `l0:"i16,scale=0.01"`
+	Right float32 `l0:"i16,scale=0.01"`
+}
+
+type stop struct{}
+
+type bumperEvent struct {
+	Mask uint8
+}
+
+func newTestRegistry(t *testing.T) *Registry {
+	reg := NewRegistry()
+	require.NoError(t, reg.RegisterCommand(2, (*setSpeed)(nil), (*speedReply)(nil)))
+	require.NoError(t, reg.RegisterCommand(4, (*stop)(nil), nil))
+	require.NoError(t, reg.RegisterEvent(1, (*bumperEvent)(nil)))
+	return reg
+}
+
+func TestRegistry(t *testing.T) {
+	reg := newTestRegistry(t)
+	pkt, err := reg.EncodeCommand(&setSpeed{Left: 1.5, Right: -1})
+	require.NoError(t, err)
+	require.Equal(t, &comm.Packet{Code: 2, Data: []byte{0x96, 0, 0x9c, 0xff}}, pkt)
+
+	req, err := reg.DecodeCommand(pkt)
+	require.NoError(t, err)
+	require.Equal(t, &setSpeed{Left: 1.5, Right: -1}, req)
+
+	reply, err := reg.DecodeReply(2, comm.Result{Code: 2, Data: []byte{0x96, 0, 0x9c, 0xff}})
+	require.NoError(t, err)
+	require.Equal(t, &speedReply{Left: 1.5, Right: -1}, reply)
+
+	reply, err = reg.DecodeReply(4, comm.Result{})
+	require.NoError(t, err)
+	require.Nil(t, reply)
+
+	_, err = reg.DecodeReply(4, comm.Result{Err: comm.ErrNoReply})
+	require.Equal(t, comm.ErrNoReply, err)
+
+	event, err := reg.DecodeEvent(&comm.Packet{Code: 0x81, Data: []byte{3}})
+	require.NoError(t, err)
+	require.Equal(t, &bumperEvent{Mask: 3}, event)
+
+	_, err = reg.EncodeCommand(&bumperEvent{})
+	require.IsType(t, &ErrUnknownType{}, err)
+	_, err = reg.DecodeEvent(&comm.Packet{Code: 0x82})
+	require.IsType(t, &ErrUnknownCode{}, err)
+	_, err = reg.DecodeReply(6, comm.Result{})
+	require.IsType(t, &ErrUnknownCode{}, err)
+}
+
+func TestRegistryInvalidTypes(t *testing.T) {
+	reg := NewRegistry()
+	require.Equal(t, ErrNotStruct, reg.RegisterCommand(2, nil, nil))
+	require.Equal(t, ErrNotStruct, reg.RegisterCommand(2, (*int)(nil), nil))
+	require.Equal(t, ErrNotStruct, reg.RegisterCommand(2, (*setSpeed)(nil), 1))
+	require.Equal(t, ErrNotStruct, reg.RegisterEvent(1, nil))
+	require.Error(t, reg.RegisterCommand(2, (*struct {
+		Speed float32 `l0:"u8,bad"`
+	})(nil), nil))
+	_, err := reg.EncodeCommand(nil)
+	require.Equal(t, ErrNotStruct, err)
+	_, err = reg.DecodeReply(2, comm.Result{})
+	require.IsType(t, &ErrUnknownCode{}, err)
+}
+
+func TestRegistryDo(t *testing.T) {
+	clientRW, deviceRW := commtest.Pipe(256)
+	client := comm.NewClient(comm.NewFIFO(clientRW))
+	device := comm.NewDevice(comm.NewFIFO(deviceRW))
+	reg := newTestRegistry(t)
+	device.HandleFunc(2, func(ctx context.Context, pkt *comm.Packet) comm.Result {
+		req, err := reg.DecodeCommand(pkt)
+		if err != nil {
+			return comm.Result{Err: err}
+		}
+		data, err := Marshal(&speedReply{Left: req.(*setSpeed).Left, Right: req.(*setSpeed).Right})
+		return comm.Result{Code: 2, Data: data, Err: err}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+	go client.Run(ctx)
+	go device.Run(ctx)
+	for start := time.Now(); !client.FIFO().State().IsReady() || !device.FIFO().State().IsReady(); time.Sleep(time.Millisecond) {
+		require.True(t, time.Since(start) < time.Second, "sync timeout")
+	}
+
+	reply, err := reg.Do(ctx, client, &setSpeed{Left: 0.5, Right: 0.25}, &comm.CommandOptions{Timeout: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, &speedReply{Left: 0.5, Right: 0.25}, reply)
+}
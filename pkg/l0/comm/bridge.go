@@ -0,0 +1,309 @@
+package comm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/websocket"
+)
+
+// BridgeMode defines how a Bridge shares the link with the remote.
+type BridgeMode int
+
+const (
+	// BridgeRaw passes bytes through between the link and the remote, and the
+	// remote runs the protocol with the peer directly. The local FIFO receives
+	// nothing and its writes are dropped while the remote is connected, so it
+	// resyncs after the remote disconnects.
+	BridgeRaw BridgeMode = iota
+	// BridgePacket runs the protocol with the remote, and forwards commands
+	// from the remote over the local FIFO, which keeps sync with the peer.
+	// Replies of forwarded commands are sent back to the remote, and events
+	// are delivered to both sides.
+	BridgePacket
+)
+
+// Bridge shares the link of a FIFO with a remote client over TCP or WebSocket.
+// Only one remote client is accepted at a time, and the remote owns sending
+// commands while connected, when FIFO.Send fails with ErrLinkBusy.
+// Bridge must be created before the FIFO runs, and both of them must run.
+type Bridge struct {
+	Mode BridgeMode
+
+	fifo     *FIFO
+	link     io.ReadWriter
+	port     *bridgePort
+	listener net.Listener
+	wsPath   string
+
+	rawConn  int32
+	remote   io.ReadWriteCloser
+	connFIFO *FIFO
+	seqMap   map[PacketSeq]PacketSeq
+	lock     sync.RWMutex
+}
+
+type bridgePort struct {
+	bridge  *Bridge
+	dataCh  chan []byte
+	pending []byte
+	err     error
+}
+
+// ListenBridge creates a Bridge for the FIFO listening on the URL,
+// like tcp://:9000 or ws://:9000/l0.
+// The FIFO is changed to read from the Bridge, which reads the link.
+func ListenBridge(fifo *FIFO, mode BridgeMode, rawURL string) (*Bridge, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bridge{Mode: mode, fifo: fifo, link: fifo.ReadWriter}
+	switch u.Scheme {
+	case "tcp":
+	case "ws":
+		if b.wsPath = u.Path; b.wsPath == "" {
+			b.wsPath = "/"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bridge scheme %q", u.Scheme)
+	}
+	if b.listener, err = net.Listen("tcp", u.Host); err != nil {
+		return nil, err
+	}
+	b.port = &bridgePort{bridge: b, dataCh: make(chan []byte, 16)}
+	fifo.ReadWriter, fifo.ReadTimeout = b.port, false
+	fifo.bridge = b
+	return b, nil
+}
+
+// Addr returns the listening address.
+func (b *Bridge) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// Connected indicates if a remote client is connected.
+func (b *Bridge) Connected() bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.remote != nil
+}
+
+// Run implements Runnable.
+func (b *Bridge) Run(ctx context.Context) error {
+	go b.readLink(ctx)
+	errCh := make(chan error, 1)
+	if b.wsPath != "" {
+		mux := http.NewServeMux()
+		mux.Handle(b.wsPath, websocket.Handler(func(conn *websocket.Conn) {
+			conn.PayloadType = websocket.BinaryFrame
+			b.serve(ctx, conn)
+		}))
+		server := &http.Server{Handler: mux}
+		go func() {
+			errCh <- server.Serve(b.listener)
+		}()
+		select {
+		case <-ctx.Done():
+			server.Close()
+			return ctx.Err()
+		case err := <-errCh:
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := b.listener.Accept()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			go b.serve(ctx, conn)
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		b.listener.Close()
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (b *Bridge) serve(ctx context.Context, conn io.ReadWriteCloser) {
+	defer conn.Close()
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var connFIFO *FIFO
+	if b.Mode == BridgePacket {
+		connFIFO = NewFIFO(conn)
+		connFIFO.Handler = HandlePacketFunc(b.forwardCommand)
+	}
+	b.lock.Lock()
+	if b.remote != nil {
+		b.lock.Unlock()
+		return
+	}
+	b.remote, b.connFIFO = conn, connFIFO
+	b.seqMap = make(map[PacketSeq]PacketSeq)
+	if connFIFO == nil {
+		atomic.StoreInt32(&b.rawConn, 1)
+	}
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		b.remote, b.connFIFO, b.seqMap = nil, nil, nil
+		atomic.StoreInt32(&b.rawConn, 0)
+		b.lock.Unlock()
+	}()
+
+	go func() {
+		<-sessionCtx.Done()
+		conn.Close()
+	}()
+	if connFIFO != nil {
+		connFIFO.Run(sessionCtx)
+	} else {
+		io.Copy(b.link, conn)
+	}
+}
+
+// readLink reads from the link and dispatches to the remote in BridgeRaw
+// mode, or to the local FIFO.
+func (b *Bridge) readLink(ctx context.Context) {
+	buf := make([]byte, 64)
+	for ctx.Err() == nil {
+		n, err := b.link.Read(buf)
+		if err != nil {
+			if os.IsTimeout(err) {
+				continue
+			}
+			b.port.err = err
+			close(b.port.dataCh)
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		b.lock.RLock()
+		remote := b.remote
+		b.lock.RUnlock()
+		if remote != nil && b.Mode == BridgeRaw {
+			if _, err := remote.Write(buf[:n]); err != nil {
+				remote.Close()
+			}
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf)
+		select {
+		case b.port.dataCh <- data:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// forwardCommand forwards a packet from the remote over the local FIFO.
+func (b *Bridge) forwardCommand(ctx context.Context, pkt *Packet) {
+	fwd := &Packet{Code: pkt.Code, Data: pkt.Data}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.seqMap == nil {
+		return
+	}
+	// lock order is the same as Send: bridge, then FIFO.
+	if err := b.fifo.send(fwd); err == nil && pkt.Code&0x80 == 0 {
+		b.seqMap[fwd.Seq] = pkt.Seq
+	}
+}
+
+// interceptPacket is called by the local FIFO for a received packet,
+// and returns true if the packet is consumed.
+func (b *Bridge) interceptPacket(ctx context.Context, pkt *Packet) bool {
+	b.lock.Lock()
+	connFIFO := b.connFIFO
+	if connFIFO == nil {
+		b.lock.Unlock()
+		return false
+	}
+	if pkt.Code&0x80 != 0 {
+		b.lock.Unlock()
+		connFIFO.Send(&Packet{Code: pkt.Code, Data: pkt.Data})
+		return false
+	}
+	if len(pkt.Data) == 0 {
+		b.lock.Unlock()
+		return false
+	}
+	seq, ok := b.seqMap[PacketSeq(pkt.Data[0])]
+	if ok {
+		delete(b.seqMap, PacketSeq(pkt.Data[0]))
+	}
+	b.lock.Unlock()
+	if !ok {
+		return false
+	}
+	data := append([]byte{byte(seq)}, pkt.Data[1:]...)
+	connFIFO.Send(&Packet{Code: pkt.Code, Data: data})
+	return true
+}
+
+// Read implements io.Reader.
+func (p *bridgePort) Read(buf []byte) (int, error) {
+	if len(p.pending) == 0 {
+		data, ok := <-p.dataCh
+		if !ok {
+			return 0, p.err
+		}
+		p.pending = data
+	}
+	n := copy(buf, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// Write implements io.Writer.
+func (p *bridgePort) Write(buf []byte) (int, error) {
+	// not locking the bridge as this can be called by forwardCommand.
+	if atomic.LoadInt32(&p.bridge.rawConn) != 0 {
+		return len(buf), nil
+	}
+	return p.bridge.link.Write(buf)
+}
+
+// Dial connects to a Bridge and creates a FIFO over the connection,
+// which is closed by closing the returned io.ReadWriteCloser.
+// Supported URLs are like tcp://host:9000 or ws://host:9000/l0.
+func Dial(rawURL string) (*FIFO, io.ReadWriteCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	var conn io.ReadWriteCloser
+	switch u.Scheme {
+	case "tcp":
+		conn, err = net.Dial("tcp", u.Host)
+	case "ws", "wss":
+		var ws *websocket.Conn
+		origin := "http://" + u.Host + "/"
+		if ws, err = websocket.Dial(rawURL, "", origin); err == nil {
+			ws.PayloadType = websocket.BinaryFrame
+			conn = ws
+		}
+	default:
+		err = fmt.Errorf("unsupported bridge scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewFIFO(conn), conn, nil
+}
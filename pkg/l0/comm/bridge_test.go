@@ -0,0 +1,138 @@
+package comm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bridgeTestEnv struct {
+	t      *testing.T
+	ctx    context.Context
+	local  *Client
+	device *Device
+	bridge *Bridge
+}
+
+func newBridgeTestEnv(ctx context.Context, t *testing.T, mode BridgeMode, rawURL string) *bridgeTestEnv {
+	localFIFO, deviceFIFO := newLinkedFIFOs()
+	env := &bridgeTestEnv{t: t, ctx: ctx, local: NewClient(localFIFO), device: NewDevice(deviceFIFO)}
+	env.device.HandleFunc(2, func(ctx context.Context, pkt *Packet) Result {
+		return Result{Code: 4, Data: pkt.Data}
+	})
+	var err error
+	env.bridge, err = ListenBridge(localFIFO, mode, rawURL)
+	require.NoError(t, err)
+	drainStates(env.local)
+	go env.bridge.Run(ctx)
+	go env.local.Run(ctx)
+	go env.device.Run(ctx)
+	waitReady(t, localFIFO, deviceFIFO)
+	return env
+}
+
+func drainStates(client *Client) {
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+}
+
+func (e *bridgeTestEnv) dial(scheme, path string) (*Client, func()) {
+	fifo, conn, err := Dial(scheme + "://" + e.bridge.Addr().String() + path)
+	require.NoError(e.t, err)
+	remote := NewClient(fifo)
+	drainStates(remote)
+	ctx, cancel := context.WithCancel(e.ctx)
+	go remote.Run(ctx)
+	waitReady(e.t, fifo)
+	deadline := time.Now().Add(time.Second)
+	for !e.bridge.Connected() {
+		require.True(e.t, time.Now().Before(deadline), "connect timeout")
+		time.Sleep(time.Millisecond)
+	}
+	return remote, func() {
+		cancel()
+		conn.Close()
+		for e.bridge.Connected() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func (e *bridgeTestEnv) command(client *Client, data byte) {
+	r := client.DoContext(e.ctx, &Packet{Code: 2, Data: []byte{data}}, &CommandOptions{Timeout: time.Second})
+	require.NoError(e.t, r.Err)
+	require.Equal(e.t, byte(4), r.Code)
+	require.Equal(e.t, []byte{data}, r.Data)
+}
+
+func (e *bridgeTestEnv) event(client *Client, data byte) {
+	select {
+	case pkt := <-client.EventChan():
+		require.Equal(e.t, byte(0x83), pkt.Code)
+		require.Equal(e.t, []byte{data}, pkt.Data)
+	case <-time.After(time.Second):
+		e.t.Fatal("event timeout")
+	}
+}
+
+func TestBridgePacket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	env := newBridgeTestEnv(ctx, t, BridgePacket, "tcp://127.0.0.1:0")
+	env.command(env.local, 1)
+
+	remote, disconnect := env.dial("tcp", "")
+	env.command(remote, 2)
+	env.command(remote, 3)
+	r := env.local.DoContext(ctx, &Packet{Code: 2}, nil)
+	require.Equal(t, ErrLinkBusy, r.Err)
+	require.NoError(t, env.device.Emit(3, []byte{5}))
+	env.event(remote, 5)
+	env.event(env.local, 5)
+
+	// a second remote is rejected.
+	_, conn, err := Dial("tcp://" + env.bridge.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	conn.Close()
+	env.command(remote, 4)
+
+	disconnect()
+	env.command(env.local, 6)
+}
+
+func TestBridgeRawWebSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	env := newBridgeTestEnv(ctx, t, BridgeRaw, "ws://127.0.0.1:0/l0")
+	env.command(env.local, 1)
+
+	remote, disconnect := env.dial("ws", "/l0")
+	env.command(remote, 2)
+	r := env.local.DoContext(ctx, &Packet{Code: 2}, nil)
+	require.Equal(t, ErrLinkBusy, r.Err)
+	require.NoError(t, env.device.Emit(3, []byte{5}))
+	env.event(remote, 5)
+
+	disconnect()
+	// the local FIFO resyncs after the first packet to the device.
+	opts := &CommandOptions{
+		Timeout: 200 * time.Millisecond,
+		Retry:   RetryPolicy{MaxRetries: 5, Delay: 50 * time.Millisecond},
+	}
+	r = env.local.DoContext(ctx, &Packet{Code: 2, Data: []byte{7}}, opts)
+	require.NoError(t, r.Err)
+	require.Equal(t, []byte{7}, r.Data)
+}
+
+func TestDialUnsupported(t *testing.T) {
+	_, _, err := Dial("udp://127.0.0.1:9000")
+	require.Error(t, err)
+	_, err = ListenBridge(NewFIFO(nil), BridgeRaw, "udp://127.0.0.1:0")
+	require.Error(t, err)
+}
@@ -3,6 +3,7 @@ package comm
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 // Result is the result of a command using Do.
@@ -12,20 +13,100 @@ type Result struct {
 	Data []byte
 }
 
+// CommandOptions defines options for DoContext.
+type CommandOptions struct {
+	// Timeout is the deadline of each attempt waiting for the reply.
+	// Zero means wait until context is done.
+	Timeout time.Duration
+	// Retry is the retry policy. The zero value means no retry.
+	Retry RetryPolicy
+}
+
+// RetryPolicy defines how a failed command is retried.
+// Only use retry with idempotent commands, as the command may have
+// been executed by the peer even if the reply is lost.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries after the first attempt.
+	MaxRetries int
+	// Delay is the delay before the first retry.
+	Delay time.Duration
+	// MaxDelay caps the delay which doubles after each retry.
+	// Zero means the delay is constant.
+	MaxDelay time.Duration
+	// Retriable determines if an error should be retried.
+	// If nil, IsRetriable is used.
+	Retriable func(error) bool
+}
+
+// IsRetriable is the default retriable error checker.
+// Errors from transport (no reply, timeout, sync lost, not ready)
+// are retriable, while CommandError from the peer is not.
+func IsRetriable(err error) bool {
+	switch err {
+	case ErrNoReply, ErrTimeout, ErrSyncLost, ErrNotReady:
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) retriable(err error) bool {
+	if p.Retriable != nil {
+		return p.Retriable(err)
+	}
+	return IsRetriable(err)
+}
+
+func (p *RetryPolicy) nextDelay(delay time.Duration) time.Duration {
+	if p.MaxDelay <= p.Delay {
+		return delay
+	}
+	if delay *= 2; delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// WindowPolicy defines what to do when the in-flight window is full.
+type WindowPolicy int
+
+const (
+	// WindowBlock blocks issuing the command until a pending command
+	// completes, or the context is done.
+	WindowBlock WindowPolicy = iota
+	// WindowFailFast fails the command with ErrWindowFull.
+	WindowFailFast
+)
+
 // Client provides client side operations over FIFO.
 type Client struct {
+	// MaxInFlight limits the number of pending commands to avoid flooding
+	// the peer. Zero means unlimited. It must be set before issuing commands.
+	MaxInFlight int
+	// WindowPolicy decides what to do when MaxInFlight commands are pending.
+	WindowPolicy WindowPolicy
+
 	fifo     *FIFO
+	channel  *muxChannel
+	events   *EventRouter
 	eventCh  chan *Packet
 	stateCh  chan SyncState
 	cmdsHead *Command
 	cmdsTail *Command
 	cmdsLock sync.Mutex
+
+	window     chan struct{}
+	windowOnce sync.Once
+
+	stats     ClientStats
+	statsLock sync.Mutex
 }
 
 // Command represents a pending command waiting for reply.
 type Command struct {
 	requestSeq PacketSeq
+	sentAt     time.Time
 	resultCh   chan Result
+	slot       chan struct{}
 	next       *Command
 }
 
@@ -41,19 +122,42 @@ func (c *Command) ResultChan() <-chan Result {
 
 // NewClient creates client and wraps the fifo.
 func NewClient(fifo *FIFO) *Client {
-	c := &Client{
+	c := newClient(fifo)
+	c.fifo.Handler = c
+	c.fifo.Notifier = StateChangedFunc(c.stateChanged)
+	return c
+}
+
+func newClient(fifo *FIFO) *Client {
+	return &Client{
 		fifo:    fifo,
+		events:  newEventRouter(),
 		eventCh: make(chan *Packet, 1),
 		stateCh: make(chan SyncState, 1),
+		stats: ClientStats{
+			Latency:    *NewLatencyHistogram(),
+			QueueDelay: *NewLatencyHistogram(),
+		},
 	}
-	c.fifo.Handler = c
-	c.fifo.Notifier = StateChangedFunc(func(ctx context.Context, state SyncState) {
-		c.stateCh <- state
-	})
-	return c
 }
 
-// FIFO gets wrapped FIFO.
+func (c *Client) stateChanged(ctx context.Context, state SyncState) {
+	if !state.IsReady() {
+		c.failPending(ErrSyncLost)
+	}
+	c.stateCh <- state
+}
+
+// send writes the packet, or queues it for a channel of Mux and
+// returns the chan of the result.
+func (c *Client) send(pkt *Packet) (<-chan error, error) {
+	if c.channel != nil {
+		return c.channel.send(pkt)
+	}
+	return nil, c.fifo.Send(pkt)
+}
+
+// FIFO gets wrapped FIFO, which is shared by all channels of a Mux.
 func (c *Client) FIFO() *FIFO {
 	return c.fifo
 }
@@ -64,28 +168,86 @@ func (c *Client) StateChan() <-chan SyncState {
 }
 
 // EventChan retrieves the event reporting chan.
+// Events without a route in Events are delivered to this chan if
+// there's room, otherwise they are dropped and counted as unhandled.
 func (c *Client) EventChan() <-chan *Packet {
 	return c.eventCh
 }
 
+// Events retrieves the event router.
+func (c *Client) Events() *EventRouter {
+	return c.events
+}
+
+// Stats gets a snapshot of the statistics, including the wrapped FIFO.
+func (c *Client) Stats() ClientStats {
+	c.statsLock.Lock()
+	stats := c.stats
+	stats.Latency = c.stats.Latency.Clone()
+	stats.QueueDelay = c.stats.QueueDelay.Clone()
+	c.statsLock.Unlock()
+	if window := c.inFlightWindow(); window != nil {
+		stats.InFlight = len(window)
+	}
+	stats.FIFO = c.fifo.Stats()
+	stats.UnhandledEvents = c.events.Unhandled()
+	return stats
+}
+
+func (c *Client) updateStats(fn func(*ClientStats)) {
+	c.statsLock.Lock()
+	fn(&c.stats)
+	c.statsLock.Unlock()
+}
+
 // DoWith sends a command and expects a result in the provided chan.
+// It blocks if the in-flight window is full with WindowBlock.
 func (c *Client) DoWith(pkt *Packet, ch chan Result) *Command {
+	return c.DoWithContext(context.Background(), pkt, ch)
+}
+
+// DoWithContext is DoWith and stops waiting for the in-flight
+// window when ctx is done, the result is ctx.Err() in that case.
+func (c *Client) DoWithContext(ctx context.Context, pkt *Packet, ch chan Result) *Command {
 	cmd := &Command{resultCh: ch}
+	if err := c.acquireSlot(ctx, cmd); err != nil {
+		cmd.resultCh <- Result{Err: err}
+		return cmd
+	}
 
 	c.cmdsLock.Lock()
-	defer c.cmdsLock.Unlock()
-	err := c.fifo.Send(pkt)
-	cmd.requestSeq = pkt.Seq
+	sentCh, err := c.send(pkt)
+	if err == nil {
+		cmd.requestSeq, cmd.sentAt = pkt.Seq, time.Now()
+		if c.cmdsHead == nil {
+			c.cmdsHead = cmd
+		} else {
+			c.cmdsTail.next = cmd
+		}
+		c.cmdsTail = cmd
+	}
+	c.cmdsLock.Unlock()
+
+	delivered := false
 	if err != nil {
-		cmd.resultCh <- Result{Err: err}
-		return cmd
+		c.releaseSlot(cmd)
+	} else if sentCh != nil {
+		// the command is pending while the packet is queued by Mux,
+		// and cmdsLock is not held for replies of other commands.
+		if err = <-sentCh; err != nil {
+			// the result is delivered if not pending, e.g. by failPending.
+			delivered = !c.removeCommand(cmd)
+		}
 	}
-	if c.cmdsHead == nil {
-		c.cmdsHead = cmd
-	} else {
-		c.cmdsTail.next = cmd
+	c.updateStats(func(s *ClientStats) {
+		s.Commands++
+		if err != nil {
+			s.SendErrors++
+		}
+	})
+	if err != nil && !delivered {
+		cmd.resultCh <- Result{Err: err}
 	}
-	c.cmdsTail = cmd
 	return cmd
 }
 
@@ -94,10 +256,156 @@ func (c *Client) Do(pkt *Packet) *Command {
 	return c.DoWith(pkt, make(chan Result, 1))
 }
 
+func (c *Client) inFlightWindow() chan struct{} {
+	if c.MaxInFlight <= 0 {
+		return nil
+	}
+	c.windowOnce.Do(func() {
+		c.window = make(chan struct{}, c.MaxInFlight)
+	})
+	return c.window
+}
+
+// acquireSlot takes a slot in the in-flight window for the command.
+func (c *Client) acquireSlot(ctx context.Context, cmd *Command) error {
+	window := c.inFlightWindow()
+	if window == nil {
+		return nil
+	}
+	start := time.Now()
+	select {
+	case window <- struct{}{}:
+	default:
+		c.updateStats(func(s *ClientStats) { s.WindowFull++ })
+		if c.WindowPolicy == WindowFailFast {
+			return ErrWindowFull
+		}
+		select {
+		case window <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	cmd.slot = window
+	delay := time.Since(start)
+	c.updateStats(func(s *ClientStats) { s.QueueDelay.Observe(delay) })
+	return nil
+}
+
+// releaseSlot is called when the command is no longer pending.
+func (c *Client) releaseSlot(cmd *Command) {
+	if cmd.slot != nil {
+		<-cmd.slot
+		cmd.slot = nil
+	}
+}
+
+// DoContext sends a command and waits for the result.
+// Each attempt waits for the reply until opts.Timeout expires or ctx is done,
+// and failed attempts are retried according to opts.Retry.
+// opts can be nil to wait without timeout or retry.
+func (c *Client) DoContext(ctx context.Context, pkt *Packet, opts *CommandOptions) Result {
+	if opts == nil {
+		opts = &CommandOptions{}
+	}
+	delay := opts.Retry.Delay
+	for attempt := 0; ; attempt++ {
+		r := c.doOnce(ctx, pkt, opts.Timeout)
+		if r.Err == nil || attempt >= opts.Retry.MaxRetries ||
+			ctx.Err() != nil || !opts.Retry.retriable(r.Err) {
+			return r
+		}
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return Result{Err: ctx.Err()}
+			case <-time.After(delay):
+			}
+			delay = opts.Retry.nextDelay(delay)
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, pkt *Packet, timeout time.Duration) Result {
+	cmd := c.DoWithContext(ctx, pkt, make(chan Result, 1))
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	var err error
+	select {
+	case r := <-cmd.resultCh:
+		return r
+	case <-timer:
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if !c.removeCommand(cmd) {
+		// the command has been removed for delivering the result.
+		return <-cmd.resultCh
+	}
+	if err == ErrTimeout {
+		c.updateStats(func(s *ClientStats) { s.Timeouts++ })
+	}
+	return Result{Err: err}
+}
+
+// removeCommand removes a pending command, and returns false if
+// the command is not pending.
+func (c *Client) removeCommand(cmd *Command) bool {
+	c.cmdsLock.Lock()
+	defer c.cmdsLock.Unlock()
+	var prev *Command
+	for curr := c.cmdsHead; curr != nil; prev, curr = curr, curr.next {
+		if curr != cmd {
+			continue
+		}
+		if prev == nil {
+			c.cmdsHead = curr.next
+		} else {
+			prev.next = curr.next
+		}
+		if c.cmdsTail == curr {
+			c.cmdsTail = prev
+		}
+		curr.next = nil
+		c.releaseSlot(curr)
+		return true
+	}
+	return false
+}
+
+// failPending fails all pending commands with the error.
+func (c *Client) failPending(err error) {
+	c.cmdsLock.Lock()
+	head := c.cmdsHead
+	c.cmdsHead, c.cmdsTail = nil, nil
+	c.cmdsLock.Unlock()
+	for head != nil {
+		cmd := head
+		head, cmd.next = cmd.next, nil
+		if err == ErrSyncLost {
+			c.updateStats(func(s *ClientStats) { s.SyncLost++ })
+		}
+		c.releaseSlot(cmd)
+		cmd.resultCh <- Result{Err: err}
+	}
+}
+
 // HandlePacket implements PacketHandler.
 func (c *Client) HandlePacket(ctx context.Context, pkt *Packet) {
 	if pkt.Code&0x80 != 0 {
-		c.eventCh <- pkt
+		c.updateStats(func(s *ClientStats) { s.Events++ })
+		if !c.events.dispatch(ctx, pkt) {
+			select {
+			case c.eventCh <- pkt:
+			default:
+				c.events.countUnhandled()
+			}
+		}
 		return
 	}
 	if len(pkt.Data) == 0 {
@@ -125,9 +433,24 @@ func (c *Client) HandlePacket(ctx context.Context, pkt *Packet) {
 	if curr == nil {
 		return
 	}
-	for ; head != curr; head = head.next {
-		head.resultCh <- Result{Err: ErrNoReply}
+	latency := time.Since(curr.sentAt)
+	c.updateStats(func(s *ClientStats) {
+		for cmd := head; cmd != curr; cmd = cmd.next {
+			s.NoReplies++
+		}
+		s.Replies++
+		if pkt.Code&1 != 0 {
+			s.CommandErrors++
+		}
+		s.Latency.Observe(latency)
+	})
+	for head != curr {
+		cmd := head
+		head, cmd.next = cmd.next, nil
+		c.releaseSlot(cmd)
+		cmd.resultCh <- Result{Err: ErrNoReply}
 	}
+	c.releaseSlot(curr)
 	if pkt.Code&1 != 0 {
 		curr.resultCh <- Result{Err: &CommandError{Code: pkt.Code & 0x7e}}
 	} else {
@@ -136,6 +459,14 @@ func (c *Client) HandlePacket(ctx context.Context, pkt *Packet) {
 }
 
 // Run wraps FIFO.Run to implement Runnable.
+// For a channel of Mux, the FIFO is run by Mux, and Run only waits
+// until ctx is done. The event handlers stop when Run returns.
 func (c *Client) Run(ctx context.Context) error {
+	c.events.start()
+	defer c.events.stop()
+	if c.channel != nil {
+		<-ctx.Done()
+		return ctx.Err()
+	}
 	return c.fifo.Run(ctx)
 }
@@ -8,31 +8,16 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
-)
-
-type chanReadWriter struct {
-	readCh  <-chan byte
-	writeCh chan byte
-}
 
-func (c *chanReadWriter) Read(p []byte) (int, error) {
-	p[0] = <-c.readCh
-	return 1, nil
-}
-
-func (c *chanReadWriter) Write(p []byte) (int, error) {
-	for _, b := range p {
-		c.writeCh <- b
-	}
-	return len(p), nil
-}
+	"github.com/robotalks/robo.go/pkg/l0/comm/commtest"
+)
 
 type clientTestEnv struct {
-	t        *testing.T
-	readCh   chan byte
-	writeCh  chan byte
-	client   *Client
-	commands []*Command
+	t       *testing.T
+	readCh  chan byte
+	writeCh chan byte
+	client  *Client
+	results []<-chan Result
 }
 
 func newClientTestEnv(t *testing.T) *clientTestEnv {
@@ -41,7 +26,7 @@ func newClientTestEnv(t *testing.T) *clientTestEnv {
 		readCh:  make(chan byte, 1),
 		writeCh: make(chan byte, 1),
 	}
-	clientFIFO := NewFIFO(&chanReadWriter{readCh: env.readCh, writeCh: env.writeCh})
+	clientFIFO := NewFIFO(&commtest.ChanReadWriter{ReadCh: env.readCh, WriteCh: env.writeCh})
 	clientFIFO.seq = PacketSeq(1)
 	clientFIFO.ReadTimeout = true
 	env.client = NewClient(clientFIFO)
@@ -111,16 +96,26 @@ func (e *clientTestEnv) stateChange(states ...SyncState) func(string) {
 
 func (e *clientTestEnv) clientDo(code byte, data ...byte) func(string) {
 	return func(name string) {
-		e.commands = append(e.commands, e.client.Do(&Packet{Code: code, Data: data}))
+		e.results = append(e.results, e.client.Do(&Packet{Code: code, Data: data}).ResultChan())
+	}
+}
+
+func (e *clientTestEnv) clientDoContext(opts *CommandOptions, code byte, data ...byte) func(string) {
+	return func(name string) {
+		ch := make(chan Result, 1)
+		e.results = append(e.results, ch)
+		go func() {
+			ch <- e.client.DoContext(context.TODO(), &Packet{Code: code, Data: data}, opts)
+		}()
 	}
 }
 
 func (e *clientTestEnv) nextResult(name string) (r Result) {
-	require.NotEmptyf(e.t, e.commands, "%s commands empty", name)
-	cmd := e.commands[0]
-	e.commands = e.commands[1:]
+	require.NotEmptyf(e.t, e.results, "%s results empty", name)
+	ch := e.results[0]
+	e.results = e.results[1:]
 	select {
-	case r = <-cmd.ResultChan():
+	case r = <-ch:
 	case <-time.After(500 * time.Millisecond):
 		e.t.Fatalf("%s: timeout", name)
 	}
@@ -172,9 +167,9 @@ func TestClient(t *testing.T) {
 			"simple command",
 			func(env *clientTestEnv) {
 				env.run(
-					env.expect(syncREQ, 1),
+					env.expect(SyncREQ, 1),
 					env.parallel(
-						env.inject(syncACK, 1),
+						env.inject(SyncACK, 1),
 						env.stateChange(SyncStateReceiving, SyncStateReady),
 					),
 					env.parallel(
@@ -193,9 +188,9 @@ func TestClient(t *testing.T) {
 			"no reply",
 			func(env *clientTestEnv) {
 				env.run(
-					env.expect(syncREQ, 1),
+					env.expect(SyncREQ, 1),
 					env.parallel(
-						env.inject(syncACK, 1),
+						env.inject(SyncACK, 1),
 						env.stateChange(SyncStateReceiving, SyncStateReady),
 					),
 					env.parallel(
@@ -218,9 +213,9 @@ func TestClient(t *testing.T) {
 			"event",
 			func(env *clientTestEnv) {
 				env.run(
-					env.expect(syncREQ, 1),
+					env.expect(SyncREQ, 1),
 					env.parallel(
-						env.inject(syncACK, 1),
+						env.inject(SyncACK, 1),
 						env.stateChange(SyncStateReceiving, SyncStateReady),
 					),
 					env.parallel(
@@ -235,9 +230,9 @@ func TestClient(t *testing.T) {
 			"event and command",
 			func(env *clientTestEnv) {
 				env.run(
-					env.expect(syncREQ, 1),
+					env.expect(SyncREQ, 1),
 					env.parallel(
-						env.inject(syncACK, 1),
+						env.inject(SyncACK, 1),
 						env.stateChange(SyncStateReceiving, SyncStateReady),
 					),
 					env.parallel(
@@ -257,6 +252,130 @@ func TestClient(t *testing.T) {
 				)
 			},
 		},
+		{
+			"command timeout",
+			func(env *clientTestEnv) {
+				env.run(
+					env.expect(SyncREQ, 1),
+					env.parallel(
+						env.inject(SyncACK, 1),
+						env.stateChange(SyncStateReceiving, SyncStateReady),
+					),
+					env.parallel(
+						env.clientDoContext(&CommandOptions{Timeout: 50 * time.Millisecond}, 1),
+						env.expect(1, 1),
+					),
+					env.clientResultErr(ErrTimeout),
+					env.parallel(
+						env.inject(1, 0x10, 1),
+						env.stateChange(SyncStateReady|SyncStateReceiving, SyncStateReady),
+					),
+					env.parallel(
+						env.clientDo(2),
+						env.expect(2, 2),
+					),
+					env.parallel(
+						env.inject(2, 0x12, 2),
+						env.stateChange(SyncStateReady|SyncStateReceiving, SyncStateReady),
+						env.clientResult(2),
+					),
+				)
+			},
+		},
+		{
+			"command retry",
+			func(env *clientTestEnv) {
+				opts := &CommandOptions{
+					Timeout: 50 * time.Millisecond,
+					Retry:   RetryPolicy{MaxRetries: 1, Delay: 10 * time.Millisecond},
+				}
+				env.run(
+					env.expect(SyncREQ, 1),
+					env.parallel(
+						env.inject(SyncACK, 1),
+						env.stateChange(SyncStateReceiving, SyncStateReady),
+					),
+					env.parallel(
+						env.clientDoContext(opts, 1),
+						env.expect(1, 1, 2, 1),
+					),
+					env.parallel(
+						env.inject(1, 0x14, 2),
+						env.stateChange(SyncStateReady|SyncStateReceiving, SyncStateReady),
+						env.clientResult(4),
+					),
+				)
+			},
+		},
+		{
+			"command retry exhausted",
+			func(env *clientTestEnv) {
+				opts := &CommandOptions{
+					Timeout: 20 * time.Millisecond,
+					Retry:   RetryPolicy{MaxRetries: 2},
+				}
+				env.run(
+					env.expect(SyncREQ, 1),
+					env.parallel(
+						env.inject(SyncACK, 1),
+						env.stateChange(SyncStateReceiving, SyncStateReady),
+					),
+					env.parallel(
+						env.clientDoContext(opts, 1),
+						env.expect(1, 1, 2, 1, 3, 1),
+					),
+					env.clientResultErr(ErrTimeout),
+				)
+			},
+		},
+		{
+			"command error not retried",
+			func(env *clientTestEnv) {
+				opts := &CommandOptions{Retry: RetryPolicy{MaxRetries: 1}}
+				env.run(
+					env.expect(SyncREQ, 1),
+					env.parallel(
+						env.inject(SyncACK, 1),
+						env.stateChange(SyncStateReceiving, SyncStateReady),
+					),
+					env.parallel(
+						env.clientDoContext(opts, 2),
+						env.expect(1, 2),
+					),
+					env.parallel(
+						env.inject(1, 0x13, 1),
+						env.stateChange(SyncStateReady|SyncStateReceiving, SyncStateReady),
+						env.clientResultErr(&CommandError{Code: 2}),
+					),
+				)
+			},
+		},
+		{
+			"sync lost",
+			func(env *clientTestEnv) {
+				env.run(
+					env.expect(SyncREQ, 1),
+					env.parallel(
+						env.inject(SyncACK, 1),
+						env.stateChange(SyncStateReceiving, SyncStateReady),
+					),
+					env.parallel(
+						env.sequential(
+							env.clientDo(1),
+							env.clientDoContext(nil, 2),
+						),
+						env.expect(1, 1, 2, 2),
+					),
+					env.parallel(
+						env.inject(SyncREQ, 5),
+						env.stateChange(SyncStateSyncing|SyncStateReceiving, SyncStateReady),
+						env.expect(SyncACK, 3),
+					),
+					env.clientResultErr(ErrSyncLost),
+					env.clientResultErr(ErrSyncLost),
+				)
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -266,3 +385,67 @@ func TestClient(t *testing.T) {
 		})
 	}
 }
+
+func TestClientWindow(t *testing.T) {
+	clientFIFO, peerFIFO := newLinkedFIFOs()
+	client := NewClient(clientFIFO)
+	client.MaxInFlight = 1
+	requests := make(chan *Packet, 4)
+	peerFIFO.Handler = HandlePacketFunc(func(ctx context.Context, pkt *Packet) {
+		requests <- pkt
+	})
+	reply := func() {
+		select {
+		case pkt := <-requests:
+			require.NoError(t, peerFIFO.Send(&Packet{Code: pkt.Code, Data: []byte{byte(pkt.Seq)}}))
+		case <-time.After(time.Second):
+			t.Fatal("request timeout")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+	go client.Run(ctx)
+	go peerFIFO.Run(ctx)
+	waitReady(t, clientFIFO, peerFIFO)
+
+	cmd1 := client.Do(&Packet{Code: 2})
+	client.WindowPolicy = WindowFailFast
+	r := <-client.Do(&Packet{Code: 4}).ResultChan()
+	require.Equal(t, ErrWindowFull, r.Err)
+
+	client.WindowPolicy = WindowBlock
+	waitCtx, waitCancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	r = <-client.DoWithContext(waitCtx, &Packet{Code: 4}, make(chan Result, 1)).ResultChan()
+	waitCancel()
+	require.Equal(t, context.DeadlineExceeded, r.Err)
+
+	cmd2Ch := make(chan *Command, 1)
+	go func() {
+		cmd2Ch <- client.Do(&Packet{Code: 6})
+	}()
+	deadline := time.Now().Add(time.Second)
+	for client.Stats().WindowFull < 3 {
+		require.True(t, time.Now().Before(deadline), "blocking timeout")
+		time.Sleep(time.Millisecond)
+	}
+	reply()
+	r = <-cmd1.ResultChan()
+	require.NoError(t, r.Err)
+	require.Equal(t, byte(2), r.Code)
+
+	reply()
+	r = <-(<-cmd2Ch).ResultChan()
+	require.NoError(t, r.Err)
+	require.Equal(t, byte(6), r.Code)
+
+	stats := client.Stats()
+	require.Equal(t, 0, stats.InFlight)
+	require.Equal(t, uint64(3), stats.WindowFull)
+	require.Equal(t, uint64(2), stats.QueueDelay.Count)
+	require.True(t, stats.QueueDelay.Max > 0)
+}
@@ -0,0 +1,35 @@
+// Package commtest provides helpers for testing over L0 links.
+package commtest
+
+import "io"
+
+// ChanReadWriter is an io.ReadWriter which reads bytes from ReadCh and
+// writes bytes to WriteCh. Read returns io.EOF when ReadCh is closed.
+type ChanReadWriter struct {
+	ReadCh  <-chan byte
+	WriteCh chan<- byte
+}
+
+// Pipe creates a pair of ChanReadWriters linked by chans buffering size bytes.
+func Pipe(size int) (*ChanReadWriter, *ChanReadWriter) {
+	ch1, ch2 := make(chan byte, size), make(chan byte, size)
+	return &ChanReadWriter{ReadCh: ch1, WriteCh: ch2}, &ChanReadWriter{ReadCh: ch2, WriteCh: ch1}
+}
+
+// Read implements io.Reader, and reads one byte at a time.
+func (c *ChanReadWriter) Read(p []byte) (int, error) {
+	b, ok := <-c.ReadCh
+	if !ok {
+		return 0, io.EOF
+	}
+	p[0] = b
+	return 1, nil
+}
+
+// Write implements io.Writer.
+func (c *ChanReadWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		c.WriteCh <- b
+	}
+	return len(p), nil
+}
@@ -0,0 +1,111 @@
+// Package conformance drives an L0 peer (the firmware side) through
+// scripted scenarios to verify its implementation of the protocol.
+//
+// The peer must implement an echo command (Config.EchoCode), which replies
+// with the same code and the data of the command.
+package conformance
+
+import (
+	"io"
+	"time"
+)
+
+// Config defines the expectations of the peer.
+type Config struct {
+	// EchoCode is the code of the echo command.
+	EchoCode byte
+	// CRC indicates the peer uses CRC-8 on packets.
+	CRC bool
+	// ReplyTimeout is the max time waiting for a response from the peer.
+	ReplyTimeout time.Duration
+	// PeerTimeout is the sync timeout of the peer, e.g. comm.FIFO.Timeout.
+	PeerTimeout time.Duration
+}
+
+// DefaultConfig creates the default Config.
+func DefaultConfig() *Config {
+	return &Config{
+		EchoCode:     2,
+		ReplyTimeout: time.Second,
+		PeerTimeout:  100 * time.Millisecond,
+	}
+}
+
+// Scenario is a scripted scenario.
+type Scenario struct {
+	Name        string
+	Description string
+	Run         func(*Session) error
+}
+
+// Result is the result of a scenario.
+type Result struct {
+	Scenario *Scenario
+	Err      error
+	Duration time.Duration
+}
+
+// Passed indicates the scenario passed.
+func (r *Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Scenarios are all the scenarios in order.
+var Scenarios = []*Scenario{
+	{
+		Name:        "sync",
+		Description: "sync with REQ/ACK, and resync with a new seq",
+		Run:         scenarioSync,
+	},
+	{
+		Name:        "seq-wrap",
+		Description: "PacketSeq wraps around at 0xf0 in both directions",
+		Run:         scenarioSeqWrap,
+	},
+	{
+		Name:        "long-length",
+		Description: "packets with data length encoded in a separate byte",
+		Run:         scenarioLongLength,
+	},
+	{
+		Name:        "mid-packet-timeout",
+		Description: "resync when a packet is incomplete for the peer timeout",
+		Run:         scenarioMidPacketTimeout,
+	},
+	{
+		Name:        "ack-in-msg-seq",
+		Description: "ACK in place of a message seq keeps sync, or resyncs with a wrong seq",
+		Run:         scenarioAckInMsgSeq,
+	},
+}
+
+// Find finds a scenario by name.
+func Find(name string) *Scenario {
+	for _, s := range Scenarios {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Run runs scenarios against the peer, and reports each result to fn if
+// not nil. All of Scenarios are run if scenarios is empty.
+func Run(peer io.ReadWriter, conf *Config, scenarios []*Scenario, fn func(*Result)) []*Result {
+	if len(scenarios) == 0 {
+		scenarios = Scenarios
+	}
+	s := NewSession(peer, conf)
+	results := make([]*Result, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		start := time.Now()
+		s.drain()
+		r := &Result{Scenario: scenario, Err: scenario.Run(s)}
+		r.Duration = time.Since(start)
+		results = append(results, r)
+		if fn != nil {
+			fn(r)
+		}
+	}
+	return results
+}
@@ -0,0 +1,61 @@
+package conformance
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+func startDevice(t *testing.T, crc bool) (net.Conn, func()) {
+	local, remote := net.Pipe()
+	fifo := comm.NewFIFO(remote)
+	fifo.CRC = crc
+	device := comm.NewDevice(fifo)
+	device.HandleFunc(2, func(ctx context.Context, pkt *comm.Packet) comm.Result {
+		return comm.Result{Code: pkt.Code, Data: pkt.Data}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go device.Run(ctx)
+	return local, func() {
+		cancel()
+		local.Close()
+		remote.Close()
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	for _, crc := range []bool{false, true} {
+		peer, stop := startDevice(t, crc)
+		conf := DefaultConfig()
+		conf.CRC = crc
+		results := Run(peer, conf, nil, nil)
+		stop()
+		require.Len(t, results, len(Scenarios))
+		for _, r := range results {
+			require.NoError(t, r.Err, "crc=%v scenario %s", crc, r.Scenario.Name)
+		}
+	}
+}
+
+type sinkPeer struct {
+	io.Reader
+	io.Writer
+}
+
+func TestScenariosFail(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	conf := DefaultConfig()
+	conf.ReplyTimeout = 20 * time.Millisecond
+	conf.PeerTimeout = 10 * time.Millisecond
+	results := Run(&sinkPeer{Reader: r, Writer: ioutil.Discard}, conf, []*Scenario{Find("sync")}, nil)
+	require.Len(t, results, 1)
+	require.False(t, results[0].Passed())
+}
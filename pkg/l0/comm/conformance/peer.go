@@ -0,0 +1,79 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ExecPrefix is the prefix of peer spec to run an executable.
+const ExecPrefix = "exec:"
+
+// OpenPeer opens the byte stream of a peer from a spec:
+//
+//	tcp://HOST:PORT     connects to a TCP socket;
+//	exec:CMD [ARGS...]  runs an executable and talks over its stdin/stdout.
+func OpenPeer(spec string) (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(spec, ExecPrefix) {
+		args := strings.Fields(spec[len(ExecPrefix):])
+		if len(args) == 0 {
+			return nil, fmt.Errorf("command is missing in %q", spec)
+		}
+		return StartExecPeer(args[0], args[1:]...)
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "tcp" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return net.Dial("tcp", u.Host)
+}
+
+// ExecPeer is a peer running as a child process.
+type ExecPeer struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out io.ReadCloser
+}
+
+// StartExecPeer starts the executable as the peer.
+// The stderr of the peer is forwarded to os.Stderr.
+func StartExecPeer(name string, args ...string) (*ExecPeer, error) {
+	p := &ExecPeer{cmd: exec.Command(name, args...)}
+	p.cmd.Stderr = os.Stderr
+	var err error
+	if p.in, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if p.out, err = p.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err = p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Read implements io.Reader.
+func (p *ExecPeer) Read(b []byte) (int, error) {
+	return p.out.Read(b)
+}
+
+// Write implements io.Writer.
+func (p *ExecPeer) Write(b []byte) (int, error) {
+	return p.in.Write(b)
+}
+
+// Close kills the process.
+func (p *ExecPeer) Close() error {
+	p.in.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	return nil
+}
@@ -0,0 +1,88 @@
+package conformance
+
+import (
+	"fmt"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+func scenarioSync(s *Session) error {
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	if err := s.Echo(1, 2, 3); err != nil {
+		return err
+	}
+	// resync in ready state with a different seq.
+	s.SetSeq(s.Seq().Next().Next().Next())
+	if err := s.Sync(); err != nil {
+		return fmt.Errorf("resync: %v", err)
+	}
+	return s.Echo(4, 5, 6)
+}
+
+func scenarioSeqWrap(s *Session) error {
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	// enough commands for both sides to wrap around.
+	for n := 0; n < 0xf0+8; n++ {
+		if err := s.Echo(byte(n)); err != nil {
+			return fmt.Errorf("command %d (seq %02x): %v", n, byte(s.Seq()), err)
+		}
+	}
+	return nil
+}
+
+func scenarioLongLength(s *Session) error {
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	// the reply carries one more byte for the request seq.
+	for _, size := range []int{0, 6, 7, 8, 64, comm.MaxDataLen - 1} {
+		data := make([]byte, size)
+		for n := range data {
+			data[n] = byte(n + size)
+		}
+		if err := s.Echo(data...); err != nil {
+			return fmt.Errorf("data length %d: %v", size, err)
+		}
+	}
+	return nil
+}
+
+func scenarioMidPacketTimeout(s *Session) error {
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	// seq, code with 3 bytes of data, and only the first byte.
+	if err := s.Write(byte(s.Seq()), s.conf.EchoCode|0x30, 1); err != nil {
+		return err
+	}
+	if err := s.ExpectResync(2*s.conf.PeerTimeout + s.conf.ReplyTimeout); err != nil {
+		return err
+	}
+	return s.Echo(7, 8, 9)
+}
+
+func scenarioAckInMsgSeq(s *Session) error {
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	if err := s.Write(comm.SyncACK, byte(s.Seq())); err != nil {
+		return err
+	}
+	if err := s.ExpectQuiet(2 * s.conf.PeerTimeout); err != nil {
+		return fmt.Errorf("ACK with expected seq: %v", err)
+	}
+	if err := s.Echo(1); err != nil {
+		return err
+	}
+	if err := s.Write(comm.SyncACK, byte(s.Seq().Next())); err != nil {
+		return err
+	}
+	if err := s.ExpectResync(s.conf.ReplyTimeout); err != nil {
+		return fmt.Errorf("ACK with wrong seq: %v", err)
+	}
+	return s.Echo(2)
+}
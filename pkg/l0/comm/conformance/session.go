@@ -0,0 +1,234 @@
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+var (
+	// ErrNoResponse indicates the peer didn't respond in time.
+	ErrNoResponse = errors.New("no response from peer")
+	// ErrUnexpectedResync indicates the peer requested resync unexpectedly.
+	ErrUnexpectedResync = errors.New("unexpected resync from peer")
+)
+
+// Session is the local side of the link driving the peer.
+// It's a minimal FIFO operated synchronously by scenarios.
+type Session struct {
+	conf   Config
+	peer   io.ReadWriter
+	parser comm.Parser
+	seq    comm.PacketSeq
+	byteCh chan byte
+	err    error
+}
+
+// NewSession creates a Session and starts reading from the peer.
+func NewSession(peer io.ReadWriter, conf *Config) *Session {
+	if conf == nil {
+		conf = DefaultConfig()
+	}
+	s := &Session{
+		conf:   *conf,
+		peer:   peer,
+		seq:    comm.NewPacketSeq(),
+		byteCh: make(chan byte, 4096),
+	}
+	s.parser.CRC = conf.CRC
+	go s.readLoop()
+	return s
+}
+
+// Seq gets the seq of the next packet to send.
+func (s *Session) Seq() comm.PacketSeq {
+	return s.seq
+}
+
+// SetSeq sets the seq of the next packet to send.
+func (s *Session) SetSeq(seq comm.PacketSeq) {
+	s.seq = seq
+}
+
+// Write writes raw bytes to the peer.
+func (s *Session) Write(b ...byte) error {
+	_, err := s.peer.Write(b)
+	return err
+}
+
+// Sync resets the local parser and synchronizes with the peer.
+func (s *Session) Sync() error {
+	if err := s.apply(s.parser.Reset()); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(s.conf.ReplyTimeout)
+	for !s.parser.State().IsReady() {
+		b, err := s.read(deadline)
+		if err != nil {
+			return fmt.Errorf("sync: %v", err)
+		}
+		if err = s.apply(s.parser.Parse(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send sends a packet with the next seq.
+func (s *Session) Send(pkt *comm.Packet) error {
+	pkt.Seq = s.seq
+	var b []byte
+	var err error
+	if s.conf.CRC {
+		b, err = pkt.BytesWithCRC()
+	} else {
+		b, err = pkt.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+	if err = s.Write(b...); err != nil {
+		return err
+	}
+	s.seq = s.seq.Next()
+	return nil
+}
+
+// Expect waits for the next packet which is not an event.
+// It fails with ErrUnexpectedResync if the peer loses sync.
+func (s *Session) Expect(timeout time.Duration) (*comm.Packet, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		b, err := s.read(deadline)
+		if err != nil {
+			return nil, err
+		}
+		pr := s.parser.Parse(b)
+		if err = s.apply(pr); err != nil {
+			return nil, err
+		}
+		if pr.Sync != 0 || !pr.State.IsReady() {
+			return nil, ErrUnexpectedResync
+		}
+		if pkt := pr.Packet; pkt != nil && pkt.Code&0x80 == 0 {
+			return pkt, nil
+		}
+	}
+}
+
+// ExpectResync waits for the peer to request resync and synchronizes again.
+func (s *Session) ExpectResync(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		b, err := s.read(deadline)
+		if err != nil {
+			return fmt.Errorf("expect resync: %v", err)
+		}
+		pr := s.parser.Parse(b)
+		if err = s.apply(pr); err != nil {
+			return err
+		}
+		if pr.Sync == comm.SyncACK {
+			return nil
+		}
+		if pr.Sync == comm.SyncREQ {
+			return s.Sync()
+		}
+		if pkt := pr.Packet; pkt != nil && pkt.Code&0x80 == 0 {
+			return fmt.Errorf("expect resync: unexpected packet %02x", pkt.Code)
+		}
+	}
+}
+
+// ExpectQuiet ensures the peer stays in sync and sends nothing but events
+// or heartbeats for the duration.
+func (s *Session) ExpectQuiet(duration time.Duration) error {
+	pkt, err := s.Expect(duration)
+	if err == ErrNoResponse {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected packet %02x", pkt.Code)
+	}
+	return err
+}
+
+// Echo sends the echo command and validates the reply.
+func (s *Session) Echo(data ...byte) error {
+	seq := s.seq
+	if err := s.Send(&comm.Packet{Code: s.conf.EchoCode, Data: data}); err != nil {
+		return err
+	}
+	pkt, err := s.Expect(s.conf.ReplyTimeout)
+	if err != nil {
+		return err
+	}
+	if len(pkt.Data) == 0 || pkt.Data[0] != byte(seq) {
+		return fmt.Errorf("reply not for request seq %02x: %v", byte(seq), pkt.Data)
+	}
+	if pkt.Code&1 != 0 {
+		return &comm.CommandError{Code: pkt.Code & 0x7e}
+	}
+	if pkt.Code != s.conf.EchoCode&0x0e {
+		return fmt.Errorf("reply code %02x, expect %02x", pkt.Code, s.conf.EchoCode&0x0e)
+	}
+	if !bytes.Equal(pkt.Data[1:], data) {
+		return fmt.Errorf("reply data mismatch: % x, expect % x", pkt.Data[1:], data)
+	}
+	return nil
+}
+
+// apply writes the sync command from the parser.
+func (s *Session) apply(pr comm.ParseResult) error {
+	if pr.Sync != 0 {
+		return s.Write(pr.Sync, byte(s.seq))
+	}
+	return nil
+}
+
+// drain discards received bytes not consumed.
+func (s *Session) drain() {
+	for {
+		select {
+		case _, ok := <-s.byteCh:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *Session) read(deadline time.Time) (byte, error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case b, ok := <-s.byteCh:
+		if !ok {
+			return 0, s.err
+		}
+		return b, nil
+	case <-timer.C:
+		return 0, ErrNoResponse
+	}
+}
+
+func (s *Session) readLoop() {
+	buf := make([]byte, 256)
+	for {
+		n, err := s.peer.Read(buf)
+		for _, b := range buf[:n] {
+			s.byteCh <- b
+		}
+		if err != nil {
+			s.err = err
+			close(s.byteCh)
+			return
+		}
+	}
+}
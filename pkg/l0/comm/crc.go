@@ -0,0 +1,28 @@
+package comm
+
+// crc8Poly is the CRC-8 polynomial x^8 + x^2 + x + 1 (CRC-8/SMBUS).
+const crc8Poly byte = 0x07
+
+var crc8Table = func() (t [256]byte) {
+	for i := range t {
+		crc := byte(i)
+		for n := 0; n < 8; n++ {
+			if crc&0x80 != 0 {
+				crc = (crc << 1) ^ crc8Poly
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return
+}()
+
+// CRC8 updates crc with bytes in p.
+// Use 0 as initial value for a new checksum.
+func CRC8(crc byte, p ...byte) byte {
+	for _, b := range p {
+		crc = crc8Table[crc^b]
+	}
+	return crc
+}
@@ -0,0 +1,117 @@
+package comm
+
+import (
+	"context"
+	"sync"
+)
+
+// CommandHandler handles a command received by Device.
+// The returned Result is sent back as the reply. If Result.Err is a
+// *CommandError, the reply carries the error code, and any other
+// error is replied as CommandError with code 0.
+type CommandHandler interface {
+	HandleCommand(context.Context, *Packet) Result
+}
+
+// HandleCommandFunc is func type of CommandHandler.
+type HandleCommandFunc func(context.Context, *Packet) Result
+
+// HandleCommand implements CommandHandler.
+func (f HandleCommandFunc) HandleCommand(ctx context.Context, pkt *Packet) Result {
+	return f(ctx, pkt)
+}
+
+// Device provides the device (L0 firmware) side operations over FIFO.
+// It's the peer of Client and is mostly used to emulate firmware in Go,
+// e.g. for testing L1 controllers without hardware.
+//
+// Commands are dispatched to handlers registered by command code, and
+// handlers run in the FIFO receiving loop, so they should not block.
+// Commands without a handler are replied with CommandError code 0.
+type Device struct {
+	fifo     *FIFO
+	handlers map[byte]CommandHandler
+	lock     sync.RWMutex
+}
+
+// NewDevice creates device and wraps the fifo.
+func NewDevice(fifo *FIFO) *Device {
+	d := &Device{
+		fifo:     fifo,
+		handlers: make(map[byte]CommandHandler),
+	}
+	d.fifo.Handler = d
+	return d
+}
+
+// FIFO gets wrapped FIFO.
+func (d *Device) FIFO() *FIFO {
+	return d.fifo
+}
+
+// Handle registers the handler for a command code.
+// A nil handler removes the registration.
+func (d *Device) Handle(code byte, handler CommandHandler) *Device {
+	code &= 0x0f
+	d.lock.Lock()
+	if handler == nil {
+		delete(d.handlers, code)
+	} else {
+		d.handlers[code] = handler
+	}
+	d.lock.Unlock()
+	return d
+}
+
+// HandleFunc registers a func as the handler for a command code.
+func (d *Device) HandleFunc(code byte, fn HandleCommandFunc) *Device {
+	return d.Handle(code, fn)
+}
+
+// Emit sends an event.
+func (d *Device) Emit(code byte, data []byte) error {
+	return d.fifo.Send(&Packet{Code: code | 0x80, Data: data})
+}
+
+// HandlePacket implements PacketHandler.
+func (d *Device) HandlePacket(ctx context.Context, pkt *Packet) {
+	if pkt.Code&0x80 != 0 {
+		// events are not expected from the peer.
+		return
+	}
+	d.lock.RLock()
+	handler := d.handlers[pkt.Code]
+	d.lock.RUnlock()
+	var r Result
+	if handler != nil {
+		r = handler.HandleCommand(ctx, pkt)
+	} else {
+		r.Err = &CommandError{}
+	}
+	d.fifo.Send(ReplyPacket(pkt.Seq, r))
+}
+
+// Run wraps FIFO.Run to implement Runnable.
+func (d *Device) Run(ctx context.Context) error {
+	return d.fifo.Run(ctx)
+}
+
+// ReplyPacket builds the reply packet of a command.
+// The request seq is encoded in Data[0], followed by Result.Data.
+// The reply code is taken from Result.Code, or from the error code
+// with the error bit set if Result.Err is not nil.
+func ReplyPacket(requestSeq PacketSeq, r Result) *Packet {
+	pkt := &Packet{Data: make([]byte, len(r.Data)+1)}
+	pkt.Data[0] = byte(requestSeq)
+	if r.Err != nil {
+		if cmdErr, ok := r.Err.(*CommandError); ok {
+			pkt.Code = cmdErr.Code
+		}
+		pkt.Code = (pkt.Code & 0x0e) | 1
+		pkt.Data = pkt.Data[:1]
+	} else {
+		pkt.Code = r.Code & 0x0e
+		copy(pkt.Data[1:], r.Data)
+	}
+	return pkt
+}
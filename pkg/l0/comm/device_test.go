@@ -0,0 +1,89 @@
+package comm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm/commtest"
+)
+
+func newLinkedFIFOs() (*FIFO, *FIFO) {
+	rw1, rw2 := commtest.Pipe(256)
+	return NewFIFO(rw1), NewFIFO(rw2)
+}
+
+func waitReady(t *testing.T, fifos ...*FIFO) {
+	deadline := time.Now().Add(time.Second)
+	for _, fifo := range fifos {
+		for !fifo.State().IsReady() {
+			require.True(t, time.Now().Before(deadline), "sync timeout")
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestReplyPacket(t *testing.T) {
+	testCases := []struct {
+		name   string
+		result Result
+		expect Packet
+	}{
+		{"no data", Result{Code: 2}, Packet{Code: 2, Data: []byte{5}}},
+		{"data", Result{Code: 4, Data: []byte{1, 2}}, Packet{Code: 4, Data: []byte{5, 1, 2}}},
+		{"error", Result{Err: &CommandError{Code: 6}, Data: []byte{1}}, Packet{Code: 7, Data: []byte{5}}},
+		{"generic error", Result{Err: ErrNotReady}, Packet{Code: 1, Data: []byte{5}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, &tc.expect, ReplyPacket(PacketSeq(5), tc.result))
+		})
+	}
+}
+
+func TestDevice(t *testing.T) {
+	clientFIFO, deviceFIFO := newLinkedFIFOs()
+	client, device := NewClient(clientFIFO), NewDevice(deviceFIFO)
+	device.HandleFunc(2, func(ctx context.Context, pkt *Packet) Result {
+		data := make([]byte, len(pkt.Data))
+		for n, b := range pkt.Data {
+			data[len(data)-n-1] = b
+		}
+		return Result{Code: 4, Data: data}
+	}).HandleFunc(4, func(ctx context.Context, pkt *Packet) Result {
+		return Result{Err: &CommandError{Code: 8}}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+	go client.Run(ctx)
+	go device.Run(ctx)
+	waitReady(t, clientFIFO, deviceFIFO)
+
+	opts := &CommandOptions{Timeout: time.Second}
+	r := client.DoContext(ctx, &Packet{Code: 2, Data: []byte{1, 2, 3}}, opts)
+	require.NoError(t, r.Err)
+	require.Equal(t, byte(4), r.Code)
+	require.Equal(t, []byte{3, 2, 1}, r.Data)
+
+	r = client.DoContext(ctx, &Packet{Code: 4}, opts)
+	require.Equal(t, &CommandError{Code: 8}, r.Err)
+
+	r = client.DoContext(ctx, &Packet{Code: 6}, opts)
+	require.Equal(t, &CommandError{Code: 0}, r.Err)
+
+	require.NoError(t, device.Emit(3, []byte{9}))
+	select {
+	case pkt := <-client.EventChan():
+		require.Equal(t, byte(0x83), pkt.Code)
+		require.Equal(t, []byte{9}, pkt.Data)
+	case <-time.After(time.Second):
+		t.Fatal("event timeout")
+	}
+}
@@ -7,9 +7,30 @@ package comm
 //
 // This package uses a simple sequence based synchronization mechanism.
 // It provides limited transfer error detection based on sequence
-// check. However, it doesn't do any bit verification (e.g. CRC/Checksum)
+// check. By default, it doesn't do any bit verification (e.g. CRC/Checksum)
 // for simplicity and to be lightweighted.
-// If needed, parity bits can be enabled on serial port for verification.
+// If needed, parity bits can be enabled on serial port for verification,
+// or CRC-8 can be enabled (see FIFO.CRC) when parity is not available.
+// With CRC-8 enabled, each packet is followed by a CRC-8 byte, and a packet
+// failing the verification is dropped and triggers resync.
+//
+// The data of a single packet is limited to MaxDataLen (127) bytes.
+// Larger payloads can be transferred by enabling fragmentation
+// (see FIFO.Fragment and Fragment) on both ends.
+//
+// An idle link doesn't detect a peer reset or disconnection by itself.
+// Keepalive (see FIFO.KeepaliveInterval) can be enabled on both ends to
+// send heartbeats while idle, and a FIFO not receiving anything for a few
+// intervals resyncs, which fails the pending commands of Client.
+//
+// Mux runs multiple logical channels over one FIFO, each channel is a
+// Client with its own seq space and a priority for sending.
+//
+// Bridge shares the link of a FIFO with a remote client over TCP or
+// WebSocket for debugging, and Dial creates a FIFO over the remote link.
 //
 // Producer: L0 firmware
 // Consumer: L1 controller
+//
+// Client implements the consumer side, and Device implements the producer
+// side which can be used to emulate L0 firmware in Go.
@@ -12,6 +12,19 @@ var (
 	// This happens when a reply is received for a latter command, and all
 	// previous commands fail with this error.
 	ErrNoReply = errors.New("no reply")
+	// ErrTimeout indicates no reply received before the command deadline.
+	ErrTimeout = errors.New("command timeout")
+	// ErrSyncLost indicates the FIFO lost sync while the command is pending.
+	ErrSyncLost = errors.New("sync lost")
+	// ErrPacketTooLarge indicates the packet data exceeds the max length.
+	ErrPacketTooLarge = errors.New("packet too large")
+	// ErrWindowFull indicates the in-flight window of Client is full
+	// with WindowFailFast.
+	ErrWindowFull = errors.New("in-flight window full")
+	// ErrLinkBusy indicates the link is owned by the remote client of Bridge.
+	ErrLinkBusy = errors.New("link busy")
+	// ErrChannelExists indicates the channel ID is already used in a Mux.
+	ErrChannelExists = errors.New("channel already exists")
 )
 
 // CommandError wraps error codes from reply.
@@ -0,0 +1,225 @@
+package comm
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy defines what to do when an event queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest drops the oldest queued event for the new one.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest drops the new event.
+	OverflowDropNewest
+	// OverflowBlock blocks the receiving loop until there's room.
+	// Use with caution as it stalls all packets including replies.
+	OverflowBlock
+)
+
+// EventQueueOptions defines the queue of an event route.
+type EventQueueOptions struct {
+	// QueueSize is the max number of queued events, at least 1.
+	QueueSize int
+	// Overflow is the policy when the queue is full.
+	Overflow OverflowPolicy
+}
+
+// EventRouter routes L0 events by event code to handlers.
+// Each route has its own queue and a goroutine calling the handler,
+// so a slow handler doesn't stall the FIFO receiving loop, unless
+// OverflowBlock is used. The goroutines stop when Client.Run returns,
+// and are started again by the next Client.Run.
+type EventRouter struct {
+	unhandled uint64
+	routes    map[byte]*EventRoute
+	doneCh    chan struct{} // closed to stop goroutines, nil if stopped
+	wg        sync.WaitGroup
+	lock      sync.RWMutex
+}
+
+// EventRoute is a registered handler for an event code.
+type EventRoute struct {
+	dropped  uint64
+	code     byte
+	handler  PacketHandler
+	overflow OverflowPolicy
+	queue    chan eventItem
+	stopCh   chan struct{}
+}
+
+type eventItem struct {
+	ctx context.Context
+	pkt *Packet
+}
+
+func newEventRouter() *EventRouter {
+	return &EventRouter{
+		routes: make(map[byte]*EventRoute),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// Handle registers the handler for an event code, replacing the
+// existing one. opts can be nil for a queue of size 1 with OverflowDropOldest.
+func (r *EventRouter) Handle(code byte, handler PacketHandler, opts *EventQueueOptions) *EventRoute {
+	route := &EventRoute{
+		code:    code&0x0f | 0x80,
+		handler: handler,
+		stopCh:  make(chan struct{}),
+	}
+	size := 1
+	if opts != nil {
+		if opts.QueueSize > size {
+			size = opts.QueueSize
+		}
+		route.overflow = opts.Overflow
+	}
+	route.queue = make(chan eventItem, size)
+	r.lock.Lock()
+	prev := r.routes[route.code]
+	r.routes[route.code] = route
+	if r.doneCh != nil {
+		r.runRoute(route)
+	}
+	r.lock.Unlock()
+	if prev != nil {
+		prev.stop()
+	}
+	return route
+}
+
+// HandleFunc registers a func as the handler for an event code.
+func (r *EventRouter) HandleFunc(code byte, fn HandlePacketFunc, opts *EventQueueOptions) *EventRoute {
+	return r.Handle(code, fn, opts)
+}
+
+// Remove removes the route of an event code.
+// Queued events not yet handled are discarded.
+func (r *EventRouter) Remove(code byte) {
+	code = code&0x0f | 0x80
+	r.lock.Lock()
+	route := r.routes[code]
+	delete(r.routes, code)
+	r.lock.Unlock()
+	if route != nil {
+		route.stop()
+	}
+}
+
+// Unhandled returns the number of events dropped because no route
+// is registered for the event code.
+func (r *EventRouter) Unhandled() uint64 {
+	return atomic.LoadUint64(&r.unhandled)
+}
+
+// start starts the goroutines of routes if stopped.
+func (r *EventRouter) start() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.doneCh != nil {
+		return
+	}
+	r.doneCh = make(chan struct{})
+	for _, route := range r.routes {
+		r.runRoute(route)
+	}
+}
+
+// stop stops the goroutines of routes and waits for them to exit,
+// queued events are handled after started again.
+func (r *EventRouter) stop() {
+	r.lock.Lock()
+	if r.doneCh != nil {
+		close(r.doneCh)
+		r.doneCh = nil
+	}
+	r.lock.Unlock()
+	r.wg.Wait()
+}
+
+// runRoute starts the goroutine of a route with the lock held.
+func (r *EventRouter) runRoute(route *EventRoute) {
+	r.wg.Add(1)
+	go func(doneCh <-chan struct{}) {
+		defer r.wg.Done()
+		route.run(doneCh)
+	}(r.doneCh)
+}
+
+// dispatch queues the event to the route and returns false if
+// no route is registered.
+func (r *EventRouter) dispatch(ctx context.Context, pkt *Packet) bool {
+	r.lock.RLock()
+	route := r.routes[pkt.Code]
+	r.lock.RUnlock()
+	if route == nil {
+		return false
+	}
+	route.enqueue(eventItem{ctx: ctx, pkt: pkt})
+	return true
+}
+
+func (r *EventRouter) countUnhandled() {
+	atomic.AddUint64(&r.unhandled, 1)
+}
+
+// Code returns the event code of the route.
+func (r *EventRoute) Code() byte {
+	return r.code
+}
+
+// Dropped returns the number of events dropped due to overflow.
+func (r *EventRoute) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+func (r *EventRoute) enqueue(item eventItem) {
+	switch r.overflow {
+	case OverflowBlock:
+		select {
+		case r.queue <- item:
+		case <-r.stopCh:
+		case <-item.ctx.Done():
+			atomic.AddUint64(&r.dropped, 1)
+		}
+	case OverflowDropNewest:
+		select {
+		case r.queue <- item:
+		default:
+			atomic.AddUint64(&r.dropped, 1)
+		}
+	default:
+		for {
+			select {
+			case r.queue <- item:
+				return
+			default:
+			}
+			select {
+			case <-r.queue:
+				atomic.AddUint64(&r.dropped, 1)
+			default:
+			}
+		}
+	}
+}
+
+func (r *EventRoute) run(doneCh <-chan struct{}) {
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-doneCh:
+			return
+		case item := <-r.queue:
+			r.handler.HandlePacket(item.ctx, item.pkt)
+		}
+	}
+}
+
+func (r *EventRoute) stop() {
+	close(r.stopCh)
+}
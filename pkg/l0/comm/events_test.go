@@ -0,0 +1,144 @@
+package comm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type eventTestHandler struct {
+	enterCh   chan *Packet
+	releaseCh chan struct{}
+}
+
+func newEventTestHandler() *eventTestHandler {
+	return &eventTestHandler{
+		enterCh:   make(chan *Packet, 16),
+		releaseCh: make(chan struct{}),
+	}
+}
+
+func (h *eventTestHandler) HandlePacket(ctx context.Context, pkt *Packet) {
+	h.enterCh <- pkt
+	<-h.releaseCh
+}
+
+func (h *eventTestHandler) expect(t *testing.T, data byte) {
+	select {
+	case pkt := <-h.enterCh:
+		require.Equal(t, []byte{data}, pkt.Data)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("expect event %d timeout", data)
+	}
+}
+
+func (h *eventTestHandler) release() {
+	h.releaseCh <- struct{}{}
+}
+
+func emitEvents(client *Client, code byte, data ...byte) {
+	for _, b := range data {
+		client.HandlePacket(context.Background(), &Packet{Code: code | 0x80, Data: []byte{b}})
+	}
+}
+
+func TestEventRouterOverflow(t *testing.T) {
+	testCases := []struct {
+		name     string
+		overflow OverflowPolicy
+		handled  []byte
+		dropped  uint64
+	}{
+		{"drop oldest", OverflowDropOldest, []byte{1, 4}, 2},
+		{"drop newest", OverflowDropNewest, []byte{1, 2}, 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, h := NewClient(NewFIFO(nil)), newEventTestHandler()
+			route := client.Events().Handle(3, h, &EventQueueOptions{QueueSize: 1, Overflow: tc.overflow})
+			defer client.Events().Remove(3)
+			emitEvents(client, 3, 1)
+			h.expect(t, tc.handled[0])
+			emitEvents(client, 3, 2, 3, 4)
+			require.Equal(t, tc.dropped, route.Dropped())
+			h.release()
+			h.expect(t, tc.handled[1])
+			h.release()
+			require.Zero(t, client.Events().Unhandled())
+		})
+	}
+}
+
+func TestEventRouterBlock(t *testing.T) {
+	client, h := NewClient(NewFIFO(nil)), newEventTestHandler()
+	client.Events().Handle(3, h, &EventQueueOptions{Overflow: OverflowBlock})
+	defer client.Events().Remove(3)
+	emitEvents(client, 3, 1)
+	h.expect(t, 1)
+	emitEvents(client, 3, 2)
+	doneCh := make(chan struct{})
+	go func() {
+		emitEvents(client, 3, 3)
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+		t.Fatal("expect blocking")
+	case <-time.After(50 * time.Millisecond):
+	}
+	h.release()
+	h.expect(t, 2)
+	<-doneCh
+	h.release()
+	h.expect(t, 3)
+	h.release()
+}
+
+func TestEventRouterUnhandled(t *testing.T) {
+	client, h := NewClient(NewFIFO(nil)), newEventTestHandler()
+	client.Events().Handle(3, h, nil)
+	emitEvents(client, 3, 1)
+	h.expect(t, 1)
+	h.release()
+	emitEvents(client, 4, 1, 2, 3)
+	require.Equal(t, uint64(2), client.Events().Unhandled())
+	pkt := <-client.EventChan()
+	require.Equal(t, byte(0x84), pkt.Code)
+	require.Equal(t, []byte{1}, pkt.Data)
+
+	client.Events().Remove(3)
+	emitEvents(client, 3, 2)
+	<-client.EventChan()
+	require.Equal(t, uint64(2), client.Events().Unhandled())
+}
+
+func TestEventRouterStopsWithRun(t *testing.T) {
+	env := newClientTestEnv(t)
+	go func() {
+		for range env.writeCh {
+		}
+	}()
+	client, h := env.client, newEventTestHandler()
+	client.Events().Handle(3, h, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		client.Run(ctx)
+		close(doneCh)
+	}()
+	emitEvents(client, 3, 1)
+	h.expect(t, 1)
+	h.release()
+
+	cancel()
+	close(env.readCh)
+	<-doneCh
+	emitEvents(client, 3, 2)
+	select {
+	case <-h.enterCh:
+		t.Fatal("event handled after Run returns")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -41,15 +41,33 @@ type FIFO struct {
 	Notifier    StateNotifier
 	Timeout     time.Duration
 	ReadTimeout bool // set to true if ReadWriter already supports timeout with Read
+	CRC         bool // set to true to append/verify CRC-8 on each packet, peer must agree
+	Fragment    bool // set to true to fragment/reassemble data exceeding MaxDataLen, peer must agree
+
+	// KeepaliveInterval enables heartbeats when non-zero, peer must agree.
+	// A heartbeat (SyncACK with current seq) is sent when nothing has been
+	// sent for about the interval, and the peer is considered dead if nothing
+	// is received for KeepaliveMisses intervals, which triggers resync.
+	KeepaliveInterval time.Duration
+	KeepaliveMisses   int // default is DefaultKeepaliveMisses if not positive
 
 	seq   PacketSeq
 	state SyncState
+	stats FIFOStats
 	lock  sync.RWMutex
 
-	syncTimer <-chan time.Time
-	parser    Parser
+	syncTimer   <-chan time.Time
+	parser      Parser
+	reassembler reassembler
+	lastSent    time.Time
+	lastRecv    time.Time
+	bridge      *Bridge
 }
 
+// DefaultKeepaliveMisses is the default number of keepalive intervals
+// without receiving anything before the peer is considered dead.
+const DefaultKeepaliveMisses = 3
+
 // NewFIFO creates a FIFO.
 func NewFIFO(rw io.ReadWriter) *FIFO {
 	return &FIFO{
@@ -66,28 +84,86 @@ func (f *FIFO) State() SyncState {
 	return f.state
 }
 
+// Stats gets a snapshot of the statistics.
+func (f *FIFO) Stats() FIFOStats {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	stats := f.stats
+	stats.State = f.state
+	return stats
+}
+
 // Send sends a packet.
+// If Fragment is enabled, data exceeding MaxDataLen is sent in
+// consecutive fragments, and pkt.Seq is set to the seq of the first one.
+// It fails with ErrLinkBusy if the FIFO is shared by a Bridge and
+// a remote client is connected.
 func (f *FIFO) Send(pkt *Packet) error {
+	if b := f.bridge; b != nil && b.Connected() {
+		return ErrLinkBusy
+	}
+	return f.send(pkt)
+}
+
+func (f *FIFO) send(pkt *Packet) error {
 	f.lock.Lock()
 	defer f.lock.Unlock()
 	if !f.state.IsReady() {
 		return ErrNotReady
 	}
-	pkt.Seq = f.seq
-	if _, err := pkt.WriteTo(f.ReadWriter); err != nil {
-		return err
+	pkts := []*Packet{pkt}
+	if f.Fragment {
+		var err error
+		if pkts, err = Fragment(pkt); err != nil {
+			return err
+		}
+	}
+	seq := f.seq
+	for _, p := range pkts {
+		p.Seq = f.seq
+		if err := f.writePacket(p); err != nil {
+			return err
+		}
+		f.seq = f.seq.Next()
+		f.stats.PacketsSent++
 	}
-	f.seq = f.seq.Next()
+	pkt.Seq = seq
 	return nil
 }
 
+func (f *FIFO) writePacket(pkt *Packet) error {
+	if !f.CRC {
+		n, err := pkt.WriteTo(f.ReadWriter)
+		f.stats.BytesSent += uint64(n)
+		f.lastSent = time.Now()
+		return err
+	}
+	b, err := pkt.BytesWithCRC()
+	if err == nil {
+		var n int
+		n, err = f.ReadWriter.Write(b)
+		f.stats.BytesSent += uint64(n)
+		f.lastSent = time.Now()
+	}
+	return err
+}
+
 // Run processes the FIFO in the background.
 func (f *FIFO) Run(ctx context.Context) error {
+	f.parser.CRC = f.CRC
+	f.reassembler.reset()
 	err := f.applyParseResult(ctx, f.parser.Reset())
 	if err != nil {
 		return err
 	}
 
+	var keepalive <-chan time.Time
+	if f.KeepaliveInterval > 0 {
+		ticker := time.NewTicker(f.KeepaliveInterval)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+
 	if f.ReadTimeout {
 		buf := make([]byte, 1)
 		for {
@@ -98,6 +174,10 @@ func (f *FIFO) Run(ctx context.Context) error {
 				if err = f.applyParseResult(ctx, f.parser.Timeout()); err != nil {
 					return err
 				}
+			case now := <-keepalive:
+				if err = f.keepalive(ctx, now); err != nil {
+					return err
+				}
 			default:
 				n, err := f.ReadWriter.Read(buf)
 				if err != nil {
@@ -107,6 +187,7 @@ func (f *FIFO) Run(ctx context.Context) error {
 				} else if n == 0 {
 					err = f.applyParseResult(ctx, f.parser.Timeout())
 				} else {
+					f.lastRecv = time.Now()
 					err = f.applyParseResult(ctx, f.parser.Parse(buf[0]))
 				}
 				if err != nil {
@@ -122,6 +203,7 @@ func (f *FIFO) Run(ctx context.Context) error {
 		for {
 			select {
 			case b := <-byteCh:
+				f.lastRecv = time.Now()
 				if err = f.applyParseResult(ctx, f.parser.Parse(b)); err != nil {
 					return err
 				}
@@ -133,11 +215,42 @@ func (f *FIFO) Run(ctx context.Context) error {
 				if err = f.applyParseResult(ctx, f.parser.Timeout()); err != nil {
 					return err
 				}
+			case now := <-keepalive:
+				if err = f.keepalive(ctx, now); err != nil {
+					return err
+				}
 			}
 		}
 	}
 }
 
+// keepalive sends a heartbeat if idle, and resyncs if the peer is dead.
+func (f *FIFO) keepalive(ctx context.Context, now time.Time) error {
+	if !f.State().IsReady() {
+		return nil
+	}
+	misses := f.KeepaliveMisses
+	if misses <= 0 {
+		misses = DefaultKeepaliveMisses
+	}
+	if now.Sub(f.lastRecv) >= time.Duration(misses)*f.KeepaliveInterval {
+		f.lock.Lock()
+		f.stats.PeerDead++
+		f.lock.Unlock()
+		return f.applyParseResult(ctx, f.parser.Reset())
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if now.Sub(f.lastSent) < f.KeepaliveInterval/2 {
+		return nil
+	}
+	n, err := f.ReadWriter.Write([]byte{SyncACK, byte(f.seq)})
+	f.stats.BytesSent += uint64(n)
+	f.stats.Heartbeats++
+	f.lastSent = now
+	return err
+}
+
 func (f *FIFO) readLoop(ctx context.Context, byteCh chan byte, errCh chan error) {
 	buf := make([]byte, 1)
 	for {
@@ -159,19 +272,32 @@ func (f *FIFO) applyParseResult(ctx context.Context, pr ParseResult) (err error)
 	var notifier StateNotifier
 	f.lock.Lock()
 	if f.state != pr.State {
+		if f.state.IsReady() && !pr.State.IsReady() {
+			f.stats.SyncLost++
+		}
 		f.state = pr.State
 		notifier = f.Notifier
 	}
 	if pr.Sync != 0 {
-		_, err = f.ReadWriter.Write([]byte{pr.Sync, byte(f.seq)})
+		var n int
+		n, err = f.ReadWriter.Write([]byte{pr.Sync, byte(f.seq)})
+		f.stats.BytesSent += uint64(n)
+		f.lastSent = time.Now()
+		switch pr.Sync {
+		case SyncREQ:
+			f.stats.SyncRequests++
+		case SyncACK:
+			f.stats.SyncAcks++
+		}
 	}
+	f.stats.Parser = f.parser.Stats()
 	f.lock.Unlock()
 	if err != nil {
 		return
 	}
 
 	if f.ReadTimeout {
-		if pr.Sync == syncREQ {
+		if pr.Sync == SyncREQ {
 			f.syncTimer = time.After(f.Timeout)
 		} else {
 			f.syncTimer = nil
@@ -185,12 +311,22 @@ func (f *FIFO) applyParseResult(ctx context.Context, pr ParseResult) (err error)
 		}
 	}
 
+	if !pr.State.IsReady() {
+		f.reassembler.reset()
+	}
 	if notifier != nil {
 		notifier.StateChanged(ctx, pr.State)
 	}
-	if pr.Packet != nil {
+	pkt := pr.Packet
+	if pkt != nil && f.Fragment {
+		pkt = f.reassembler.add(pkt)
+	}
+	if pkt != nil && f.bridge != nil && f.bridge.interceptPacket(ctx, pkt) {
+		pkt = nil
+	}
+	if pkt != nil {
 		if h := f.Handler; h != nil {
-			h.HandlePacket(ctx, pr.Packet)
+			h.HandlePacket(ctx, pkt)
 		}
 	}
 	return
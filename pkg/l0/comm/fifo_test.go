@@ -139,6 +139,7 @@ type fifoTestSequence struct {
 
 type fifoTestCase struct {
 	name      string
+	crc       bool
 	sequences []fifoTestSequence
 }
 
@@ -151,6 +152,7 @@ func (tc *fifoTestCase) run(t *testing.T) {
 	}
 	tctx.fifo = NewFIFO(tctx.stream)
 	tctx.fifo.seq = PacketSeq(1)
+	tctx.fifo.CRC = tc.crc
 	tctx.fifo.Handler = HandlePacketFunc(func(ctx context.Context, pkt *Packet) {
 		tctx.packetCh <- pkt
 	})
@@ -201,10 +203,10 @@ func TestSync(t *testing.T) {
 			name: "sync and receive",
 			sequences: []fifoTestSequence{
 				{
-					expect: []byte{syncREQ, 0x01},
+					expect: []byte{SyncREQ, 0x01},
 				},
 				{
-					inject: []byte{syncACK, 0x01},
+					inject: []byte{SyncACK, 0x01},
 					action: func(n int, tctx *fifoTestCtx) {
 						tctx.expectStateChanges(SyncStateReceiving, SyncStateReady)
 					},
@@ -228,10 +230,10 @@ func TestSync(t *testing.T) {
 			name: "sync and send",
 			sequences: []fifoTestSequence{
 				{
-					expect: []byte{syncREQ, 0x01},
+					expect: []byte{SyncREQ, 0x01},
 				},
 				{
-					inject: []byte{syncACK, 0x01},
+					inject: []byte{SyncACK, 0x01},
 					action: func(n int, tctx *fifoTestCtx) {
 						tctx.expectStateChanges(SyncStateReceiving, SyncStateReady).
 							mustSend(0x02, nil).
@@ -248,9 +250,99 @@ func TestSync(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "sync and receive with crc",
+			crc:  true,
+			sequences: []fifoTestSequence{
+				{
+					expect: []byte{SyncREQ, 0x01},
+				},
+				{
+					inject: []byte{SyncACK, 0x01},
+					action: func(n int, tctx *fifoTestCtx) {
+						tctx.expectStateChanges(SyncStateReceiving, SyncStateReady)
+					},
+				},
+				{
+					inject: []byte{
+						0x01, 0x92, 0x03, CRC8(0, 0x01, 0x92, 0x03),
+						0x02, 0x92, 0x04, CRC8(0, 0x02, 0x92, 0x03),
+					},
+					expect: []byte{SyncREQ, 0x01},
+					action: func(n int, tctx *fifoTestCtx) {
+						tctx.fromPacketSeq(PacketSeq(0x01)).
+							expectPacket(0x82, []byte{0x03})
+					},
+				},
+			},
+		},
+		{
+			name: "sync and send with crc",
+			crc:  true,
+			sequences: []fifoTestSequence{
+				{
+					expect: []byte{SyncREQ, 0x01},
+				},
+				{
+					inject: []byte{SyncACK, 0x01},
+					action: func(n int, tctx *fifoTestCtx) {
+						tctx.expectStateChanges(SyncStateReceiving, SyncStateReady).
+							mustSend(0x02, nil).
+							mustSend(0x82, []byte{0x03})
+					},
+				},
+				{
+					expect: []byte{
+						0x01, 0x02, CRC8(0, 0x01, 0x02),
+						0x02, 0x92, 0x03, CRC8(0, 0x02, 0x92, 0x03),
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, tc.run)
 	}
 }
+
+func TestKeepalive(t *testing.T) {
+	fifo1, fifo2 := newLinkedFIFOs()
+	for _, fifo := range []*FIFO{fifo1, fifo2} {
+		fifo.KeepaliveInterval = 20 * time.Millisecond
+	}
+	client := NewClient(fifo1)
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx2, cancel2 := context.WithCancel(ctx)
+	go client.Run(ctx)
+	go fifo2.Run(ctx2)
+	waitReady(t, fifo1, fifo2)
+
+	time.Sleep(150 * time.Millisecond)
+	stats1, stats2 := fifo1.Stats(), fifo2.Stats()
+	require.True(t, stats1.State.IsReady())
+	require.True(t, stats2.State.IsReady())
+	require.NotZero(t, stats1.Heartbeats)
+	require.NotZero(t, stats2.Heartbeats)
+	require.Zero(t, stats1.PeerDead)
+	require.Zero(t, stats1.SyncLost)
+	require.Zero(t, stats2.SyncLost)
+
+	cmd := client.Do(&Packet{Code: 2})
+	cancel2()
+	select {
+	case r := <-cmd.ResultChan():
+		require.Equal(t, ErrSyncLost, r.Err)
+	case <-time.After(time.Second):
+		t.Fatal("dead peer not detected")
+	}
+	stats1 = fifo1.Stats()
+	require.False(t, stats1.State.IsReady())
+	require.Equal(t, uint64(1), stats1.PeerDead)
+}
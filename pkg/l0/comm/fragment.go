@@ -0,0 +1,88 @@
+package comm
+
+// Fragmentation splits a packet with data exceeding MaxDataLen into
+// consecutive packets. All but the last packet are fragments using
+// FragmentCode, with Data[0] as the fragment index starting from 0,
+// followed by a chunk of the payload. The last packet uses the original
+// code and carries the remaining payload without a header.
+// The receiver concatenates the chunks and the last packet, and delivers
+// it as a single packet with the seq of the first fragment.
+// Fragments must be received with consecutive seq and indices, otherwise
+// the whole payload is dropped.
+
+const (
+	// FragmentCode is the packet code reserved for fragments when
+	// fragmentation is enabled.
+	FragmentCode byte = 0x8f
+	// MaxFragments is the max number of fragments of a packet.
+	MaxFragments = 0x100
+	// MaxFragmentedDataLen is the max length of data with fragmentation.
+	MaxFragmentedDataLen = MaxFragments*fragmentChunkLen + MaxDataLen
+
+	fragmentChunkLen = MaxDataLen - 1
+)
+
+// Fragment splits a packet into fragments.
+// It returns the packet itself if it's small enough.
+func Fragment(pkt *Packet) ([]*Packet, error) {
+	if len(pkt.Data) <= MaxDataLen {
+		return []*Packet{pkt}, nil
+	}
+	if len(pkt.Data) > MaxFragmentedDataLen {
+		return nil, ErrPacketTooLarge
+	}
+	var pkts []*Packet
+	data := pkt.Data
+	for index := 0; len(data) > MaxDataLen; index++ {
+		frag := &Packet{Code: FragmentCode, Data: make([]byte, fragmentChunkLen+1)}
+		frag.Data[0] = byte(index)
+		data = data[copy(frag.Data[1:], data):]
+		pkts = append(pkts, frag)
+	}
+	return append(pkts, &Packet{Code: pkt.Code, Data: data}), nil
+}
+
+// reassembler reassembles fragments into packets.
+type reassembler struct {
+	seq     PacketSeq // seq of the first fragment.
+	lastSeq PacketSeq
+	index   int
+	data    []byte
+	broken  bool
+}
+
+func (r *reassembler) reset() {
+	r.seq, r.lastSeq, r.index, r.data, r.broken = 0, 0, 0, nil, false
+}
+
+// add accepts a received packet and returns the packet to deliver, or
+// nil if the packet is a fragment or the payload is dropped.
+func (r *reassembler) add(pkt *Packet) *Packet {
+	inProgress := r.index > 0 || r.broken
+	if inProgress && pkt.Seq != r.lastSeq.Next() {
+		r.broken = true
+	}
+	r.lastSeq = pkt.Seq
+	if pkt.Code == FragmentCode {
+		if len(pkt.Data) == 0 || int(pkt.Data[0]) != r.index || r.index >= MaxFragments {
+			r.broken = true
+		}
+		if !r.broken {
+			if r.index == 0 {
+				r.seq = pkt.Seq
+			}
+			r.data = append(r.data, pkt.Data[1:]...)
+		}
+		r.index++
+		return nil
+	}
+	if !inProgress {
+		return pkt
+	}
+	broken, seq, data := r.broken, r.seq, append(r.data, pkt.Data...)
+	r.reset()
+	if broken {
+		return nil
+	}
+	return &Packet{Seq: seq, Code: pkt.Code, Data: data}
+}
@@ -0,0 +1,138 @@
+package comm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testPayload(size int) []byte {
+	data := make([]byte, size)
+	for n := range data {
+		data[n] = byte(n)
+	}
+	return data
+}
+
+func TestFragment(t *testing.T) {
+	pkt := &Packet{Code: 2, Data: testPayload(MaxDataLen)}
+	pkts, err := Fragment(pkt)
+	require.NoError(t, err)
+	require.Equal(t, []*Packet{pkt}, pkts)
+
+	data := testPayload(fragmentChunkLen*2 + 10)
+	pkts, err = Fragment(&Packet{Code: 2, Data: data})
+	require.NoError(t, err)
+	require.Len(t, pkts, 3)
+	require.Equal(t, FragmentCode, pkts[0].Code)
+	require.Equal(t, append([]byte{0}, data[:fragmentChunkLen]...), pkts[0].Data)
+	require.Equal(t, FragmentCode, pkts[1].Code)
+	require.Equal(t, append([]byte{1}, data[fragmentChunkLen:fragmentChunkLen*2]...), pkts[1].Data)
+	require.Equal(t, byte(2), pkts[2].Code)
+	require.Equal(t, data[fragmentChunkLen*2:], pkts[2].Data)
+
+	// remaining data fits in the last packet.
+	pkts, err = Fragment(&Packet{Code: 2, Data: testPayload(fragmentChunkLen + MaxDataLen)})
+	require.NoError(t, err)
+	require.Len(t, pkts, 2)
+	require.Len(t, pkts[1].Data, MaxDataLen)
+
+	_, err = Fragment(&Packet{Code: 2, Data: testPayload(MaxFragmentedDataLen)})
+	require.NoError(t, err)
+	_, err = Fragment(&Packet{Code: 2, Data: testPayload(MaxFragmentedDataLen + 1)})
+	require.Equal(t, ErrPacketTooLarge, err)
+}
+
+func TestReassembler(t *testing.T) {
+	data := testPayload(fragmentChunkLen*2 + 10)
+	fragments := func(seq PacketSeq) []*Packet {
+		pkts, err := Fragment(&Packet{Code: 0x84, Data: data})
+		require.NoError(t, err)
+		for _, pkt := range pkts {
+			pkt.Seq, seq = seq, seq.Next()
+		}
+		return pkts
+	}
+
+	t.Run("reassemble", func(t *testing.T) {
+		var r reassembler
+		pkts := fragments(PacketSeq(0xee))
+		require.Nil(t, r.add(pkts[0]))
+		require.Nil(t, r.add(pkts[1]))
+		require.Equal(t, &Packet{Seq: PacketSeq(0xee), Code: 0x84, Data: data}, r.add(pkts[2]))
+		pkt := &Packet{Seq: PacketSeq(2), Code: 2, Data: []byte{1}}
+		require.Equal(t, pkt, r.add(pkt))
+	})
+
+	t.Run("seq gap", func(t *testing.T) {
+		var r reassembler
+		pkts := fragments(PacketSeq(1))
+		pkts[2].Seq = PacketSeq(5)
+		require.Nil(t, r.add(pkts[0]))
+		require.Nil(t, r.add(pkts[1]))
+		require.Nil(t, r.add(pkts[2]))
+		pkts = fragments(PacketSeq(6))
+		require.Nil(t, r.add(pkts[0]))
+		require.Nil(t, r.add(pkts[1]))
+		require.Equal(t, data, r.add(pkts[2]).Data)
+	})
+
+	t.Run("index mismatch", func(t *testing.T) {
+		var r reassembler
+		pkts := fragments(PacketSeq(1))
+		require.Nil(t, r.add(pkts[1]))
+		require.Nil(t, r.add(pkts[2]))
+		pkts = fragments(PacketSeq(4))
+		require.Nil(t, r.add(pkts[0]))
+		require.Nil(t, r.add(pkts[0]))
+		require.Nil(t, r.add(pkts[2]))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		var r reassembler
+		pkts := fragments(PacketSeq(1))
+		require.Nil(t, r.add(pkts[0]))
+		r.reset()
+		pkt := &Packet{Seq: PacketSeq(9), Code: 2}
+		require.Equal(t, pkt, r.add(pkt))
+	})
+}
+
+func TestFragmentedCommand(t *testing.T) {
+	clientFIFO, deviceFIFO := newLinkedFIFOs()
+	clientFIFO.Fragment, deviceFIFO.Fragment = true, true
+	client, device := NewClient(clientFIFO), NewDevice(deviceFIFO)
+	device.HandleFunc(2, func(ctx context.Context, pkt *Packet) Result {
+		return Result{Code: 2, Data: pkt.Data}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+	go client.Run(ctx)
+	go device.Run(ctx)
+	waitReady(t, clientFIFO, deviceFIFO)
+
+	data := testPayload(1000)
+	r := client.DoContext(ctx, &Packet{Code: 2, Data: data}, &CommandOptions{Timeout: time.Second})
+	require.NoError(t, r.Err)
+	require.Equal(t, data, r.Data)
+
+	data = testPayload(300)
+	require.NoError(t, device.Emit(3, data))
+	select {
+	case pkt := <-client.EventChan():
+		require.Equal(t, byte(0x83), pkt.Code)
+		require.Equal(t, data, pkt.Data)
+	case <-time.After(time.Second):
+		t.Fatal("event timeout")
+	}
+
+	r = <-client.Do(&Packet{Code: 2, Data: testPayload(MaxFragmentedDataLen + 1)}).ResultChan()
+	require.Equal(t, ErrPacketTooLarge, r.Err)
+}
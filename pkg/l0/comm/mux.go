@@ -0,0 +1,240 @@
+package comm
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Channel multiplexing runs multiple logical channels over one FIFO.
+// The FIFO keeps a single seq space and sync state, and each channel
+// packet carries a header in Data: the channel ID, followed by the
+// channel seq. The channel seq is the channel's own seq for commands
+// and events, and the seq of the request for replies, so the rest of
+// a reply is the same as without multiplexing.
+//
+// Both ends must agree on using multiplexing, and all packets over the
+// FIFO must be channel packets.
+
+// MuxHeaderLen is the length of channel header in Data.
+const MuxHeaderLen = 2
+
+// Mux multiplexes logical channels over a FIFO.
+// Each channel is a Client with its own seq space and a priority.
+// Packets of channels are queued and written by Mux.Run, packets of the
+// channel with higher priority are written first, and channels of the
+// same priority are served in order. The latency of a command includes
+// the time its packet is queued.
+type Mux struct {
+	fifo      *FIFO
+	queue     sendQueue
+	channels  map[byte]*Client
+	lock      sync.RWMutex
+	unhandled uint64
+}
+
+type muxChannel struct {
+	id       byte
+	priority int
+	seq      PacketSeq
+	mux      *Mux
+}
+
+// NewMux creates a Mux and wraps the fifo.
+func NewMux(fifo *FIFO) *Mux {
+	m := &Mux{fifo: fifo, channels: make(map[byte]*Client)}
+	fifo.Handler = m
+	fifo.Notifier = m
+	return m
+}
+
+// FIFO gets wrapped FIFO.
+func (m *Mux) FIFO() *FIFO {
+	return m.fifo
+}
+
+// Channel creates the client of a channel. A larger priority is
+// served first. Channels should be created before Run.
+func (m *Mux) Channel(id byte, priority int) (*Client, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.channels[id]; ok {
+		return nil, ErrChannelExists
+	}
+	c := newClient(m.fifo)
+	c.channel = &muxChannel{id: id, priority: priority, seq: NewPacketSeq(), mux: m}
+	m.channels[id] = c
+	return c, nil
+}
+
+// Unhandled returns the number of packets dropped for missing or
+// unknown channel.
+func (m *Mux) Unhandled() uint64 {
+	return atomic.LoadUint64(&m.unhandled)
+}
+
+// HandlePacket implements PacketHandler.
+func (m *Mux) HandlePacket(ctx context.Context, pkt *Packet) {
+	var c *Client
+	if len(pkt.Data) >= MuxHeaderLen {
+		m.lock.RLock()
+		c = m.channels[pkt.Data[0]]
+		m.lock.RUnlock()
+	}
+	if c == nil {
+		atomic.AddUint64(&m.unhandled, 1)
+		return
+	}
+	chPkt := &Packet{Seq: PacketSeq(pkt.Data[1]), Code: pkt.Code}
+	if pkt.Code&0x80 != 0 {
+		chPkt.Data = pkt.Data[MuxHeaderLen:]
+	} else {
+		// keep the seq of request for Client.
+		chPkt.Data = pkt.Data[1:]
+	}
+	c.HandlePacket(ctx, chPkt)
+}
+
+// StateChanged implements StateNotifier.
+func (m *Mux) StateChanged(ctx context.Context, state SyncState) {
+	m.lock.RLock()
+	clients := make([]*Client, 0, len(m.channels))
+	for _, c := range m.channels {
+		clients = append(clients, c)
+	}
+	m.lock.RUnlock()
+	for _, c := range clients {
+		c.stateChanged(ctx, state)
+	}
+}
+
+// Run wraps FIFO.Run to implement Runnable, and writes packets queued
+// by channels. Channels fail to send with ErrNotReady when Mux is not
+// running.
+func (m *Mux) Run(ctx context.Context) error {
+	writerCtx, cancel := context.WithCancel(ctx)
+	m.queue.start()
+	go m.writeLoop(writerCtx)
+	err := m.fifo.Run(ctx)
+	cancel()
+	m.queue.stop()
+	return err
+}
+
+// send queues the packet with the next channel seq, and returns the chan
+// of the result of writing it to the link. It's called with
+// Client.cmdsLock held, so the channel seq is consistent with the order
+// of pending commands.
+func (ch *muxChannel) send(pkt *Packet) (<-chan error, error) {
+	data := make([]byte, MuxHeaderLen, MuxHeaderLen+len(pkt.Data))
+	data[0], data[1] = ch.id, byte(ch.seq)
+	req := &sendRequest{
+		pkt:   &Packet{Code: pkt.Code, Data: append(data, pkt.Data...)},
+		errCh: make(chan error, 1),
+	}
+	if err := ch.mux.queue.push(ch.priority, req); err != nil {
+		return nil, err
+	}
+	pkt.Seq, ch.seq = ch.seq, ch.seq.Next()
+	return req.errCh, nil
+}
+
+// writeLoop writes queued packets to the FIFO until ctx is done.
+func (m *Mux) writeLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.queue.readyCh:
+		}
+		for req := m.queue.pop(); req != nil; req = m.queue.pop() {
+			req.errCh <- m.fifo.Send(req.pkt)
+		}
+	}
+}
+
+// sendRequest is a packet waiting in sendQueue.
+type sendRequest struct {
+	pkt   *Packet
+	errCh chan error
+	next  *sendRequest
+}
+
+// priorityQueue holds requests of the same priority in order.
+type priorityQueue struct {
+	priority   int
+	head, tail *sendRequest
+}
+
+// sendQueue holds packets of all channels waiting for the writer,
+// which takes them by priority, then in order.
+type sendQueue struct {
+	queues  []*priorityQueue // sorted by priority, highest first
+	readyCh chan struct{}
+	running bool
+	lock    sync.Mutex
+}
+
+// start accepts requests for the writer.
+func (q *sendQueue) start() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.running = true
+	if q.readyCh == nil {
+		q.readyCh = make(chan struct{}, 1)
+	}
+}
+
+// stop rejects new requests and fails queued ones with ErrNotReady.
+func (q *sendQueue) stop() {
+	q.lock.Lock()
+	q.running = false
+	q.lock.Unlock()
+	for req := q.pop(); req != nil; req = q.pop() {
+		req.errCh <- ErrNotReady
+	}
+}
+
+func (q *sendQueue) push(priority int, req *sendRequest) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if !q.running {
+		return ErrNotReady
+	}
+	pos := 0
+	for pos < len(q.queues) && q.queues[pos].priority > priority {
+		pos++
+	}
+	if pos == len(q.queues) || q.queues[pos].priority != priority {
+		q.queues = append(q.queues, nil)
+		copy(q.queues[pos+1:], q.queues[pos:])
+		q.queues[pos] = &priorityQueue{priority: priority}
+	}
+	pq := q.queues[pos]
+	if pq.tail == nil {
+		pq.head = req
+	} else {
+		pq.tail.next = req
+	}
+	pq.tail = req
+	select {
+	case q.readyCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *sendQueue) pop() *sendRequest {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, pq := range q.queues {
+		if req := pq.head; req != nil {
+			if pq.head = req.next; pq.head == nil {
+				pq.tail = nil
+			}
+			req.next = nil
+			return req
+		}
+	}
+	return nil
+}
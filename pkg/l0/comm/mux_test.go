@@ -0,0 +1,120 @@
+package comm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendQueue(t *testing.T) {
+	var q sendQueue
+	require.Equal(t, ErrNotReady, q.push(0, &sendRequest{}))
+	q.start()
+	for n, priority := range []int{0, 5, 3, 5} {
+		require.NoError(t, q.push(priority, &sendRequest{pkt: &Packet{Code: byte(n)}}))
+	}
+	var order []byte
+	for req := q.pop(); req != nil; req = q.pop() {
+		order = append(order, req.pkt.Code)
+	}
+	require.Equal(t, []byte{1, 3, 2, 0}, order)
+
+	req := &sendRequest{errCh: make(chan error, 1)}
+	require.NoError(t, q.push(0, req))
+	q.stop()
+	require.Equal(t, ErrNotReady, <-req.errCh)
+	require.Nil(t, q.pop())
+}
+
+func TestMuxQueueReleasesLock(t *testing.T) {
+	mux := NewMux(NewFIFO(nil))
+	c, err := mux.Channel(1, 0)
+	require.NoError(t, err)
+	mux.queue.start()
+
+	cmdCh := make(chan *Command, 1)
+	go func() {
+		cmdCh <- c.Do(&Packet{Code: 2})
+	}()
+	deadline := time.Now().Add(time.Second)
+	var req *sendRequest
+	for req == nil {
+		require.True(t, time.Now().Before(deadline), "queue timeout")
+		time.Sleep(time.Millisecond)
+		req = mux.queue.pop()
+	}
+	// the command is pending without holding cmdsLock.
+	c.cmdsLock.Lock()
+	pending := c.cmdsHead
+	c.cmdsLock.Unlock()
+	require.NotNil(t, pending)
+	require.Equal(t, []byte{1, byte(pending.RequestSeq())}, req.pkt.Data)
+
+	req.errCh <- ErrNotReady
+	cmd := <-cmdCh
+	require.True(t, cmd == pending)
+	r := <-cmd.ResultChan()
+	require.Equal(t, ErrNotReady, r.Err)
+	require.Equal(t, uint64(1), c.Stats().SendErrors)
+}
+
+func TestMux(t *testing.T) {
+	muxFIFO, peerFIFO := newLinkedFIFOs()
+	mux := NewMux(muxFIFO)
+	motor, err := mux.Channel(1, 1)
+	require.NoError(t, err)
+	sensor, err := mux.Channel(2, 0)
+	require.NoError(t, err)
+	_, err = mux.Channel(1, 0)
+	require.Equal(t, ErrChannelExists, err)
+
+	// the peer replies with the channel ID and the command data.
+	peerFIFO.Handler = HandlePacketFunc(func(ctx context.Context, pkt *Packet) {
+		data := append([]byte{pkt.Data[0], pkt.Data[1], pkt.Data[0]}, pkt.Data[MuxHeaderLen:]...)
+		peerFIFO.Send(&Packet{Code: pkt.Code & 0x7e, Data: data})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, c := range []*Client{motor, sensor} {
+		go func(c *Client) {
+			for range c.StateChan() {
+			}
+		}(c)
+	}
+	go mux.Run(ctx)
+	go peerFIFO.Run(ctx)
+	waitReady(t, muxFIFO, peerFIFO)
+
+	opts := &CommandOptions{Timeout: time.Second}
+	for i := 0; i < 3; i++ {
+		r := motor.DoContext(ctx, &Packet{Code: 2, Data: []byte{byte(i)}}, opts)
+		require.NoError(t, r.Err)
+		require.Equal(t, byte(2), r.Code)
+		require.Equal(t, []byte{1, byte(i)}, r.Data)
+		r = sensor.DoContext(ctx, &Packet{Code: 4}, opts)
+		require.NoError(t, r.Err)
+		require.Equal(t, byte(4), r.Code)
+		require.Equal(t, []byte{2}, r.Data)
+	}
+
+	require.NoError(t, peerFIFO.Send(&Packet{Code: 0x83, Data: []byte{2, 1, 9}}))
+	select {
+	case pkt := <-sensor.EventChan():
+		require.Equal(t, byte(0x83), pkt.Code)
+		require.Equal(t, []byte{9}, pkt.Data)
+	case <-time.After(time.Second):
+		t.Fatal("event timeout")
+	}
+
+	require.NoError(t, peerFIFO.Send(&Packet{Code: 0x83, Data: []byte{3, 1, 9}}))
+	require.NoError(t, peerFIFO.Send(&Packet{Code: 0x83}))
+	deadline := time.Now().Add(time.Second)
+	for mux.Unhandled() < 2 {
+		require.True(t, time.Now().Before(deadline), "unhandled timeout")
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, uint64(3), motor.Stats().Replies)
+}
@@ -28,6 +28,13 @@ func (s PacketSeq) IsValid() bool {
 	return n > 0 && n < 0xf0
 }
 
+// MaxDataLen is the max length of Packet.Data which can be encoded.
+const MaxDataLen = 0x7f
+
+// MaxPacketLen is the max length of an encoded packet, including
+// seq, code, length, data and CRC-8.
+const MaxPacketLen = MaxDataLen + 4
+
 // Packet contains the information of a parsed packet.
 type Packet struct {
 	Seq  PacketSeq
@@ -36,7 +43,11 @@ type Packet struct {
 }
 
 // Bytes returns encoded bytes for sending.
-func (p *Packet) Bytes() []byte {
+// It fails with ErrPacketTooLarge if Data exceeds MaxDataLen.
+func (p *Packet) Bytes() ([]byte, error) {
+	if len(p.Data) > MaxDataLen {
+		return nil, ErrPacketTooLarge
+	}
 	b := make([]byte, len(p.Data)+3)
 	b[0], b[1] = byte(p.Seq), (p.Code & 0x8f)
 	if l := byte(len(p.Data)); l >= 7 {
@@ -48,11 +59,27 @@ func (p *Packet) Bytes() []byte {
 		b[1] |= (l << 4) & 0x70
 		copy(b[2:], p.Data)
 	}
-	return b
+	return b, nil
+}
+
+// BytesWithCRC returns encoded bytes with CRC-8 appended.
+func (p *Packet) BytesWithCRC() ([]byte, error) {
+	b, err := p.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, CRC8(0, b...)), nil
 }
 
-// WriteTo writes encoded bytes.
-func (p *Packet) WriteTo(w io.Writer) (n int, err error) {
+var _ io.WriterTo = (*Packet)(nil)
+
+// WriteTo implements io.WriterTo, and writes encoded bytes.
+// It fails with ErrPacketTooLarge without writing anything if Data
+// exceeds MaxDataLen.
+func (p *Packet) WriteTo(w io.Writer) (n int64, err error) {
+	if len(p.Data) > MaxDataLen {
+		return 0, ErrPacketTooLarge
+	}
 	head := []byte{byte(p.Seq), p.Code & 0x8f, byte(len(p.Data))}
 	if head[2] < 7 {
 		head[1] |= (head[2] << 4) & 0x70
@@ -60,13 +87,14 @@ func (p *Packet) WriteTo(w io.Writer) (n int, err error) {
 	} else {
 		head[1] |= 0x70
 	}
-	if n, err = w.Write(head); err != nil {
+	var written int
+	written, err = w.Write(head)
+	if n = int64(written); err != nil {
 		return
 	}
-	if l := byte(len(p.Data)); l > 0 {
-		var n1 int
-		n1, err = w.Write(p.Data[:l])
-		n += n1
+	if len(p.Data) > 0 {
+		written, err = w.Write(p.Data)
+		n += int64(written)
 	}
 	return
 }
@@ -40,12 +40,47 @@ func TestPacket(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			require.Equal(t, tc.expect, tc.packet.Bytes())
+			encoded, err := tc.packet.Bytes()
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, encoded)
 			var buf bytes.Buffer
 			n, err := tc.packet.WriteTo(&buf)
 			require.NoError(t, err)
 			require.Equal(t, tc.expect, buf.Bytes())
-			require.Equal(t, len(tc.expect), n)
+			require.Equal(t, int64(len(tc.expect)), n)
 		})
 	}
 }
+
+func TestPacketTooLarge(t *testing.T) {
+	pkt := Packet{Seq: PacketSeq(1), Code: 2, Data: make([]byte, MaxDataLen)}
+	encoded, err := pkt.Bytes()
+	require.NoError(t, err)
+	require.Len(t, encoded, MaxDataLen+3)
+
+	pkt.Data = make([]byte, MaxDataLen+1)
+	_, err = pkt.Bytes()
+	require.Equal(t, ErrPacketTooLarge, err)
+	_, err = pkt.BytesWithCRC()
+	require.Equal(t, ErrPacketTooLarge, err)
+	var buf bytes.Buffer
+	n, err := pkt.WriteTo(&buf)
+	require.Equal(t, ErrPacketTooLarge, err)
+	require.Zero(t, n)
+	require.Zero(t, buf.Len())
+}
+
+func TestCRC8(t *testing.T) {
+	require.Equal(t, byte(0), CRC8(0))
+	require.Equal(t, byte(0xf4), CRC8(0, []byte("123456789")...))
+	require.Equal(t, CRC8(0, 1, 2, 3), CRC8(CRC8(0, 1), 2, 3))
+}
+
+func TestPacketWithCRC(t *testing.T) {
+	pkt := Packet{Seq: PacketSeq(1), Code: 0x82, Data: []byte{1}}
+	encoded, err := pkt.Bytes()
+	require.NoError(t, err)
+	withCRC, err := pkt.BytesWithCRC()
+	require.NoError(t, err)
+	require.Equal(t, append(encoded, CRC8(0, encoded...)), withCRC)
+}
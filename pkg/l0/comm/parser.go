@@ -2,10 +2,38 @@ package comm
 
 // Parser parses bytes received.
 type Parser struct {
+	// CRC enables CRC-8 verification. When enabled, every packet is
+	// expected to be followed by a CRC-8 byte calculated over all the
+	// encoded bytes of the packet. A packet failing the verification
+	// is dropped and resync is triggered.
+	CRC bool
+
 	peerSeq PacketSeq
 	state   parseState
 	packet  *Packet
 	recvLen byte
+	crc     byte
+	stats   ParserStats
+}
+
+// ParserStats provides the statistics of a Parser.
+type ParserStats struct {
+	// Bytes is the number of bytes parsed.
+	Bytes uint64
+	// Packets is the number of packets parsed.
+	Packets uint64
+	// Resyncs is the number of resyncs triggered by errors or timeouts.
+	Resyncs uint64
+	// SyncErrors is the number of invalid seq received when syncing.
+	SyncErrors uint64
+	// SeqErrors is the number of packet or ACK sequence mismatches.
+	SeqErrors uint64
+	// LengthErrors is the number of invalid data length received.
+	LengthErrors uint64
+	// CRCErrors is the number of packets failing CRC-8 verification.
+	CRCErrors uint64
+	// Timeouts is the number of resyncs triggered by timeout.
+	Timeouts uint64
 }
 
 // SyncState indicates the state of communication.
@@ -51,7 +79,7 @@ type ParseResult struct {
 
 // WhatAboutTimer decides what to do with timer.
 func (r ParseResult) WhatAboutTimer() TimerAction {
-	if r.State.IsReceiving() || r.Sync == syncREQ {
+	if r.State.IsReceiving() || r.Sync == SyncREQ {
 		return TimerRestart
 	}
 	if r.State.IsReady() {
@@ -63,19 +91,21 @@ func (r ParseResult) WhatAboutTimer() TimerAction {
 type parseState int
 
 const (
-	stateSyncAck    parseState = iota // sync req sent, waiting for syncACK
-	stateSyncReqSeq                   // waiting for sync seq after syncREQ
-	stateSyncAckSeq                   // waiting for sync seq after syncACK
+	stateSyncAck    parseState = iota // sync req sent, waiting for SyncACK
+	stateSyncReqSeq                   // waiting for sync seq after SyncREQ
+	stateSyncAckSeq                   // waiting for sync seq after SyncACK
 	stateMsgSeq                       // waiting for message seq
 	stateMsgAckSeq                    // recv ack in MsgSeq, validate seq
 	stateMsgCode                      // waiting for message code
 	stateMsgLen                       // waiting for message length
 	stateMsgData                      // waiting for message data
+	stateMsgCRC                       // waiting for CRC-8 of message
 )
 
+// Sync commands on the wire, also used in ParseResult.Sync.
 const (
-	syncREQ byte = 0xff
-	syncACK byte = 0xfe
+	SyncREQ byte = 0xff
+	SyncACK byte = 0xfe
 )
 
 // State gets the current sync state.
@@ -100,16 +130,27 @@ func (p *Parser) Reset() (pr ParseResult) {
 	return
 }
 
+// Stats gets the statistics.
+func (p *Parser) Stats() ParserStats {
+	return p.stats
+}
+
 // Parse consumes one byte.
 func (p *Parser) Parse(b byte) (pr ParseResult) {
+	p.stats.Bytes++
 	pr.Sync, pr.Packet = p.parseByte(b)
 	pr.State = p.State()
+	if pr.Packet != nil {
+		p.stats.Packets++
+	}
 	return
 }
 
 // Timeout notifies the parser timer expires.
 func (p *Parser) Timeout() (pr ParseResult) {
 	if p.state != stateMsgSeq {
+		p.stats.Timeouts++
+		p.stats.Resyncs++
 		pr.Sync, pr.Packet = p.resync()
 	}
 	pr.State = p.State()
@@ -120,45 +161,47 @@ func (p *Parser) parseByte(b byte) (syncCmd byte, pkt *Packet) {
 	switch p.state {
 	case stateSyncAck:
 		switch b {
-		case syncREQ:
+		case SyncREQ:
 			p.state = stateSyncReqSeq
-		case syncACK:
+		case SyncACK:
 			p.state = stateSyncAckSeq
 		}
 	case stateSyncReqSeq:
 		if seq := PacketSeq(b); seq.IsValid() {
 			p.peerSeq, p.state = seq, stateMsgSeq
-			syncCmd = syncACK
+			syncCmd = SyncACK
 			return
 		}
-		return p.resync()
+		return p.syncError()
 	case stateSyncAckSeq:
 		if seq := PacketSeq(b); seq.IsValid() {
 			p.peerSeq, p.state = seq, stateMsgSeq
 			return
 		}
-		return p.resync()
+		return p.syncError()
 	case stateMsgSeq:
-		if b == syncREQ {
+		if b == SyncREQ {
 			p.state = stateSyncReqSeq
 			return
 		}
-		if b == syncACK {
+		if b == SyncACK {
 			p.state = stateMsgAckSeq
 			return
 		}
 		if b != byte(p.peerSeq) {
-			return p.resync()
+			return p.seqError()
 		}
 		p.packet = &Packet{Seq: p.peerSeq}
 		p.peerSeq = p.peerSeq.Next()
+		p.crc = CRC8(0, b)
 		p.state = stateMsgCode
 	case stateMsgAckSeq:
 		if b != byte(p.peerSeq) {
-			return p.resync()
+			return p.seqError()
 		}
 		p.state = stateMsgSeq
 	case stateMsgCode:
+		p.crc = CRC8(p.crc, b)
 		p.packet.Code = b & 0x8f
 		switch dataLen := (b >> 4) & 7; dataLen {
 		case 0:
@@ -171,29 +214,70 @@ func (p *Parser) parseByte(b byte) (syncCmd byte, pkt *Packet) {
 		}
 	case stateMsgLen:
 		if b >= 0x80 {
-			return p.resync()
+			return p.lengthError()
 		}
+		p.crc = CRC8(p.crc, b)
 		if b == 0 {
 			return p.packetReady()
 		}
 		p.packet.Data, p.recvLen = make([]byte, b), 0
 		p.state = stateMsgData
 	case stateMsgData:
+		p.crc = CRC8(p.crc, b)
 		p.packet.Data[p.recvLen] = b
 		p.recvLen++
 		if p.recvLen >= byte(len(p.packet.Data)) {
 			return p.packetReady()
 		}
+	case stateMsgCRC:
+		if b != p.crc {
+			p.packet = nil
+			return p.crcError()
+		}
+		return p.packetDone()
 	}
 	return
 }
 
+func (p *Parser) syncError() (byte, *Packet) {
+	p.stats.SyncErrors++
+	return p.errorResync()
+}
+
+func (p *Parser) seqError() (byte, *Packet) {
+	p.stats.SeqErrors++
+	return p.errorResync()
+}
+
+func (p *Parser) lengthError() (byte, *Packet) {
+	p.stats.LengthErrors++
+	return p.errorResync()
+}
+
+func (p *Parser) crcError() (byte, *Packet) {
+	p.stats.CRCErrors++
+	return p.errorResync()
+}
+
+func (p *Parser) errorResync() (byte, *Packet) {
+	p.stats.Resyncs++
+	return p.resync()
+}
+
 func (p *Parser) resync() (byte, *Packet) {
 	p.state = stateSyncAck
-	return syncREQ, nil
+	return SyncREQ, nil
 }
 
 func (p *Parser) packetReady() (syncCmd byte, pkt *Packet) {
+	if p.CRC {
+		p.state = stateMsgCRC
+		return
+	}
+	return p.packetDone()
+}
+
+func (p *Parser) packetDone() (syncCmd byte, pkt *Packet) {
 	p.state = stateMsgSeq
 	pkt, p.packet = p.packet, nil
 	return
@@ -60,17 +60,34 @@ func (b *parserTestSequenceBuilder) packet(seq, code byte, data ...byte) *parser
 }
 
 func (b *parserTestSequenceBuilder) resync() *parserTestSequenceBuilder {
-	return b.final(ParseResult{Sync: syncREQ, State: SyncStateSyncing})
+	return b.final(ParseResult{Sync: SyncREQ, State: SyncStateSyncing})
 }
 
 func (b *parserTestSequenceBuilder) syncedWithAck() *parserTestSequenceBuilder {
-	return b.final(ParseResult{Sync: syncACK, State: SyncStateReady})
+	return b.final(ParseResult{Sync: SyncACK, State: SyncStateReady})
 }
 
 func (b *parserTestSequenceBuilder) build() []parserTestSequence {
 	return b.seq
 }
 
+func runParserTestSequences(t *testing.T, parser *Parser, seq []parserTestSequence) {
+	for n, s := range seq {
+		var pr ParseResult
+		if l := len(s.in); l == 0 {
+			pr = parser.Timeout()
+		} else {
+			for i, b := range s.in {
+				pr = parser.Parse(b)
+				if i+1 < l {
+					require.Equalf(t, s.expect, pr, "seq[%d][%d] expect mismatch", n, i)
+				}
+			}
+		}
+		require.Equalf(t, s.final, pr, "seq[%d] final mismatch", n)
+	}
+}
+
 func TestParser(t *testing.T) {
 	testCases := []struct {
 		name string
@@ -79,7 +96,7 @@ func TestParser(t *testing.T) {
 		{
 			name: "sync and receive",
 			seq: parserTestSequences().
-				onSyncing(syncACK, 1).synced().
+				onSyncing(SyncACK, 1).synced().
 				onReceiving(1, 0x02).packet(1, 2).
 				onReceiving(2, 0x72, 0).packet(2, 2).
 				onReceiving(3, 0x92, 0x03).packet(3, 0x82, 3).
@@ -90,7 +107,7 @@ func TestParser(t *testing.T) {
 			name: "sync timeout",
 			seq: parserTestSequences().
 				timeout().resync().
-				onSyncing(syncACK).
+				onSyncing(SyncACK).
 				timeout().resync().
 				build(),
 		},
@@ -98,79 +115,79 @@ func TestParser(t *testing.T) {
 			name: "sync skip invalid bytes",
 			seq: parserTestSequences().
 				on(SyncStateSyncing, 1, 2, 3, 4, 0x80, 0x81, 0xf0, 0xf1).
-				onSyncing(syncACK, 1).synced().
+				onSyncing(SyncACK, 1).synced().
 				build(),
 		},
 		{
 			name: "handle req in sync",
 			seq: parserTestSequences().
-				onSyncing(syncREQ, 1).syncedWithAck().
+				onSyncing(SyncREQ, 1).syncedWithAck().
 				build(),
 		},
 		{
 			name: "handle req in sync with invalid seq",
 			seq: parserTestSequences().
-				onSyncing(syncREQ, syncREQ).resync().
-				onSyncing(syncACK, 1).synced().
+				onSyncing(SyncREQ, SyncREQ).resync().
+				onSyncing(SyncACK, 1).synced().
 				build(),
 		},
 		{
 			name: "handle req after sync",
 			seq: parserTestSequences().
-				onSyncing(syncACK, 1).synced().
-				onSyncing(syncREQ, 1).syncedWithAck().
+				onSyncing(SyncACK, 1).synced().
+				onSyncing(SyncREQ, 1).syncedWithAck().
 				onReceiving(1, 0x02).packet(1, 2).
 				build(),
 		},
 		{
 			name: "handle req after sync with invalid seq",
 			seq: parserTestSequences().
-				onSyncing(syncACK, 1).synced().
-				onSyncing(syncREQ, syncACK).resync().
-				onSyncing(syncACK, 1).synced().
+				onSyncing(SyncACK, 1).synced().
+				onSyncing(SyncREQ, SyncACK).resync().
+				onSyncing(SyncACK, 1).synced().
 				build(),
 		},
 		{
 			name: "handle ack in sync with invalid seq",
 			seq: parserTestSequences().
-				onSyncing(syncACK, syncREQ).resync().
-				onSyncing(syncACK, 1).synced().
+				onSyncing(SyncACK, SyncREQ).resync().
+				onSyncing(SyncACK, 1).synced().
 				build(),
 		},
 		{
 			name: "handle ack after sync",
 			seq: parserTestSequences().
-				onSyncing(syncACK, 1).synced().
-				onReceiving(syncACK, 1).synced().
+				onSyncing(SyncACK, 1).synced().
+				onReceiving(SyncACK, 1).synced().
 				onReceiving(1, 0x02).packet(1, 2).
 				build(),
 		},
 		{
 			name: "ack invalid seq after sync",
 			seq: parserTestSequences().
-				onSyncing(syncACK, 1).synced().
-				onReceiving(syncACK, 2).resync().
-				onSyncing(syncACK, 2).synced().
+				onSyncing(SyncACK, 1).synced().
+				onReceiving(SyncACK, 2).resync().
+				onSyncing(SyncACK, 2).synced().
 				onReceiving(2, 0x02).packet(2, 2).
 				build(),
 		},
 		{
 			name: "invalid seq",
 			seq: parserTestSequences().
-				onSyncing(syncACK, 1).synced().
+				onSyncing(SyncACK, 1).synced().
 				onReceiving(1, 2).packet(1, 2).
 				onSyncing(1).resync().
 				on(SyncStateSyncing, 0x92, 3).
-				onSyncing(syncACK, 3).synced().
+				onSyncing(SyncACK, 3).synced().
 				build(),
 		},
 		{
 			name: "invalid data len",
 			seq: parserTestSequences().
-				onSyncing(syncACK, 1).synced().
+				onSyncing(SyncACK, 1).synced().
 				onReceiving(1, 0x70, 0x80).resync().
 				on(SyncStateSyncing, 1, 2, 3, 4).
-				onSyncing(syncACK, 1).synced().
+				onSyncing(SyncACK, 1).synced().
 				build(),
 		},
 	}
@@ -178,20 +195,7 @@ func TestParser(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			var parser Parser
-			for n, s := range tc.seq {
-				var pr ParseResult
-				if l := len(s.in); l == 0 {
-					pr = parser.Timeout()
-				} else {
-					for i, b := range s.in {
-						pr = parser.Parse(b)
-						if i+1 < l {
-							require.Equalf(t, s.expect, pr, "seq[%d][%d] expect mismatch", n, i)
-						}
-					}
-				}
-				require.Equalf(t, s.final, pr, "seq[%d] final mismatch", n)
-			}
+			runParserTestSequences(t, &parser, tc.seq)
 		})
 	}
 }
@@ -199,7 +203,7 @@ func TestParser(t *testing.T) {
 func TestParserReset(t *testing.T) {
 	var parser Parser
 	pr := parser.Reset()
-	require.Equal(t, syncREQ, pr.Sync)
+	require.Equal(t, SyncREQ, pr.Sync)
 	require.Equal(t, SyncStateSyncing, pr.State)
 	require.Nil(t, pr.Packet)
 }
@@ -222,12 +226,12 @@ func TestParseResult(t *testing.T) {
 		action TimerAction
 	}{
 		{SyncStateSyncing, 0, TimerNoChange},
-		{SyncStateSyncing, syncACK, TimerNoChange},
-		{SyncStateSyncing, syncREQ, TimerRestart},
-		{SyncStateSyncing, syncACK, TimerNoChange},
+		{SyncStateSyncing, SyncACK, TimerNoChange},
+		{SyncStateSyncing, SyncREQ, TimerRestart},
+		{SyncStateSyncing, SyncACK, TimerNoChange},
 		{SyncStateReceiving, 0, TimerRestart},
 		{SyncStateReady, 0, TimerStop},
-		{SyncStateReady, syncACK, TimerStop},
+		{SyncStateReady, SyncACK, TimerStop},
 	}
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("%x %x", tc.state, tc.cmd), func(t *testing.T) {
@@ -236,3 +240,46 @@ func TestParseResult(t *testing.T) {
 		})
 	}
 }
+
+func TestParserCRC(t *testing.T) {
+	crcOf := func(p ...byte) byte { return CRC8(0, p...) }
+	testCases := []struct {
+		name string
+		seq  []parserTestSequence
+	}{
+		{
+			name: "receive with crc",
+			seq: parserTestSequences().
+				onSyncing(SyncACK, 1).synced().
+				onReceiving(1, 0x02, crcOf(1, 0x02)).packet(1, 2).
+				onReceiving(2, 0x92, 0x03, crcOf(2, 0x92, 0x03)).packet(2, 0x82, 3).
+				onReceiving(3, 0x72, 0x08, 1, 2, 3, 4, 5, 6, 7, 8, crcOf(3, 0x72, 0x08, 1, 2, 3, 4, 5, 6, 7, 8)).
+				packet(3, 2, 1, 2, 3, 4, 5, 6, 7, 8).
+				build(),
+		},
+		{
+			name: "crc mismatch",
+			seq: parserTestSequences().
+				onSyncing(SyncACK, 1).synced().
+				onReceiving(1, 0x92, 0x04, crcOf(1, 0x92, 0x03)).resync().
+				onSyncing(SyncACK, 1).synced().
+				onReceiving(1, 0x92, 0x03, crcOf(1, 0x92, 0x03)).packet(1, 0x82, 3).
+				build(),
+		},
+		{
+			name: "timeout waiting for crc",
+			seq: parserTestSequences().
+				onSyncing(SyncACK, 1).synced().
+				onReceiving(1, 0x02).
+				timeout().resync().
+				build(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := Parser{CRC: true}
+			runParserTestSequences(t, &parser, tc.seq)
+		})
+	}
+}
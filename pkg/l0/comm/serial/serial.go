@@ -0,0 +1,193 @@
+// Package serial provides serial port transport for L0 FIFO.
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+// Parity defines the parity checking mode.
+type Parity byte
+
+// Parity modes.
+const (
+	ParityNone Parity = 'N'
+	ParityEven Parity = 'E'
+	ParityOdd  Parity = 'O'
+)
+
+// Config defines the configuration of a serial port.
+type Config struct {
+	// Device is the path to the device, e.g. /dev/ttyUSB0.
+	Device string
+	// BaudRate is the baud rate, e.g. 115200.
+	BaudRate int
+	// DataBits is the number of data bits, 5 to 8.
+	DataBits int
+	// Parity is the parity checking mode.
+	Parity Parity
+	// StopBits is the number of stop bits, 1 or 2.
+	StopBits int
+	// ReadTimeout is the inter-byte timeout of Read. It's programmed
+	// into VTIME with 0.1s resolution (rounded up, at most 25.5s).
+	// When it's non-zero, VMIN is 0 and Read returns 0 bytes with no
+	// error on timeout, which is what FIFO expects with ReadTimeout.
+	// When it's zero, Read blocks until at least one byte arrives.
+	ReadTimeout time.Duration
+}
+
+var (
+	// ErrUnsupportedBaudRate indicates the baud rate is not supported.
+	ErrUnsupportedBaudRate = errors.New("unsupported baud rate")
+	// ErrInvalidConfig indicates invalid configuration.
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrUnsupportedPlatform indicates serial ports are not supported
+	// on the platform.
+	ErrUnsupportedPlatform = errors.New("serial port unsupported on this platform")
+)
+
+// URLScheme is the scheme for serial port URL.
+const URLScheme = "serial"
+
+// DefaultConfig returns the default config of 115200 8N1
+// with 100ms read timeout.
+func DefaultConfig() *Config {
+	return &Config{
+		BaudRate:    115200,
+		DataBits:    8,
+		Parity:      ParityNone,
+		StopBits:    1,
+		ReadTimeout: 100 * time.Millisecond,
+	}
+}
+
+// ParseURL parses the config from a URL, e.g.
+//
+//	serial:///dev/ttyUSB0?baud=115200&parity=none&databits=8&stopbits=1&timeout=100ms
+//
+// Query parameters are optional and default values from DefaultConfig
+// are used if not specified.
+func ParseURL(rawURL string) (*Config, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != URLScheme {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	conf := DefaultConfig()
+	if conf.Device = u.Path; conf.Device == "" {
+		return nil, fmt.Errorf("%v: device path is missing", ErrInvalidConfig)
+	}
+	query := u.Query()
+	for key, parse := range map[string]func(string) error{
+		"baud":     intParser(&conf.BaudRate),
+		"databits": intParser(&conf.DataBits),
+		"stopbits": intParser(&conf.StopBits),
+		"parity": func(val string) (err error) {
+			conf.Parity, err = ParseParity(val)
+			return
+		},
+		"timeout": func(val string) (err error) {
+			conf.ReadTimeout, err = time.ParseDuration(val)
+			return
+		},
+	} {
+		if val := query.Get(key); val != "" {
+			if err := parse(val); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// ParseParity parses the parity mode from a string.
+func ParseParity(str string) (Parity, error) {
+	switch strings.ToLower(str) {
+	case "n", "none":
+		return ParityNone, nil
+	case "e", "even":
+		return ParityEven, nil
+	case "o", "odd":
+		return ParityOdd, nil
+	}
+	return ParityNone, fmt.Errorf("unknown parity %q", str)
+}
+
+// String implements Stringer.
+func (p Parity) String() string {
+	switch p {
+	case ParityNone:
+		return "none"
+	case ParityEven:
+		return "even"
+	case ParityOdd:
+		return "odd"
+	}
+	return fmt.Sprintf("parity(%d)", byte(p))
+}
+
+// Validate validates the config.
+func (c *Config) Validate() error {
+	if c.Device == "" {
+		return fmt.Errorf("%v: device path is missing", ErrInvalidConfig)
+	}
+	if c.DataBits < 5 || c.DataBits > 8 {
+		return fmt.Errorf("%v: data bits %d", ErrInvalidConfig, c.DataBits)
+	}
+	if c.StopBits != 1 && c.StopBits != 2 {
+		return fmt.Errorf("%v: stop bits %d", ErrInvalidConfig, c.StopBits)
+	}
+	switch c.Parity {
+	case ParityNone, ParityEven, ParityOdd:
+	default:
+		return fmt.Errorf("%v: %v", ErrInvalidConfig, c.Parity)
+	}
+	if c.ReadTimeout < 0 {
+		return fmt.Errorf("%v: negative read timeout", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// NewFIFO opens the serial port and creates a FIFO over it.
+// If ReadTimeout is configured, FIFO.ReadTimeout is enabled and
+// FIFO.Timeout is set to the same value as the sync timer is
+// driven by the read timeout.
+func (c *Config) NewFIFO() (*comm.FIFO, *Port, error) {
+	port, err := Open(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	fifo := comm.NewFIFO(port)
+	if c.ReadTimeout > 0 {
+		fifo.ReadTimeout = true
+		fifo.Timeout = port.ReadTimeout()
+	}
+	return fifo, port, nil
+}
+
+// OpenURL opens the serial port from a URL.
+func OpenURL(rawURL string) (*Port, error) {
+	conf, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return Open(conf)
+}
+
+func intParser(val *int) func(string) error {
+	return func(str string) (err error) {
+		*val, err = strconv.Atoi(str)
+		return
+	}
+}
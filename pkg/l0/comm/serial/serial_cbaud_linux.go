@@ -0,0 +1,7 @@
+//go:build linux && !ppc64 && !ppc64le
+// +build linux,!ppc64,!ppc64le
+
+package serial
+
+// tcCBAUD is the mask of baud rate in Cflag.
+const tcCBAUD = 0x100f
@@ -0,0 +1,8 @@
+//go:build linux && (ppc64 || ppc64le)
+// +build linux
+// +build ppc64 ppc64le
+
+package serial
+
+// tcCBAUD is the mask of baud rate in Cflag, which is different on POWER.
+const tcCBAUD = 0xff
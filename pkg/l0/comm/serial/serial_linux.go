@@ -0,0 +1,263 @@
+//go:build linux
+// +build linux
+
+package serial
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Port is an opened serial port.
+// It implements io.ReadWriteCloser with blocking I/O on the
+// file descriptor, so the read timeout configured in VTIME
+// is honored.
+type Port struct {
+	fd          int
+	conf        Config
+	readTimeout time.Duration
+	lock        sync.RWMutex
+
+	// wake up a pending Read on Close.
+	wakeR, wakeW int
+	wakeOnce     sync.Once
+}
+
+// missing from package syscall, tcCBAUD depends on the arch.
+const (
+	tcCRTSCTS = 0x80000000
+	pollIn    = 0x1
+)
+
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+var baudRates = map[int]uint32{
+	50:      syscall.B50,
+	75:      syscall.B75,
+	110:     syscall.B110,
+	134:     syscall.B134,
+	150:     syscall.B150,
+	200:     syscall.B200,
+	300:     syscall.B300,
+	600:     syscall.B600,
+	1200:    syscall.B1200,
+	1800:    syscall.B1800,
+	2400:    syscall.B2400,
+	4800:    syscall.B4800,
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	500000:  syscall.B500000,
+	576000:  syscall.B576000,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+	1152000: syscall.B1152000,
+	1500000: syscall.B1500000,
+	2000000: syscall.B2000000,
+	2500000: syscall.B2500000,
+	3000000: syscall.B3000000,
+	3500000: syscall.B3500000,
+	4000000: syscall.B4000000,
+}
+
+var dataBits = map[int]uint32{
+	5: syscall.CS5,
+	6: syscall.CS6,
+	7: syscall.CS7,
+	8: syscall.CS8,
+}
+
+// Open opens and configures the serial port.
+func Open(conf *Config) (*Port, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	baud, ok := baudRates[conf.BaudRate]
+	if !ok {
+		return nil, ErrUnsupportedBaudRate
+	}
+	// open in non-blocking mode to avoid waiting for carrier detect,
+	// and switch to blocking mode after CLOCAL is set.
+	fd, err := syscall.Open(conf.Device, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: conf.Device, Err: err}
+	}
+	p := &Port{fd: fd, conf: *conf}
+	if err = p.configure(baud); err == nil {
+		err = syscall.SetNonblock(fd, false)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, &os.PathError{Op: "configure", Path: conf.Device, Err: err}
+	}
+	var wake [2]int
+	if err = syscall.Pipe2(wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(fd)
+		return nil, &os.PathError{Op: "pipe", Path: conf.Device, Err: err}
+	}
+	p.wakeR, p.wakeW = wake[0], wake[1]
+	return p, nil
+}
+
+func (p *Port) configure(baud uint32) error {
+	var t syscall.Termios
+	if errno := p.ioctl(syscall.TCGETS, unsafe.Pointer(&t)); errno != 0 {
+		return errno
+	}
+	// raw mode.
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF | syscall.INPCK
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB | tcCBAUD | tcCRTSCTS
+	t.Cflag |= syscall.CREAD | syscall.CLOCAL | dataBits[p.conf.DataBits] | baud
+	switch p.conf.Parity {
+	case ParityEven:
+		t.Cflag |= syscall.PARENB
+		t.Iflag |= syscall.INPCK
+	case ParityOdd:
+		t.Cflag |= syscall.PARENB | syscall.PARODD
+		t.Iflag |= syscall.INPCK
+	}
+	if p.conf.StopBits == 2 {
+		t.Cflag |= syscall.CSTOPB
+	}
+	setSpeed(&t, baud)
+
+	// VTIME is in deciseconds.
+	if p.conf.ReadTimeout > 0 {
+		vtime := (p.conf.ReadTimeout + 100*time.Millisecond - 1) / (100 * time.Millisecond)
+		if vtime > 0xff {
+			vtime = 0xff
+		}
+		t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 0, uint8(vtime)
+		p.readTimeout = vtime * 100 * time.Millisecond
+	} else {
+		t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+	}
+
+	if errno := p.ioctl(syscall.TCSETS, unsafe.Pointer(&t)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Config returns the config used to open the port.
+func (p *Port) Config() Config {
+	return p.conf
+}
+
+// ReadTimeout returns the actual read timeout programmed in VTIME.
+// It's zero if Read blocks until data arrives.
+func (p *Port) ReadTimeout() time.Duration {
+	return p.readTimeout
+}
+
+// Read implements io.Reader.
+// When read timeout is configured, it returns 0 bytes with no error
+// after the timeout expires without receiving data.
+// A pending Read returns os.ErrClosed when the port is closed.
+func (p *Port) Read(b []byte) (int, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.fd < 0 {
+		return 0, os.ErrClosed
+	}
+	for {
+		ready, err := p.wait()
+		if err != nil || !ready {
+			return 0, err
+		}
+		n, err := syscall.Read(p.fd, b)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, &os.PathError{Op: "read", Path: p.conf.Device, Err: err}
+		}
+		return n, nil
+	}
+}
+
+// Write implements io.Writer.
+func (p *Port) Write(b []byte) (n int, err error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.fd < 0 {
+		return 0, os.ErrClosed
+	}
+	for n < len(b) {
+		var written int
+		written, err = syscall.Write(p.fd, b[n:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return n, &os.PathError{Op: "write", Path: p.conf.Device, Err: err}
+		}
+		n += written
+	}
+	return
+}
+
+// wait waits until the port is readable, closed, or the read timeout
+// expires. It returns false on timeout.
+func (p *Port) wait() (bool, error) {
+	fds := [2]pollFd{
+		{fd: int32(p.fd), events: pollIn},
+		{fd: int32(p.wakeR), events: pollIn},
+	}
+	var timeout *syscall.Timespec
+	if p.readTimeout > 0 {
+		ts := syscall.NsecToTimespec(int64(p.readTimeout))
+		timeout = &ts
+	}
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])),
+			uintptr(len(fds)), uintptr(unsafe.Pointer(timeout)), 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return false, &os.PathError{Op: "poll", Path: p.conf.Device, Err: errno}
+		}
+		if fds[1].revents != 0 {
+			return false, os.ErrClosed
+		}
+		return n > 0, nil
+	}
+}
+
+// Close implements io.Closer.
+// It wakes up a pending Read, which returns os.ErrClosed.
+func (p *Port) Close() error {
+	p.wakeOnce.Do(func() {
+		syscall.Write(p.wakeW, []byte{0})
+	})
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.fd < 0 {
+		return nil
+	}
+	err := syscall.Close(p.fd)
+	syscall.Close(p.wakeR)
+	syscall.Close(p.wakeW)
+	p.fd = -1
+	return err
+}
+
+func (p *Port) ioctl(req uint, ptr unsafe.Pointer) syscall.Errno {
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(p.fd), uintptr(req), uintptr(ptr))
+	return err
+}
@@ -0,0 +1,152 @@
+//go:build linux
+// +build linux
+
+package serial
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+// openPty opens a pty pair and returns the master and the path of slave.
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pty not available: %v", err)
+	}
+	var unlock int32
+	var ptn uint32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if errno == 0 {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptn)))
+	}
+	if errno != 0 {
+		master.Close()
+		t.Skipf("pty not available: %v", errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", ptn)
+}
+
+func TestPortReadWrite(t *testing.T) {
+	master, device := openPty(t)
+	defer master.Close()
+
+	conf := DefaultConfig()
+	conf.Device = device
+	port, err := Open(conf)
+	require.NoError(t, err)
+	defer port.Close()
+	require.Equal(t, 100*time.Millisecond, port.ReadTimeout())
+
+	// raw mode: no translation of CR/LF and control chars.
+	data := []byte{0xff, 0x01, '\r', '\n', 0x03, 0x7f}
+	_, err = master.Write(data)
+	require.NoError(t, err)
+	buf := make([]byte, len(data))
+	for received := 0; received < len(data); {
+		n, err := port.Read(buf[received:])
+		require.NoError(t, err)
+		require.NotZero(t, n)
+		received += n
+	}
+	require.Equal(t, data, buf)
+
+	n, err := port.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	for received := 0; received < len(data); {
+		n, err := master.Read(buf[received:])
+		require.NoError(t, err)
+		received += n
+	}
+	require.Equal(t, data, buf)
+}
+
+func TestPortReadTimeout(t *testing.T) {
+	master, device := openPty(t)
+	defer master.Close()
+
+	conf := DefaultConfig()
+	conf.Device, conf.ReadTimeout = device, 150*time.Millisecond
+	port, err := Open(conf)
+	require.NoError(t, err)
+	defer port.Close()
+	require.Equal(t, 200*time.Millisecond, port.ReadTimeout())
+
+	start := time.Now()
+	n, err := port.Read(make([]byte, 1))
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	require.NoError(t, port.Close())
+	_, err = port.Read(make([]byte, 1))
+	require.Equal(t, os.ErrClosed, err)
+}
+
+func TestPortCloseUnblocksRead(t *testing.T) {
+	master, device := openPty(t)
+	defer master.Close()
+
+	conf := DefaultConfig()
+	conf.Device, conf.ReadTimeout = device, 0
+	port, err := Open(conf)
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := port.Read(make([]byte, 1))
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, port.Close())
+	select {
+	case err = <-errCh:
+		require.Equal(t, os.ErrClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Read is not unblocked by Close")
+	}
+}
+
+func TestFIFOOverPty(t *testing.T) {
+	master, device := openPty(t)
+	defer master.Close()
+
+	conf := DefaultConfig()
+	conf.Device = device
+	fifo, port, err := conf.NewFIFO()
+	require.NoError(t, err)
+	defer port.Close()
+	require.True(t, fifo.ReadTimeout)
+	require.Equal(t, port.ReadTimeout(), fifo.Timeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- fifo.Run(ctx) }()
+
+	buf := make([]byte, 2)
+	_, err = master.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, byte(0xff), buf[0])
+	// ACK the sync request.
+	_, err = master.Write([]byte{0xfe, 0x01})
+	require.NoError(t, err)
+	for start := time.Now(); !fifo.State().IsReady(); time.Sleep(10 * time.Millisecond) {
+		require.True(t, time.Since(start) < time.Second, "sync timeout")
+	}
+
+	cancel()
+	select {
+	case err = <-errCh:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("FIFO not stopped")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+package serial
+
+import "syscall"
+
+// setSpeed does nothing as termios on MIPS has no speed fields,
+// and the baud rate is only set in Cflag.
+func setSpeed(t *syscall.Termios, baud uint32) {
+}
@@ -0,0 +1,44 @@
+//go:build !linux
+// +build !linux
+
+package serial
+
+import "time"
+
+// Port is an opened serial port, unsupported on this platform.
+type Port struct {
+	conf Config
+}
+
+// Open fails with ErrUnsupportedPlatform.
+func Open(conf *Config) (*Port, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return nil, ErrUnsupportedPlatform
+}
+
+// Config returns the config used to open the port.
+func (p *Port) Config() Config {
+	return p.conf
+}
+
+// ReadTimeout returns the read timeout.
+func (p *Port) ReadTimeout() time.Duration {
+	return 0
+}
+
+// Read implements io.Reader.
+func (p *Port) Read(b []byte) (int, error) {
+	return 0, ErrUnsupportedPlatform
+}
+
+// Write implements io.Writer.
+func (p *Port) Write(b []byte) (int, error) {
+	return 0, ErrUnsupportedPlatform
+}
+
+// Close implements io.Closer.
+func (p *Port) Close() error {
+	return nil
+}
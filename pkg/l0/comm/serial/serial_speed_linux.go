@@ -0,0 +1,10 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package serial
+
+import "syscall"
+
+func setSpeed(t *syscall.Termios, baud uint32) {
+	t.Ispeed, t.Ospeed = baud, baud
+}
@@ -0,0 +1,52 @@
+package serial
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	conf, err := ParseURL("serial:///dev/ttyUSB0")
+	require.NoError(t, err)
+	expected := DefaultConfig()
+	expected.Device = "/dev/ttyUSB0"
+	require.Equal(t, expected, conf)
+
+	conf, err = ParseURL("serial:///dev/ttyS1?baud=9600&parity=even&databits=7&stopbits=2&timeout=250ms")
+	require.NoError(t, err)
+	require.Equal(t, &Config{
+		Device:      "/dev/ttyS1",
+		BaudRate:    9600,
+		DataBits:    7,
+		Parity:      ParityEven,
+		StopBits:    2,
+		ReadTimeout: 250 * time.Millisecond,
+	}, conf)
+
+	for _, invalid := range []string{
+		"tcp://localhost:1234",
+		"serial://",
+		"serial:///dev/ttyS1?baud=fast",
+		"serial:///dev/ttyS1?parity=mark",
+		"serial:///dev/ttyS1?databits=9",
+		"serial:///dev/ttyS1?stopbits=3",
+		"serial:///dev/ttyS1?timeout=-1s",
+	} {
+		_, err = ParseURL(invalid)
+		require.Errorf(t, err, invalid)
+	}
+}
+
+func TestParseParity(t *testing.T) {
+	for str, expected := range map[string]Parity{
+		"n": ParityNone, "None": ParityNone,
+		"E": ParityEven, "even": ParityEven,
+		"o": ParityOdd, "ODD": ParityOdd,
+	} {
+		parity, err := ParseParity(str)
+		require.NoError(t, err)
+		require.Equal(t, expected, parity)
+	}
+}
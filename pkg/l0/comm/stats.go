@@ -0,0 +1,128 @@
+package comm
+
+import "time"
+
+// FIFOStats provides the statistics of a FIFO.
+type FIFOStats struct {
+	// State is the current sync state.
+	State SyncState
+	// BytesSent is the number of bytes written, including sync commands.
+	BytesSent uint64
+	// PacketsSent is the number of packets sent, including fragments.
+	PacketsSent uint64
+	// SyncRequests is the number of sync requests (REQ) sent.
+	SyncRequests uint64
+	// SyncAcks is the number of sync acknowledgements (ACK) sent.
+	SyncAcks uint64
+	// SyncLost is the number of times the FIFO left ready state.
+	SyncLost uint64
+	// Heartbeats is the number of keepalive heartbeats sent.
+	Heartbeats uint64
+	// PeerDead is the number of times the peer is considered dead by keepalive.
+	PeerDead uint64
+	// Parser provides the receiving side statistics.
+	Parser ParserStats
+}
+
+// ClientStats provides the statistics of a Client.
+type ClientStats struct {
+	// FIFO is the statistics of the wrapped FIFO.
+	FIFO FIFOStats
+	// Commands is the number of commands issued.
+	Commands uint64
+	// SendErrors is the number of commands failed to send.
+	SendErrors uint64
+	// Replies is the number of replies matching pending commands.
+	Replies uint64
+	// CommandErrors is the number of replies with error.
+	CommandErrors uint64
+	// NoReplies is the number of commands failed with ErrNoReply.
+	NoReplies uint64
+	// Timeouts is the number of commands failed with ErrTimeout.
+	Timeouts uint64
+	// SyncLost is the number of commands failed with ErrSyncLost.
+	SyncLost uint64
+	// Events is the number of events received.
+	Events uint64
+	// UnhandledEvents is the number of events dropped without a handler.
+	UnhandledEvents uint64
+	// Latency is the histogram of round-trip time of commands.
+	Latency LatencyHistogram
+	// InFlight is the number of commands holding the in-flight window.
+	InFlight int
+	// WindowFull is the number of commands found the in-flight window full,
+	// either waited or failed with ErrWindowFull.
+	WindowFull uint64
+	// QueueDelay is the histogram of time waiting for the in-flight window.
+	QueueDelay LatencyHistogram
+}
+
+// DefaultLatencyBounds are the upper bounds of latency histogram buckets.
+var DefaultLatencyBounds = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram is a histogram of latencies.
+type LatencyHistogram struct {
+	// Bounds are the inclusive upper bounds of buckets in ascending order.
+	Bounds []time.Duration
+	// Counts are the number of observations in each bucket.
+	// It has one more bucket than Bounds for observations
+	// exceeding the last bound.
+	Counts []uint64
+	// Count is the total number of observations.
+	Count uint64
+	// Sum is the sum of all observations.
+	Sum time.Duration
+	// Max is the max observation.
+	Max time.Duration
+}
+
+// NewLatencyHistogram creates a histogram with bounds.
+// DefaultLatencyBounds is used if bounds is empty.
+func NewLatencyHistogram(bounds ...time.Duration) *LatencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBounds
+	}
+	return &LatencyHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe records a latency.
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	n := 0
+	for ; n < len(h.Bounds) && d > h.Bounds[n]; n++ {
+	}
+	h.Counts[n]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Mean returns the average latency.
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Clone makes a deep copy.
+func (h *LatencyHistogram) Clone() LatencyHistogram {
+	c := *h
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return c
+}
@@ -0,0 +1,90 @@
+package comm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := NewLatencyHistogram(time.Millisecond, 10*time.Millisecond)
+	require.Zero(t, h.Mean())
+	h.Observe(time.Millisecond)
+	h.Observe(5 * time.Millisecond)
+	h.Observe(30 * time.Millisecond)
+	require.Equal(t, []uint64{1, 1, 1}, h.Counts)
+	require.Equal(t, uint64(3), h.Count)
+	require.Equal(t, 30*time.Millisecond, h.Max)
+	require.Equal(t, 12*time.Millisecond, h.Mean())
+
+	c := h.Clone()
+	h.Observe(0)
+	require.Equal(t, []uint64{1, 1, 1}, c.Counts)
+	require.Equal(t, []uint64{2, 1, 1}, h.Counts)
+}
+
+func TestParserStats(t *testing.T) {
+	parser := Parser{CRC: true}
+	runParserTestSequences(t, &parser, parserTestSequences().
+		onSyncing(SyncACK, 1).synced().
+		onReceiving(1, 0x02, CRC8(0, 1, 0x02)).packet(1, 2).
+		onReceiving(2, 0x02, 0).resync().
+		onSyncing(SyncACK, 2).synced().
+		onReceiving(3).resync().
+		onSyncing(SyncACK, 2).synced().
+		onReceiving(2, 0x70, 0x80).resync().
+		onSyncing(SyncREQ, 0xf0).resync().
+		timeout().resync().
+		build())
+	require.Equal(t, ParserStats{
+		Bytes:        18,
+		Packets:      1,
+		Resyncs:      5,
+		SyncErrors:   1,
+		SeqErrors:    1,
+		LengthErrors: 1,
+		CRCErrors:    1,
+		Timeouts:     1,
+	}, parser.Stats())
+}
+
+func TestClientStats(t *testing.T) {
+	clientFIFO, deviceFIFO := newLinkedFIFOs()
+	client, device := NewClient(clientFIFO), NewDevice(deviceFIFO)
+	device.HandleFunc(2, func(ctx context.Context, pkt *Packet) Result {
+		return Result{Code: 2}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+	go client.Run(ctx)
+	go device.Run(ctx)
+	waitReady(t, clientFIFO, deviceFIFO)
+
+	opts := &CommandOptions{Timeout: time.Second}
+	require.NoError(t, client.DoContext(ctx, &Packet{Code: 2, Data: []byte{1}}, opts).Err)
+	require.Error(t, client.DoContext(ctx, &Packet{Code: 4}, opts).Err)
+	require.NoError(t, device.Emit(3, nil))
+	<-client.EventChan()
+
+	stats := client.Stats()
+	require.Equal(t, uint64(2), stats.Commands)
+	require.Equal(t, uint64(2), stats.Replies)
+	require.Equal(t, uint64(1), stats.CommandErrors)
+	require.Equal(t, uint64(1), stats.Events)
+	require.Equal(t, uint64(2), stats.Latency.Count)
+	require.True(t, stats.FIFO.State.IsReady())
+	require.Equal(t, uint64(2), stats.FIFO.PacketsSent)
+	// sync REQ, ACK to the REQ from peer, and packets of 3 and 2 bytes.
+	require.Equal(t, uint64(2+2+3+2), stats.FIFO.BytesSent)
+	require.Equal(t, uint64(1), stats.FIFO.SyncRequests)
+	require.Equal(t, uint64(1), stats.FIFO.SyncAcks)
+	require.Equal(t, uint64(3), stats.FIFO.Parser.Packets)
+	require.Zero(t, stats.FIFO.Parser.Resyncs)
+}
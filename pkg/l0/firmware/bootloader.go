@@ -0,0 +1,119 @@
+package firmware
+
+import (
+	"context"
+	"hash/crc32"
+	"sync"
+
+	"github.com/robotalks/robo.go/pkg/l0/codec"
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+// Bootloader emulates the bootloader side of firmware update,
+// which is used with comm.Device for testing without hardware.
+type Bootloader struct {
+	Version  uint8
+	MaxChunk uint8
+	MaxSize  uint32
+	// OnReboot is called with the verified image on OpReboot.
+	OnReboot func(image []byte)
+
+	image    []byte
+	buf      []byte
+	crc      uint32
+	received uint32
+	verified bool
+	lock     sync.Mutex
+}
+
+// NewBootloader creates a Bootloader.
+func NewBootloader(maxSize uint32) *Bootloader {
+	return &Bootloader{Version: 1, MaxChunk: MaxChunkSize, MaxSize: maxSize}
+}
+
+// Image returns the last verified image.
+func (b *Bootloader) Image() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.image
+}
+
+// Received returns the number of bytes received for the current transfer.
+func (b *Bootloader) Received() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return int(b.received)
+}
+
+// HandleCommand implements comm.CommandHandler.
+func (b *Bootloader) HandleCommand(ctx context.Context, pkt *comm.Packet) comm.Result {
+	if len(pkt.Data) == 0 {
+		return errorResult(ErrCodeBadRequest)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	data := pkt.Data[1:]
+	switch pkt.Data[0] {
+	case OpHello:
+		return replyResult(&HelloReply{Version: b.Version, MaxChunk: b.MaxChunk, MaxSize: b.MaxSize})
+	case OpBegin:
+		var req BeginRequest
+		if codec.Unmarshal(data, &req) != nil {
+			return errorResult(ErrCodeBadRequest)
+		}
+		if req.Size > b.MaxSize {
+			return errorResult(ErrCodeTooLarge)
+		}
+		if b.buf == nil || uint32(len(b.buf)) != req.Size || b.crc != req.CRC {
+			b.buf, b.crc, b.received = make([]byte, req.Size), req.CRC, 0
+		}
+		b.verified = false
+		return replyResult(&BeginReply{Offset: b.received})
+	case OpWrite:
+		var req WriteRequest
+		if codec.Unmarshal(data, &req) != nil || len(req.Data) > int(b.MaxChunk) {
+			return errorResult(ErrCodeBadRequest)
+		}
+		if b.buf == nil {
+			return errorResult(ErrCodeNotStarted)
+		}
+		// only accept the expected chunk, the ack tells where to continue.
+		if req.Offset == b.received && uint64(req.Offset)+uint64(len(req.Data)) <= uint64(len(b.buf)) {
+			copy(b.buf[req.Offset:], req.Data)
+			b.received += uint32(len(req.Data))
+		}
+		return replyResult(&WriteReply{Offset: b.received})
+	case OpVerify:
+		if b.buf == nil || b.received != uint32(len(b.buf)) {
+			return errorResult(ErrCodeNotStarted)
+		}
+		crc := crc32.ChecksumIEEE(b.buf)
+		if crc != b.crc {
+			b.buf, b.received = nil, 0
+			return errorResult(ErrCodeBadChecksum)
+		}
+		b.verified = true
+		return replyResult(&VerifyReply{CRC: crc})
+	case OpReboot:
+		if b.verified {
+			b.image, b.buf, b.received, b.verified = b.buf, nil, 0, false
+			if b.OnReboot != nil {
+				b.OnReboot(b.image)
+			}
+		}
+		return comm.Result{}
+	}
+	return errorResult(ErrCodeBadRequest)
+}
+
+func replyResult(reply interface{}) comm.Result {
+	data, err := codec.Marshal(reply)
+	if err != nil {
+		return errorResult(ErrCodeBadRequest)
+	}
+	return comm.Result{Data: data}
+}
+
+func errorResult(code byte) comm.Result {
+	return comm.Result{Err: &comm.CommandError{Code: code}}
+}
@@ -0,0 +1,103 @@
+// Package firmware implements firmware update over the L0 link.
+//
+// All operations use a single L0 command code (DefaultCode by default),
+// with the operation in Data[0] followed by the request encoded using
+// package codec. An update goes as:
+//
+//   - OpHello: handshake with the bootloader to get the limits;
+//   - OpBegin: start or resume a transfer of the image identified by size
+//     and CRC-32, the bootloader replies the offset to resume from;
+//   - OpWrite: write chunks of the image, each acknowledged with the next
+//     offset expected by the bootloader;
+//   - OpVerify: the bootloader verifies the whole image and replies its CRC-32;
+//   - OpReboot: reboot into the new firmware.
+package firmware
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+// DefaultCode is the default L0 command code for firmware update.
+const DefaultCode byte = 0x0e
+
+// Operations in Data[0] of the command.
+const (
+	OpHello  byte = 1
+	OpBegin  byte = 2
+	OpWrite  byte = 3
+	OpVerify byte = 4
+	OpReboot byte = 5
+)
+
+// Error codes replied by the bootloader in comm.CommandError.
+const (
+	ErrCodeBadRequest  byte = 2
+	ErrCodeTooLarge    byte = 4
+	ErrCodeNotStarted  byte = 6
+	ErrCodeBadChecksum byte = 8
+)
+
+// WriteHeaderLen is the length of Data before the chunk in OpWrite.
+const WriteHeaderLen = 5
+
+// MaxChunkSize is the max chunk size fitting in a single packet.
+const MaxChunkSize = comm.MaxDataLen - WriteHeaderLen
+
+var (
+	// ErrImageTooLarge indicates the image exceeds the bootloader limit.
+	ErrImageTooLarge = errors.New("image too large")
+	// ErrVerifyFailed indicates the image verification failed.
+	ErrVerifyFailed = errors.New("image verification failed")
+	// ErrNoProgress indicates the bootloader doesn't accept chunks.
+	ErrNoProgress = errors.New("transfer not progressing")
+)
+
+// ErrBadOffset indicates the bootloader acknowledges an invalid offset.
+type ErrBadOffset struct {
+	Offset uint32
+}
+
+// Error implements error.
+func (e *ErrBadOffset) Error() string {
+	return fmt.Sprintf("bad offset %d acknowledged", e.Offset)
+}
+
+// HelloReply is the reply of OpHello.
+type HelloReply struct {
+	Version  uint8
+	MaxChunk uint8  // max chunk size of OpWrite
+	MaxSize  uint32 // max image size
+}
+
+// BeginRequest is the request of OpBegin.
+type BeginRequest struct {
+	Size uint32
+	CRC  uint32
+}
+
+// BeginReply is the reply of OpBegin.
+type BeginReply struct {
+	// Offset is where to resume the transfer, non-zero only if a
+	// previous transfer of the same image is incomplete.
+	Offset uint32
+}
+
+// WriteRequest is the request of OpWrite.
+type WriteRequest struct {
+	Offset uint32
+	Data   []byte
+}
+
+// WriteReply is the reply of OpWrite.
+type WriteReply struct {
+	// Offset is the next offset expected.
+	Offset uint32
+}
+
+// VerifyReply is the reply of OpVerify.
+type VerifyReply struct {
+	CRC uint32
+}
@@ -0,0 +1,143 @@
+package firmware
+
+import (
+	"context"
+	"hash/crc32"
+	"time"
+
+	"github.com/robotalks/robo.go/pkg/l0/codec"
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+)
+
+// maxStalls is the max number of chunks acknowledged without progress.
+const maxStalls = 3
+
+// Updater updates firmware through the bootloader over a Client.
+type Updater struct {
+	Client    *comm.Client
+	Code      byte                   // command code, DefaultCode by default
+	Options   comm.CommandOptions    // options of each command
+	ChunkSize int                    // limited by the bootloader, 0 to use the max
+	Progress  func(offset, size int) // called when a chunk is acknowledged
+}
+
+// NewUpdater creates an Updater with default options.
+func NewUpdater(client *comm.Client) *Updater {
+	return &Updater{
+		Client: client,
+		Code:   DefaultCode,
+		Options: comm.CommandOptions{
+			Timeout: time.Second,
+			Retry: comm.RetryPolicy{
+				MaxRetries: 3,
+				Delay:      100 * time.Millisecond,
+				MaxDelay:   time.Second,
+			},
+		},
+	}
+}
+
+// Hello performs the bootloader handshake.
+func (u *Updater) Hello(ctx context.Context) (*HelloReply, error) {
+	var reply HelloReply
+	if err := u.do(ctx, OpHello, nil, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Update transfers the image, resuming an incomplete transfer of the same
+// image, then verifies the image and reboots.
+func (u *Updater) Update(ctx context.Context, image []byte) error {
+	info, err := u.Hello(ctx)
+	if err != nil {
+		return err
+	}
+	if uint64(len(image)) > uint64(info.MaxSize) {
+		return ErrImageTooLarge
+	}
+	chunkSize := MaxChunkSize
+	if max := int(info.MaxChunk); max > 0 && max < chunkSize {
+		chunkSize = max
+	}
+	if u.ChunkSize > 0 && u.ChunkSize < chunkSize {
+		chunkSize = u.ChunkSize
+	}
+
+	crc := crc32.ChecksumIEEE(image)
+	var begin BeginReply
+	if err := u.do(ctx, OpBegin, &BeginRequest{Size: uint32(len(image)), CRC: crc}, &begin); err != nil {
+		return err
+	}
+	if err := u.transfer(ctx, image, begin.Offset, chunkSize); err != nil {
+		return err
+	}
+
+	var verify VerifyReply
+	if err := u.do(ctx, OpVerify, nil, &verify); err != nil {
+		if cmdErr, ok := err.(*comm.CommandError); ok && cmdErr.Code == ErrCodeBadChecksum {
+			return ErrVerifyFailed
+		}
+		return err
+	}
+	if verify.CRC != crc {
+		return ErrVerifyFailed
+	}
+	return u.do(ctx, OpReboot, nil, nil)
+}
+
+func (u *Updater) transfer(ctx context.Context, image []byte, offset uint32, chunkSize int) error {
+	size := uint32(len(image))
+	if offset > size {
+		return &ErrBadOffset{Offset: offset}
+	}
+	for stalls := 0; offset < size; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := offset + uint32(chunkSize)
+		if end > size {
+			end = size
+		}
+		var reply WriteReply
+		req := &WriteRequest{Offset: offset, Data: image[offset:end]}
+		if err := u.do(ctx, OpWrite, req, &reply); err != nil {
+			return err
+		}
+		if reply.Offset > size {
+			return &ErrBadOffset{Offset: reply.Offset}
+		}
+		if reply.Offset <= offset {
+			if stalls++; stalls > maxStalls {
+				return ErrNoProgress
+			}
+		} else {
+			stalls = 0
+		}
+		offset = reply.Offset
+		if u.Progress != nil {
+			u.Progress(int(offset), int(size))
+		}
+	}
+	return nil
+}
+
+func (u *Updater) do(ctx context.Context, op byte, request, reply interface{}) error {
+	data := []byte{op}
+	if request != nil {
+		encoded, err := codec.Marshal(request)
+		if err != nil {
+			return err
+		}
+		data = append(data, encoded...)
+	}
+	opts := u.Options
+	r := u.Client.DoContext(ctx, &comm.Packet{Code: u.Code, Data: data}, &opts)
+	if r.Err != nil {
+		return r.Err
+	}
+	if reply != nil {
+		return codec.Unmarshal(r.Data, reply)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package firmware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l0/comm/commtest"
+)
+
+func testImage(size int) []byte {
+	image := make([]byte, size)
+	for n := range image {
+		image[n] = byte(n * 7)
+	}
+	return image
+}
+
+func newTestUpdater(ctx context.Context, t *testing.T, handler comm.CommandHandler) *Updater {
+	clientRW, deviceRW := commtest.Pipe(256)
+	client := comm.NewClient(comm.NewFIFO(clientRW))
+	device := comm.NewDevice(comm.NewFIFO(deviceRW))
+	device.Handle(DefaultCode, handler)
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+	go client.Run(ctx)
+	go device.Run(ctx)
+	for start := time.Now(); !client.FIFO().State().IsReady() || !device.FIFO().State().IsReady(); time.Sleep(time.Millisecond) {
+		require.True(t, time.Since(start) < time.Second, "sync timeout")
+	}
+	return NewUpdater(client)
+}
+
+func TestUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bootloader := NewBootloader(4096)
+	rebooted := make(chan []byte, 1)
+	bootloader.OnReboot = func(image []byte) { rebooted <- image }
+	updater := newTestUpdater(ctx, t, bootloader)
+
+	info, err := updater.Hello(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &HelloReply{Version: 1, MaxChunk: MaxChunkSize, MaxSize: 4096}, info)
+
+	image := testImage(1000)
+	var offsets []int
+	updater.Progress = func(offset, size int) {
+		require.Equal(t, len(image), size)
+		offsets = append(offsets, offset)
+	}
+	require.NoError(t, updater.Update(ctx, image))
+	require.Len(t, offsets, (len(image)+MaxChunkSize-1)/MaxChunkSize)
+	require.Equal(t, len(image), offsets[len(offsets)-1])
+	select {
+	case flashed := <-rebooted:
+		require.Equal(t, image, flashed)
+	case <-time.After(time.Second):
+		t.Fatal("reboot timeout")
+	}
+	require.Equal(t, image, bootloader.Image())
+
+	require.Equal(t, ErrImageTooLarge, updater.Update(ctx, testImage(4097)))
+}
+
+func TestUpdateResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bootloader := NewBootloader(4096)
+	updater := newTestUpdater(ctx, t, bootloader)
+	updater.ChunkSize = 100
+
+	image := testImage(1000)
+	updateCtx, interrupt := context.WithCancel(ctx)
+	updater.Progress = func(offset, size int) {
+		if offset >= 300 {
+			interrupt()
+		}
+	}
+	require.Equal(t, context.Canceled, updater.Update(updateCtx, image))
+	require.Equal(t, 300, bootloader.Received())
+
+	var offsets []int
+	updater.Progress = func(offset, size int) {
+		offsets = append(offsets, offset)
+	}
+	require.NoError(t, updater.Update(ctx, image))
+	require.Equal(t, []int{400, 500, 600, 700, 800, 900, 1000}, offsets)
+	require.Equal(t, image, bootloader.Image())
+}
+
+func TestUpdateVerifyFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bootloader := NewBootloader(4096)
+	updater := newTestUpdater(ctx, t, comm.HandleCommandFunc(func(ctx context.Context, pkt *comm.Packet) comm.Result {
+		// corrupt the first chunk.
+		if len(pkt.Data) > WriteHeaderLen && pkt.Data[0] == OpWrite && pkt.Data[1] == 0 {
+			pkt.Data[WriteHeaderLen] ^= 0xff
+		}
+		return bootloader.HandleCommand(ctx, pkt)
+	}))
+	require.Equal(t, ErrVerifyFailed, updater.Update(ctx, testImage(200)))
+	require.Nil(t, bootloader.Image())
+}
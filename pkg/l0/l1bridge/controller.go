@@ -0,0 +1,153 @@
+// Package l1bridge provides a controller mapping L1 commands and events
+// to/from L0 commands and events declaratively.
+package l1bridge
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	fx "github.com/robotalks/robo.go/pkg/framework"
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l1"
+	"github.com/robotalks/robo.go/pkg/l1/msgs"
+)
+
+// EncodeFunc converts an L1 command message into L0 command data.
+type EncodeFunc func(fx.Message) ([]byte, error)
+
+// DecodeFunc converts the successful result of L0 command into the L1
+// reply message.
+type DecodeFunc func(cmd fx.Message, r comm.Result) (fx.Message, error)
+
+// EventFunc converts an L0 event into an L1 event message.
+// A nil message drops the event.
+type EventFunc func(*comm.Packet) (fx.Message, error)
+
+// CommandMapping maps an L1 command message type to an L0 command.
+type CommandMapping struct {
+	Code    byte
+	Encode  EncodeFunc           // nil to send without data
+	Decode  DecodeFunc           // nil to reply msgs.CommandOK
+	Options *comm.CommandOptions // nil to use Controller.Options
+}
+
+// Controller forwards mapped L1 commands to L0 and replies with the
+// converted results, and sends mapped L0 events as L1 events.
+// L0 commands are executed in the background without blocking the loop,
+// and are flushed in the ShutdownFlushEvents phase.
+type Controller struct {
+	Client    *comm.Client
+	Registrar l1.Registrar
+	// Options is the default options of L0 commands.
+	Options comm.CommandOptions
+
+	commands map[reflect.Type]*CommandMapping
+	pending  sync.WaitGroup
+}
+
+// New creates a Controller with 1 second timeout for L0 commands.
+func New(client *comm.Client, registrar l1.Registrar) *Controller {
+	return &Controller{
+		Client:    client,
+		Registrar: registrar,
+		Options:   comm.CommandOptions{Timeout: time.Second},
+		commands:  make(map[reflect.Type]*CommandMapping),
+	}
+}
+
+// MapCommand maps L1 command messages of the same type as prototype.
+func (c *Controller) MapCommand(prototype fx.Message, mapping CommandMapping) *Controller {
+	c.commands[reflect.TypeOf(prototype)] = &mapping
+	return c
+}
+
+// MapEvent maps an L0 event code (with 0x80 set) to L1 events.
+// The event is routed in Client.Events with opts.
+func (c *Controller) MapEvent(code byte, convert EventFunc, opts *comm.EventQueueOptions) *Controller {
+	c.Client.Events().HandleFunc(code, func(ctx context.Context, pkt *comm.Packet) {
+		msg, err := convert(pkt)
+		if err == nil && msg != nil {
+			err = c.Registrar.SendEvent(ctx, msg)
+		}
+		if err != nil {
+			glog.Errorf("L0 event %02x: %v", pkt.Code, err)
+		}
+	}, opts)
+	return c
+}
+
+// AddToLoop implements LoopAdder.
+func (c *Controller) AddToLoop(l *fx.Loop) {
+	l.AddController(fx.PrLvControl, c)
+	l.OnShutdown(fx.ShutdownFlushEvents, "l1bridge", fx.ShutdownFunc(c.Flush))
+}
+
+// Flush waits until L0 commands being executed are done.
+// It returns ctx.Err() if ctx is done first.
+func (c *Controller) Flush(ctx context.Context) error {
+	doneCh := make(chan struct{})
+	go func() {
+		c.pending.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Control implements Controller.
+func (c *Controller) Control(cc fx.ControlContext) error {
+	cc.Messages().ProcessMessages(fx.ProcessMessageFunc(func(mctx fx.MessageProcessingContext) {
+		cmdMsg, ok := mctx.CurrentMessage().(*l1.CommandMsg)
+		if !ok {
+			return
+		}
+		mapping := c.commands[reflect.TypeOf(cmdMsg.Command.Msg())]
+		if mapping == nil {
+			return
+		}
+		mctx.MessageTaken()
+		c.pending.Add(1)
+		go c.execute(cc.Context(), cmdMsg.Command, mapping)
+	}))
+	return nil
+}
+
+func (c *Controller) execute(ctx context.Context, cmd l1.Command, mapping *CommandMapping) {
+	defer c.pending.Done()
+	cmd.Done(c.do(ctx, cmd.Msg(), mapping))
+}
+
+func (c *Controller) do(ctx context.Context, msg fx.Message, mapping *CommandMapping) fx.Message {
+	pkt := &comm.Packet{Code: mapping.Code}
+	if mapping.Encode != nil {
+		data, err := mapping.Encode(msg)
+		if err != nil {
+			return msgs.NewCommandErr(err)
+		}
+		pkt.Data = data
+	}
+	opts := mapping.Options
+	if opts == nil {
+		opts = &c.Options
+	}
+	r := c.Client.DoContext(ctx, pkt, opts)
+	if r.Err != nil {
+		return msgs.NewCommandErr(r.Err)
+	}
+	if mapping.Decode == nil {
+		return msgs.NewCommandOK()
+	}
+	reply, err := mapping.Decode(msg, r)
+	if err != nil {
+		return msgs.NewCommandErr(err)
+	}
+	return reply
+}
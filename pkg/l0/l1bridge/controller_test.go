@@ -0,0 +1,149 @@
+package l1bridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	fx "github.com/robotalks/robo.go/pkg/framework"
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l0/comm/commtest"
+	"github.com/robotalks/robo.go/pkg/l1"
+	"github.com/robotalks/robo.go/pkg/l1/msgs"
+)
+
+type setSpeed struct{ speed float64 }
+
+func (m *setSpeed) NewMessage() fx.Message { return &setSpeed{} }
+
+type speedReply struct{ speed float64 }
+
+func (m *speedReply) NewMessage() fx.Message { return &speedReply{} }
+
+type stop struct{}
+
+func (m *stop) NewMessage() fx.Message { return &stop{} }
+
+type bumper struct{ mask byte }
+
+func (m *bumper) NewMessage() fx.Message { return &bumper{} }
+
+type testCommand struct {
+	msg    fx.Message
+	doneCh chan fx.Message
+}
+
+func (c *testCommand) Msg() fx.Message { return c.msg }
+
+func (c *testCommand) Done(msg fx.Message) error {
+	c.doneCh <- msg
+	return nil
+}
+
+type testRegistrar struct {
+	eventCh chan fx.Message
+}
+
+func (r *testRegistrar) SendEvent(ctx context.Context, msg fx.Message) error {
+	r.eventCh <- msg
+	return nil
+}
+
+func TestController(t *testing.T) {
+	clientRW, deviceRW := commtest.Pipe(256)
+	client := comm.NewClient(comm.NewFIFO(clientRW))
+	device := comm.NewDevice(comm.NewFIFO(deviceRW))
+	// speed in cm/s, replies the actual speed.
+	device.HandleFunc(2, func(ctx context.Context, pkt *comm.Packet) comm.Result {
+		return comm.Result{Code: 2, Data: pkt.Data}
+	}).HandleFunc(4, func(ctx context.Context, pkt *comm.Packet) comm.Result {
+		return comm.Result{Err: &comm.CommandError{Code: 2}}
+	})
+
+	registrar := &testRegistrar{eventCh: make(chan fx.Message, 1)}
+	ctl := New(client, registrar).
+		MapCommand(&setSpeed{}, CommandMapping{
+			Code: 2,
+			Encode: func(msg fx.Message) ([]byte, error) {
+				speed := msg.(*setSpeed).speed
+				if speed < 0 {
+					return nil, errors.New("negative speed")
+				}
+				return []byte{byte(speed * 100)}, nil
+			},
+			Decode: func(msg fx.Message, r comm.Result) (fx.Message, error) {
+				return &speedReply{speed: float64(r.Data[0]) / 100}, nil
+			},
+		}).
+		MapCommand(&stop{}, CommandMapping{Code: 4}).
+		MapEvent(0x81, func(pkt *comm.Packet) (fx.Message, error) {
+			return &bumper{mask: pkt.Data[0]}, nil
+		}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for range client.StateChan() {
+		}
+	}()
+	go client.Run(ctx)
+	go device.Run(ctx)
+	for start := time.Now(); !client.FIFO().State().IsReady() || !device.FIFO().State().IsReady(); time.Sleep(time.Millisecond) {
+		require.True(t, time.Since(start) < time.Second, "sync timeout")
+	}
+
+	loop := fx.NewLoop()
+	loop.Interval = 10 * time.Millisecond
+	loop.Add(ctl)
+	go loop.Run(ctx)
+
+	do := func(msg fx.Message) fx.Message {
+		cmd := &testCommand{msg: msg, doneCh: make(chan fx.Message, 1)}
+		loop.PostMessage(&l1.CommandMsg{Command: cmd})
+		select {
+		case reply := <-cmd.doneCh:
+			return reply
+		case <-time.After(time.Second):
+			t.Fatal("command timeout")
+		}
+		return nil
+	}
+	require.Equal(t, &speedReply{speed: 0.5}, do(&setSpeed{speed: 0.5}))
+	require.Equal(t, msgs.NewCommandErrFromMsg("negative speed"), do(&setSpeed{speed: -1}))
+	require.Equal(t, msgs.NewCommandErr(&comm.CommandError{Code: 2}), do(&stop{}))
+
+	require.NoError(t, device.Emit(1, []byte{3}))
+	select {
+	case event := <-registrar.eventCh:
+		require.Equal(t, &bumper{mask: 3}, event)
+	case <-time.After(time.Second):
+		t.Fatal("event timeout")
+	}
+}
+
+func TestControllerFlush(t *testing.T) {
+	releaseCh := make(chan struct{})
+	ctl := New(nil, nil).MapCommand(&stop{}, CommandMapping{
+		Code: 4,
+		Encode: func(msg fx.Message) ([]byte, error) {
+			<-releaseCh
+			return nil, errors.New("released")
+		},
+	})
+	loop := fx.NewLoop()
+	loop.Add(ctl)
+	require.NotNil(t, loop.Shutdown)
+	cmd := &testCommand{msg: &stop{}, doneCh: make(chan fx.Message, 1)}
+	loop.PostMessage(&l1.CommandMsg{Command: cmd})
+	loop.Step(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, ctl.Flush(ctx))
+	close(releaseCh)
+	require.NoError(t, loop.Shutdown.Run(context.Background()))
+	require.Len(t, cmd.doneCh, 1)
+}
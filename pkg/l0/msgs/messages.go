@@ -0,0 +1,103 @@
+// Package msgs defines L1 messages related to L0 communication.
+package msgs
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	fx "github.com/robotalks/robo.go/pkg/framework"
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l1/msgs"
+)
+
+// LinkStats is an Event message reporting the health of an L0 link.
+type LinkStats struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Ready bool   `protobuf:"varint,2,opt,name=ready,proto3" json:"ready,omitempty"`
+
+	BytesSent     uint64 `protobuf:"varint,3,opt,name=bytes_sent,proto3" json:"bytes_sent,omitempty"`
+	BytesReceived uint64 `protobuf:"varint,4,opt,name=bytes_received,proto3" json:"bytes_received,omitempty"`
+	SyncLost      uint64 `protobuf:"varint,5,opt,name=sync_lost,proto3" json:"sync_lost,omitempty"`
+	Resyncs       uint64 `protobuf:"varint,6,opt,name=resyncs,proto3" json:"resyncs,omitempty"`
+	SeqErrors     uint64 `protobuf:"varint,7,opt,name=seq_errors,proto3" json:"seq_errors,omitempty"`
+	CRCErrors     uint64 `protobuf:"varint,8,opt,name=crc_errors,proto3" json:"crc_errors,omitempty"`
+
+	Commands      uint64 `protobuf:"varint,9,opt,name=commands,proto3" json:"commands,omitempty"`
+	Replies       uint64 `protobuf:"varint,10,opt,name=replies,proto3" json:"replies,omitempty"`
+	CommandErrors uint64 `protobuf:"varint,11,opt,name=command_errors,proto3" json:"command_errors,omitempty"`
+	NoReplies     uint64 `protobuf:"varint,12,opt,name=no_replies,proto3" json:"no_replies,omitempty"`
+	Timeouts      uint64 `protobuf:"varint,13,opt,name=timeouts,proto3" json:"timeouts,omitempty"`
+	Events        uint64 `protobuf:"varint,14,opt,name=events,proto3" json:"events,omitempty"`
+	Unhandled     uint64 `protobuf:"varint,15,opt,name=unhandled_events,proto3" json:"unhandled_events,omitempty"`
+
+	// LatencyBoundsUs are the upper bounds of latency buckets in microseconds.
+	LatencyBoundsUs []uint64 `protobuf:"varint,16,rep,packed,name=latency_bounds_us,proto3" json:"latency_bounds_us,omitempty"`
+	// LatencyCounts are the number of commands in each latency bucket,
+	// with one more bucket for latencies exceeding the last bound.
+	LatencyCounts []uint64 `protobuf:"varint,17,rep,packed,name=latency_counts,proto3" json:"latency_counts,omitempty"`
+	LatencyMeanUs uint64   `protobuf:"varint,18,opt,name=latency_mean_us,proto3" json:"latency_mean_us,omitempty"`
+	LatencyMaxUs  uint64   `protobuf:"varint,19,opt,name=latency_max_us,proto3" json:"latency_max_us,omitempty"`
+}
+
+// NewLinkStats creates LinkStats from Client statistics.
+func NewLinkStats(name string, stats comm.ClientStats) *LinkStats {
+	m := &LinkStats{
+		Name:          name,
+		Ready:         stats.FIFO.State.IsReady(),
+		BytesSent:     stats.FIFO.BytesSent,
+		BytesReceived: stats.FIFO.Parser.Bytes,
+		SyncLost:      stats.FIFO.SyncLost,
+		Resyncs:       stats.FIFO.Parser.Resyncs,
+		SeqErrors:     stats.FIFO.Parser.SeqErrors,
+		CRCErrors:     stats.FIFO.Parser.CRCErrors,
+		Commands:      stats.Commands,
+		Replies:       stats.Replies,
+		CommandErrors: stats.CommandErrors,
+		NoReplies:     stats.NoReplies,
+		Timeouts:      stats.Timeouts,
+		Events:        stats.Events,
+		Unhandled:     stats.UnhandledEvents,
+		LatencyCounts: stats.Latency.Counts,
+		LatencyMeanUs: microseconds(stats.Latency.Mean()),
+		LatencyMaxUs:  microseconds(stats.Latency.Max),
+	}
+	for _, bound := range stats.Latency.Bounds {
+		m.LatencyBoundsUs = append(m.LatencyBoundsUs, microseconds(bound))
+	}
+	return m
+}
+
+func microseconds(d time.Duration) uint64 {
+	return uint64(d / time.Microsecond)
+}
+
+// NewMessage implements Message.
+func (m *LinkStats) NewMessage() fx.Message { return &LinkStats{} }
+
+// TypeID implements SerializableMessage.
+func (m *LinkStats) TypeID() uint32 { return LinkStatsEventTypeID }
+
+// Serializable implements SerializableMessage.
+func (m *LinkStats) Serializable() proto.Message { return m }
+
+// ProtoMessage implements proto.Message.
+func (m *LinkStats) ProtoMessage() {}
+
+// Reset implements proto.Message.
+func (m *LinkStats) Reset() { *m = LinkStats{} }
+
+// String implements proto.Message.
+func (m *LinkStats) String() string { return proto.CompactTextString(m) }
+
+// GroupL0 defines the custom group.
+const GroupL0 = msgs.GroupCustom | 0x00010000
+
+// TypeIDs
+const (
+	LinkStatsEventTypeID uint32 = GroupL0 | msgs.TypeIDKindEvent | 0x0000
+)
+
+func init() {
+	msgs.MessageTypes[LinkStatsEventTypeID] = (*LinkStats)(nil)
+}
@@ -0,0 +1,35 @@
+package msgs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l1/msgs"
+)
+
+func TestLinkStatsRoundTrip(t *testing.T) {
+	var stats comm.ClientStats
+	stats.Commands, stats.Replies, stats.Timeouts = 10, 9, 1
+	stats.FIFO.BytesSent = 100
+	stats.Latency.Bounds = []time.Duration{time.Millisecond, 10 * time.Millisecond}
+	stats.Latency.Counts = []uint64{3, 5, 1}
+	stats.Latency.Max = 20 * time.Millisecond
+	msg := NewLinkStats("link0", stats)
+	require.Equal(t, []uint64{1000, 10000}, msg.LatencyBoundsUs)
+	require.Equal(t, uint64(20000), msg.LatencyMaxUs)
+
+	typed, err := msgs.TypedFrom(msg)
+	require.NoError(t, err)
+	require.True(t, typed.IsEvent())
+	data, err := typed.Encode()
+	require.NoError(t, err)
+	decoded, err := msgs.DecodeTyped(data)
+	require.NoError(t, err)
+	require.Equal(t, LinkStatsEventTypeID, decoded.GetTypeId())
+	out, err := decoded.Decode()
+	require.NoError(t, err)
+	require.Equal(t, msg, out)
+}
@@ -0,0 +1,48 @@
+// Package stats reports L0 link statistics to L2.
+package stats
+
+import (
+	"time"
+
+	fx "github.com/robotalks/robo.go/pkg/framework"
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l0/msgs"
+	"github.com/robotalks/robo.go/pkg/l1"
+)
+
+// Reporter is a controller periodically sends statistics of
+// an L0 link as LinkStats events.
+type Reporter struct {
+	// Name identifies the link in LinkStats.
+	Name      string
+	Client    *comm.Client
+	Registrar l1.Registrar
+	Interval  time.Duration
+
+	lastReport time.Time
+}
+
+// NewReporter creates a Reporter reporting every 5 seconds.
+func NewReporter(name string, client *comm.Client, registrar l1.Registrar) *Reporter {
+	return &Reporter{
+		Name:      name,
+		Client:    client,
+		Registrar: registrar,
+		Interval:  5 * time.Second,
+	}
+}
+
+// AddToLoop implements LoopAdder.
+func (r *Reporter) AddToLoop(l *fx.Loop) {
+	l.AddController(fx.PrLvPostProc, r)
+}
+
+// Control implements Controller.
+func (r *Reporter) Control(cc fx.ControlContext) error {
+	now := cc.Time()
+	if !r.lastReport.IsZero() && now.Sub(r.lastReport) < r.Interval {
+		return nil
+	}
+	r.lastReport = now
+	return r.Registrar.SendEvent(cc.Context(), msgs.NewLinkStats(r.Name, r.Client.Stats()))
+}
@@ -0,0 +1,44 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	fx "github.com/robotalks/robo.go/pkg/framework"
+	"github.com/robotalks/robo.go/pkg/l0/comm"
+	"github.com/robotalks/robo.go/pkg/l0/msgs"
+)
+
+type testRegistrar struct {
+	events []fx.Message
+}
+
+func (r *testRegistrar) SendEvent(ctx context.Context, msg fx.Message) error {
+	r.events = append(r.events, msg)
+	return nil
+}
+
+func TestReporterInterval(t *testing.T) {
+	registrar := &testRegistrar{}
+	reporter := NewReporter("link0", comm.NewClient(comm.NewFIFO(nil)), registrar)
+	reporter.Interval = 250 * time.Millisecond
+	loop := fx.NewLoop()
+	loop.Clock = fx.NewSimClock(time.Unix(1000, 0))
+	loop.Add(reporter)
+
+	ctx := context.Background()
+	loop.Step(ctx, 1)
+	require.Len(t, registrar.events, 1)
+	stats, ok := registrar.events[0].(*msgs.LinkStats)
+	require.True(t, ok)
+	require.Equal(t, "link0", stats.Name)
+	require.False(t, stats.Ready)
+
+	loop.Step(ctx, 2)
+	require.Len(t, registrar.events, 1)
+	loop.Step(ctx, 1)
+	require.Len(t, registrar.events, 2)
+}
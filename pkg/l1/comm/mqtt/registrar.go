@@ -3,6 +3,8 @@ package mqtt
 import (
 	"context"
 	"encoding/json"
+	"sync"
+	"time"
 
 	fx "github.com/robotalks/robo.go/pkg/framework"
 	"github.com/robotalks/robo.go/pkg/l1"
@@ -16,6 +18,7 @@ type Registrar struct {
 
 	metaJSON  string
 	registrar comm.Registrar
+	closeOnce sync.Once
 }
 
 // NewRegistrar creates a Registrar.
@@ -51,17 +54,31 @@ func (r *Registrar) SendEvent(ctx context.Context, msg fx.Message) error {
 func (r *Registrar) AddToLoop(loop *fx.Loop) {
 	loop.Add(&r.registrar)
 	loop.AddRunnable(r)
+	loop.OnShutdown(fx.ShutdownCloseTransports, "mqtt registrar", fx.ShutdownFunc(r.close))
 }
 
 // Run implements Runnable.
 func (r *Registrar) Run(ctx context.Context) error {
 	r.Queue.Connect()
 	<-ctx.Done()
-	r.Queue.PubWith(r.Info.Ref.Name()+"/meta", nil, 1, true)
-	r.Queue.Close()
+	r.close(context.Background())
 	return nil
 }
 
+// close clears the meta and disconnects once. It waits for the meta
+// to be published if ctx has a deadline.
+func (r *Registrar) close(ctx context.Context) (err error) {
+	r.closeOnce.Do(func() {
+		token := r.Queue.PubWith(r.Info.Ref.Name()+"/meta", nil, 1, true)
+		if deadline, ok := ctx.Deadline(); ok {
+			token.WaitTimeout(time.Until(deadline))
+			err = token.Error()
+		}
+		r.Queue.Close()
+	})
+	return
+}
+
 func (r *Registrar) onConnected() {
 	r.Queue.PubWith(r.Info.Ref.Name()+"/meta", []byte(r.metaJSON), 1, true)
 }
@@ -15,6 +15,10 @@ type Pipe struct {
 	Handler    msgs.TypedMsgHandler
 
 	sendLock sync.Mutex
+
+	pending int           // sends waiting or being written
+	idleCh  chan struct{} // closed when pending drops to zero
+	lock    sync.Mutex
 }
 
 // NewPipe creates a Pipe with given PacketReadWriter.
@@ -53,11 +57,47 @@ func (p *Pipe) SendTyped(typed *msgs.Typed) error {
 	if err != nil {
 		return err
 	}
+	p.beginSend()
+	defer p.endSend()
 	p.sendLock.Lock()
 	defer p.sendLock.Unlock()
 	return p.ReadWriter.WritePacket(pkt)
 }
 
+// Flush waits until all messages being sent, including the ones waiting
+// for others, are written. It returns ctx.Err() if ctx is done first.
+func (p *Pipe) Flush(ctx context.Context) error {
+	p.lock.Lock()
+	idleCh := p.idleCh
+	p.lock.Unlock()
+	if idleCh == nil {
+		return nil
+	}
+	select {
+	case <-idleCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pipe) beginSend() {
+	p.lock.Lock()
+	if p.pending++; p.pending == 1 {
+		p.idleCh = make(chan struct{})
+	}
+	p.lock.Unlock()
+}
+
+func (p *Pipe) endSend() {
+	p.lock.Lock()
+	if p.pending--; p.pending == 0 {
+		close(p.idleCh)
+		p.idleCh = nil
+	}
+	p.lock.Unlock()
+}
+
 // Run implements Runnable.
 func (p *Pipe) Run(ctx context.Context) error {
 	defer p.Close()
@@ -0,0 +1,58 @@
+package comm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/robotalks/robo.go/pkg/l1/msgs"
+)
+
+// blockingWriter writes packets when released.
+type blockingWriter struct {
+	releaseCh chan struct{}
+	writeCh   chan []byte
+}
+
+func (w *blockingWriter) ReadPacket() ([]byte, error) {
+	select {}
+}
+
+func (w *blockingWriter) WritePacket(pkt []byte) error {
+	<-w.releaseCh
+	w.writeCh <- pkt
+	return nil
+}
+
+func TestPipeFlush(t *testing.T) {
+	w := &blockingWriter{releaseCh: make(chan struct{}), writeCh: make(chan []byte, 2)}
+	p := NewPipe(w)
+	require.NoError(t, p.Flush(context.Background()))
+
+	errCh := make(chan error, 2)
+	for n := 0; n < 2; n++ {
+		go func() {
+			errCh <- p.SendCommandMsg(msgs.NewCommandOK(), 1)
+		}()
+	}
+	for {
+		p.lock.Lock()
+		pending := p.pending
+		p.lock.Unlock()
+		if pending == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, p.Flush(ctx))
+
+	close(w.releaseCh)
+	require.NoError(t, p.Flush(context.Background()))
+	require.Len(t, w.writeCh, 2)
+	require.NoError(t, <-errCh)
+	require.NoError(t, <-errCh)
+}
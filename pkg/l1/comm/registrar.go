@@ -2,6 +2,7 @@ package comm
 
 import (
 	"context"
+	"sync/atomic"
 
 	fx "github.com/robotalks/robo.go/pkg/framework"
 	"github.com/robotalks/robo.go/pkg/l1"
@@ -9,8 +10,11 @@ import (
 )
 
 // Registrar implements Registrar with Pipe and integrated with Loop.
+// During shutdown, it rejects commands with msgs.ErrShuttingDown, and
+// flushes events being sent.
 type Registrar struct {
-	pipe Pipe
+	pipe     Pipe
+	stopping int32
 }
 
 // Init initializes the Registrar with defaults.
@@ -20,6 +24,9 @@ func (r *Registrar) Init(rw PacketReadWriter) {
 		loopCtl := fx.LoopCtlFrom(ctx)
 		switch typed.Kind() {
 		case msgs.TypeIDKindCommand:
+			if atomic.LoadInt32(&r.stopping) != 0 {
+				return r.pipe.SendCommandMsg(msgs.NewCommandErr(msgs.ErrShuttingDown), typed.Sequence)
+			}
 			loopCtl.PostMessage(&l1.CommandMsg{Command: &command{seq: typed.Sequence, msg: msg, pipe: &r.pipe}})
 			loopCtl.TriggerNext()
 		case msgs.TypeIDKindEvent:
@@ -38,6 +45,11 @@ func (r *Registrar) SendEvent(ctx context.Context, msg fx.Message) error {
 // AddToLoop implements LoopAdder.
 func (r *Registrar) AddToLoop(loop *fx.Loop) {
 	loop.Add(&r.pipe)
+	loop.OnShutdown(fx.ShutdownStopCommands, "l1 registrar", fx.ShutdownFunc(func(context.Context) error {
+		atomic.StoreInt32(&r.stopping, 1)
+		return nil
+	}))
+	loop.OnShutdown(fx.ShutdownFlushEvents, "l1 registrar", fx.ShutdownFunc(r.pipe.Flush))
 }
 
 type command struct {
@@ -86,15 +98,14 @@ func (r *RegistrarMux) Add(regs ...l1.Registrar) {
 type UnsupportedCommands struct {
 }
 
+var unsupportedCommands = l1.NewDispatcher().
+	OnAnyCommand(func(fx.ControlContext, fx.Message) (fx.Message, error) {
+		return nil, msgs.ErrUnsupportedCommand
+	})
+
 // Control implements Controller.
 func (c *UnsupportedCommands) Control(cc fx.ControlContext) error {
-	cc.Messages().ProcessMessages(fx.ProcessMessageFunc(func(mctx fx.MessageProcessingContext) {
-		if cmdMsg, ok := mctx.CurrentMessage().(*l1.CommandMsg); ok {
-			mctx.MessageTaken()
-			cmdMsg.Command.Done(msgs.NewCommandErr(msgs.ErrUnsupportedCommand))
-		}
-	}))
-	return nil
+	return unsupportedCommands.Control(cc)
 }
 
 // AddToLoop implements LoopAdder.
@@ -0,0 +1,140 @@
+package l1
+
+import (
+	"fmt"
+	"reflect"
+
+	fx "github.com/robotalks/robo.go/pkg/framework"
+	"github.com/robotalks/robo.go/pkg/l1/msgs"
+)
+
+// CommandFunc handles a command and returns the reply.
+// If the error is not nil, the command is replied with msgs.CommandErr,
+// otherwise, with msgs.CommandOK if the reply is nil, including a typed
+// nil, e.g. a nil *SomeReply.
+type CommandFunc func(fx.ControlContext, fx.Message) (fx.Message, error)
+
+// Dispatcher dispatches messages and commands to handlers by type.
+// Messages and commands with handlers are taken, and all others are left
+// untouched.
+type Dispatcher struct {
+	messages   map[reflect.Type]reflect.Value
+	commands   map[reflect.Type]reflect.Value
+	anyCommand CommandFunc
+}
+
+var (
+	controlContextType = reflect.TypeOf((*fx.ControlContext)(nil)).Elem()
+	messageType        = reflect.TypeOf((*fx.Message)(nil)).Elem()
+	errorType          = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewDispatcher creates a Dispatcher.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		messages: make(map[reflect.Type]reflect.Value),
+		commands: make(map[reflect.Type]reflect.Value),
+	}
+}
+
+// OnMessage registers a handler of messages, which must be in the form of
+//
+//	func(fx.ControlContext, *SomeMessage)
+//
+// It panics if fn is not in the form.
+func (d *Dispatcher) OnMessage(fn interface{}) *Dispatcher {
+	fnVal, msgType := handlerFunc(fn, 0)
+	d.messages[msgType] = fnVal
+	return d
+}
+
+// OnCommand registers a handler of commands, which must be in the form of
+//
+//	func(fx.ControlContext, *SomeCommand) (fx.Message, error)
+//
+// The reply is sent in the same way as CommandFunc.
+// It panics if fn is not in the form.
+func (d *Dispatcher) OnCommand(fn interface{}) *Dispatcher {
+	fnVal, msgType := handlerFunc(fn, 2)
+	d.commands[msgType] = fnVal
+	return d
+}
+
+// OnAnyCommand registers a handler of commands without specific handlers.
+func (d *Dispatcher) OnAnyCommand(fn CommandFunc) *Dispatcher {
+	d.anyCommand = fn
+	return d
+}
+
+// Dispatch processes the messages of current iteration.
+func (d *Dispatcher) Dispatch(cc fx.ControlContext) {
+	cc.Messages().ProcessMessages(fx.ProcessMessageFunc(func(mctx fx.MessageProcessingContext) {
+		msg := mctx.CurrentMessage()
+		cmdMsg, ok := msg.(*CommandMsg)
+		if !ok {
+			if fn, ok := d.messages[reflect.TypeOf(msg)]; ok {
+				mctx.MessageTaken()
+				fn.Call([]reflect.Value{reflect.ValueOf(cc), reflect.ValueOf(msg)})
+			}
+			return
+		}
+		cmd := cmdMsg.Command.Msg()
+		var reply fx.Message
+		var err error
+		if fn, ok := d.commands[reflect.TypeOf(cmd)]; ok {
+			out := fn.Call([]reflect.Value{reflect.ValueOf(cc), reflect.ValueOf(cmd)})
+			reply, _ = out[0].Interface().(fx.Message)
+			err, _ = out[1].Interface().(error)
+		} else if d.anyCommand != nil {
+			reply, err = d.anyCommand(cc, cmd)
+		} else {
+			return
+		}
+		mctx.MessageTaken()
+		if err != nil {
+			reply = msgs.NewCommandErr(err)
+		} else if isNil(reply) {
+			reply = msgs.NewCommandOK()
+		}
+		cmdMsg.Command.Done(reply)
+	}))
+}
+
+// Control implements Controller.
+func (d *Dispatcher) Control(cc fx.ControlContext) error {
+	d.Dispatch(cc)
+	return nil
+}
+
+// isNil checks msg is nil or a typed nil.
+func isNil(msg fx.Message) bool {
+	if msg == nil {
+		return true
+	}
+	v := reflect.ValueOf(msg)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// handlerFunc validates fn takes (fx.ControlContext, fx.Message) and
+// returns (fx.Message, error) if numOut is 2, or nothing.
+func handlerFunc(fn interface{}, numOut int) (reflect.Value, reflect.Type) {
+	fnVal := reflect.ValueOf(fn)
+	fnType := fnVal.Type()
+	valid := fnType.Kind() == reflect.Func &&
+		fnType.NumIn() == 2 &&
+		fnType.In(0) == controlContextType &&
+		fnType.In(1).Implements(messageType) &&
+		fnType.In(1).Kind() != reflect.Interface &&
+		fnType.NumOut() == numOut
+	if valid && numOut == 2 {
+		valid = fnType.Out(0) == messageType && fnType.Out(1) == errorType
+	}
+	if !valid {
+		panic(fmt.Sprintf("invalid handler type %v", fnType))
+	}
+	return fnVal, fnType.In(1)
+}
@@ -0,0 +1,96 @@
+package l1
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	fx "github.com/robotalks/robo.go/pkg/framework"
+	"github.com/robotalks/robo.go/pkg/l1/msgs"
+)
+
+type testCommand struct {
+	msg   fx.Message
+	reply fx.Message
+}
+
+func (c *testCommand) Msg() fx.Message { return c.msg }
+
+func (c *testCommand) Done(reply fx.Message) error {
+	c.reply = reply
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	var drive *msgs.Nav2DDrive
+	d := NewDispatcher().
+		OnCommand(func(cc fx.ControlContext, m *msgs.Nav2DDrive) (fx.Message, error) {
+			drive = m
+			return nil, nil
+		}).
+		OnCommand(func(cc fx.ControlContext, m *msgs.Nav2DTurn) (fx.Message, error) {
+			return nil, errors.New("turn failed")
+		}).
+		OnCommand(func(cc fx.ControlContext, m *msgs.Nav2DCapsQuery) (fx.Message, error) {
+			return &msgs.Nav2DCaps{}, nil
+		}).
+		OnMessage(func(cc fx.ControlContext, m *msgs.CommandOK) {
+			cc.PostMessage(m)
+		})
+
+	cmds := []*testCommand{
+		{msg: &msgs.Nav2DDrive{}},
+		{msg: &msgs.Nav2DTurn{}},
+		{msg: &msgs.Nav2DCapsQuery{}},
+		{msg: &msgs.CommandErr{}},
+	}
+	loop := fx.NewLoop()
+	for _, cmd := range cmds {
+		loop.PostMessage(&CommandMsg{Command: cmd})
+	}
+	loop.PostMessage(&msgs.CommandOK{})
+	loop.PostMessage(&msgs.CommandErr{})
+	var remains []fx.Message
+	loop.AddController(fx.PrLvControl, d)
+	loop.AddController(fx.PrLvIdle, fx.ControlFunc(func(cc fx.ControlContext) error {
+		cc.Messages().ProcessMessages(fx.ProcessMessageFunc(func(mctx fx.MessageProcessingContext) {
+			remains = append(remains, mctx.CurrentMessage())
+		}))
+		return nil
+	}))
+	loop.Step(context.Background(), 1)
+
+	require.Equal(t, cmds[0].msg, drive)
+	require.Equal(t, msgs.NewCommandOK(), cmds[0].reply)
+	require.Equal(t, msgs.NewCommandErrFromMsg("turn failed"), cmds[1].reply)
+	require.Equal(t, &msgs.Nav2DCaps{}, cmds[2].reply)
+	require.Nil(t, cmds[3].reply)
+	require.Len(t, remains, 2)
+	require.Equal(t, &CommandMsg{Command: cmds[3]}, remains[0])
+	require.Equal(t, &msgs.CommandErr{}, remains[1])
+
+	d.OnAnyCommand(func(fx.ControlContext, fx.Message) (fx.Message, error) {
+		return nil, msgs.ErrUnsupportedCommand
+	})
+	loop.PostMessage(&CommandMsg{Command: cmds[3]})
+	loop.Step(context.Background(), 1)
+	require.Equal(t, msgs.NewCommandErr(msgs.ErrUnsupportedCommand), cmds[3].reply)
+
+	// a typed nil reply is replied with CommandOK.
+	d.OnCommand(func(cc fx.ControlContext, m *msgs.Nav2DCapsQuery) (fx.Message, error) {
+		var caps *msgs.Nav2DCaps
+		return caps, nil
+	})
+	loop.PostMessage(&CommandMsg{Command: cmds[2]})
+	loop.Step(context.Background(), 1)
+	require.Equal(t, msgs.NewCommandOK(), cmds[2].reply)
+
+	require.Panics(t, func() {
+		d.OnCommand(func(cc fx.ControlContext, m *msgs.Nav2DDrive) error { return nil })
+	})
+	require.Panics(t, func() {
+		d.OnMessage(func(m *msgs.Nav2DDrive) {})
+	})
+}
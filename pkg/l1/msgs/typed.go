@@ -58,6 +58,8 @@ var (
 	ErrNotSerializable = errors.New("not serializable message")
 	// ErrUnsupportedCommand indicates the command is unsupported.
 	ErrUnsupportedCommand = errors.New("unsupported command")
+	// ErrShuttingDown indicates the command is rejected during shutdown.
+	ErrShuttingDown = errors.New("shutting down")
 )
 
 // SerializableMessage can be serialized over the wire.
@@ -0,0 +1,60 @@
+package l1
+
+import (
+	fx "github.com/robotalks/robo.go/pkg/framework"
+	"github.com/robotalks/robo.go/pkg/l1/msgs"
+)
+
+// TraceCodec is a framework.MessageCodec for serializable messages and
+// CommandMsg of serializable commands, to trace and replay a Loop.
+// The replies of replayed commands are discarded.
+type TraceCodec struct{}
+
+const (
+	traceKindMessage byte = 'M'
+	traceKindCommand byte = 'C'
+)
+
+// EncodeMessage implements framework.MessageCodec.
+func (TraceCodec) EncodeMessage(msg fx.Message) ([]byte, error) {
+	kind := traceKindMessage
+	if cmdMsg, ok := msg.(*CommandMsg); ok {
+		kind, msg = traceKindCommand, cmdMsg.Command.Msg()
+	}
+	typed, err := msgs.TypedFrom(msg)
+	if err != nil {
+		return nil, err
+	}
+	data, err := typed.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{kind}, data...), nil
+}
+
+// DecodeMessage implements framework.MessageCodec.
+func (TraceCodec) DecodeMessage(data []byte) (fx.Message, error) {
+	if len(data) == 0 || (data[0] != traceKindMessage && data[0] != traceKindCommand) {
+		return nil, fx.ErrBadTraceFormat
+	}
+	typed, err := msgs.DecodeTyped(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	msg, err := typed.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if data[0] == traceKindCommand {
+		return &CommandMsg{Command: &replayedCommand{msg: msg}}, nil
+	}
+	return msg, nil
+}
+
+type replayedCommand struct {
+	msg fx.Message
+}
+
+func (c *replayedCommand) Msg() fx.Message { return c.msg }
+
+func (c *replayedCommand) Done(fx.Message) error { return nil }
@@ -15,7 +15,8 @@ type Engine struct {
 	Object sim.Placeable2D
 	Caps   msgs.Nav2DCaps
 
-	state state
+	state    state
+	commands *l1.Dispatcher
 }
 
 type state interface {
@@ -24,7 +25,20 @@ type state interface {
 
 // New creates the engine.
 func New(obj sim.Placeable2D) *Engine {
-	return &Engine{Object: obj}
+	e := &Engine{Object: obj}
+	e.commands = l1.NewDispatcher().
+		OnCommand(func(cc fx.ControlContext, m *msgs.Nav2DCapsQuery) (fx.Message, error) {
+			return e.CapsQuery(cc, m), nil
+		}).
+		OnCommand(func(cc fx.ControlContext, m *msgs.Nav2DDrive) (fx.Message, error) {
+			e.Drive(cc, m)
+			return nil, nil
+		}).
+		OnCommand(func(cc fx.ControlContext, m *msgs.Nav2DTurn) (fx.Message, error) {
+			e.Turn(cc, m)
+			return nil, nil
+		})
+	return e
 }
 
 // CapsQuery executes Nav2DCapsQuery command.
@@ -46,28 +60,12 @@ func (e *Engine) Turn(ctx physics.Context, msg *msgs.Nav2DTurn) {
 func (e *Engine) AddToLoop(l *fx.Loop) {
 	l.AddController(fx.PrLvControl, fx.ControlFunc(e.HandleCommand))
 	l.AddController(fx.PrLvAcuate, fx.ControlFunc(e.Execute))
+	l.AddShutdownController(fx.PrLvAcuate, fx.ControlFunc(e.Stop))
 }
 
 // HandleCommand is a controller processing commands.
 func (e *Engine) HandleCommand(cc fx.ControlContext) error {
-	cc.Messages().ProcessMessages(fx.ProcessMessageFunc(func(mctx fx.MessageProcessingContext) {
-		if cmdMsg, ok := mctx.CurrentMessage().(*l1.CommandMsg); ok {
-			switch m := cmdMsg.Command.Msg().(type) {
-			case *msgs.Nav2DCapsQuery:
-				mctx.MessageTaken()
-				cmdMsg.Command.Done(e.CapsQuery(cc, m))
-			case *msgs.Nav2DDrive:
-				mctx.MessageTaken()
-				e.Drive(cc, m)
-				cmdMsg.Command.Done(msgs.NewCommandOK())
-			case *msgs.Nav2DTurn:
-				mctx.MessageTaken()
-				e.Turn(cc, m)
-				cmdMsg.Command.Done(msgs.NewCommandOK())
-			}
-		}
-	}))
-	return nil
+	return e.commands.Control(cc)
 }
 
 // Execute is a controller for acuation.
@@ -80,6 +78,13 @@ func (e *Engine) Execute(ctx fx.ControlContext) error {
 	return nil
 }
 
+// Stop is a shutdown controller which stops the motion at current pose.
+func (e *Engine) Stop(ctx fx.ControlContext) error {
+	e.estimatePose(ctx)
+	e.state = nil
+	return nil
+}
+
 func (e *Engine) estimatePose(ctx physics.Context) (pose sim.Pose2D) {
 	if s := e.state; s != nil {
 		pose, e.state = s.estimate(ctx.Time())